@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Acknowledge, Resolve and Escalate buttons on forwarded alerts, and the
+// escalation loop that DMs the next person in the rotation when nobody
+// acknowledges in time. Everything is kept on the AlertRecord, which is
+// persisted with the rest of the alert state.
+
+const escalationInterval = 30 * time.Second
+
+// alertServer is the running webhook receiver, nil when it's disabled.
+var alertServer *webhookServer
+
+// updateAlertMessage re-renders the alert's top-level message.
+func (s *webhookServer) updateAlertMessage(rec *AlertRecord) {
+	_, _, _, err := s.api.UpdateMessage(rec.Channel, rec.TS,
+		slack.MsgOptionText(s.renderer.execute(s.renderer.title, rec.Alert), false),
+		slack.MsgOptionBlocks(s.renderer.blocks(rec)...))
+	if err != nil {
+		fmt.Printf("failed updating alert message: %v\n", err)
+	}
+}
+
+func (s *webhookServer) threadReply(rec *AlertRecord, text string) {
+	_, _, err := s.api.PostMessage(rec.Channel, slack.MsgOptionText(text, false), slack.MsgOptionTS(rec.TS))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// escalate DMs the next person in the alert's rotation. by is the user who
+// pressed Escalate, empty when the timeout fired. rec is a copy, it's called
+// without holding alertState.mu.
+func (s *webhookServer) escalate(rec AlertRecord, by string) error {
+	rotation := rec.Escalation.Rotation
+	if rec.Escalation.OnCall != "" {
+		order, ok := currentOnCall(rec.Escalation.OnCall)
+		if !ok {
+			return fmt.Errorf("there's no on-call rotation called %s", rec.Escalation.OnCall)
+		}
+		rotation = order
+	}
+	if len(rotation) == 0 {
+		return fmt.Errorf("no escalation rotation is configured for this alert")
+	}
+
+	// Everyone has been paged once, stop rather than go round again.
+	if rec.EscalationLevel >= len(rotation) {
+		if !rec.EscalationExhausted {
+			s.markExhausted(rec)
+		}
+		return fmt.Errorf("everyone in the rotation has already been paged")
+	}
+
+	userID, err := s.resolveUser(rotation[rec.EscalationLevel])
+	if err != nil {
+		return err
+	}
+
+	channelID, err := openDirectMessage(s.api, userID)
+	if err != nil {
+		return err
+	}
+
+	link, err := s.api.GetPermalink(&slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.TS})
+	if err != nil {
+		link = "<#" + rec.Channel + ">"
+	}
+
+	reason := fmt.Sprintf("nobody acknowledged it within %s", rec.Escalation.Timeout)
+	if by != "" {
+		reason = fmt.Sprintf("<@%s> escalated it", by)
+	}
+
+	text := fmt.Sprintf(":rotating_light: You're up for *%s*, %s.\n%s",
+		s.renderer.execute(s.renderer.title, rec.Alert), reason, link)
+	if _, _, err := s.api.PostMessage(channelID, slack.MsgOptionText(text, false)); err != nil {
+		return err
+	}
+
+	alertState.lock()
+	if stored, ok := alertState.Records[rec.Alert.Fingerprint]; ok {
+		stored.EscalationLevel = rec.EscalationLevel + 1
+		stored.EscalatedTo = userID
+		stored.LastEscalation = time.Now()
+		alertState.save()
+		rec = *stored
+	}
+	alertState.mu.Unlock()
+
+	s.threadReply(&rec, fmt.Sprintf(":rotating_light: Escalated to <@%s>, %s.", userID, reason))
+	s.updateAlertMessage(&rec)
+	return nil
+}
+
+// markExhausted says in the alert's thread that nobody is left to page, once.
+func (s *webhookServer) markExhausted(rec AlertRecord) {
+	alertState.lock()
+	stored, ok := alertState.Records[rec.Alert.Fingerprint]
+	if !ok || stored.EscalationExhausted {
+		alertState.mu.Unlock()
+		return
+	}
+	stored.EscalationExhausted = true
+	alertState.save()
+	alertState.mu.Unlock()
+
+	s.threadReply(&rec, ":warning: Escalation exhausted, everyone in the rotation was paged and nobody acknowledged.")
+}
+
+// resolveUser resolves a rotation entry to a user ID, remembering names so
+// escalations don't list every user in the workspace each time.
+func (s *webhookServer) resolveUser(ref string) (string, error) {
+	s.channelsMutex.Lock()
+	id, ok := s.users[ref]
+	s.channelsMutex.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := resolveUser(s.api, ref)
+	if err != nil {
+		return "", err
+	}
+
+	s.channelsMutex.Lock()
+	s.users[ref] = id
+	s.channelsMutex.Unlock()
+	return id, nil
+}
+
+// runEscalations escalates firing, unacknowledged alerts whose timeout has
+// passed, until stop is closed.
+func (s *webhookServer) runEscalations(stop <-chan struct{}) {
+	ticker := time.NewTicker(escalationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, rec := range dueEscalations(time.Now()) {
+			if err := s.escalate(rec, ""); err != nil {
+				fmt.Printf("failed escalating alert %s: %v\n", rec.Alert.Fingerprint, err)
+			}
+		}
+	}
+}
+
+// dueEscalations returns copies of the firing, unacknowledged alerts whose
+// timeout has passed, for paging after the lock is released. It marks them
+// escalated at now, so one that fails waits for the next timeout rather than
+// being retried every tick.
+func dueEscalations(now time.Time) []AlertRecord {
+	var due []AlertRecord
+	alertState.lock()
+	defer alertState.mu.Unlock()
+
+	for _, rec := range alertState.Records {
+		if rec.Alert.Status != "firing" || rec.AckedBy != "" || rec.TS == "" ||
+			rec.EscalationExhausted || rec.Escalation.Timeout == "" {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(rec.Escalation.Timeout)
+		if err != nil || timeout <= 0 {
+			continue
+		}
+
+		since := rec.FirstSeen
+		if !rec.LastEscalation.IsZero() {
+			since = rec.LastEscalation
+		}
+		if now.Sub(since) < timeout {
+			continue
+		}
+
+		rec.LastEscalation = now
+		due = append(due, *rec)
+	}
+	if len(due) > 0 {
+		alertState.save()
+	}
+	return due
+}
+
+// handleAlertAction handles the alert_ack, alert_resolve and alert_escalate buttons.
+func handleAlertAction(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	user := callback.User.ID
+
+	reply := func(text string) {
+		_, err := client.Client.PostEphemeral(callback.Channel.ID, user, slack.MsgOptionText(text, false))
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+
+	s := alertServer
+	if s == nil {
+		reply("The alert receiver isn't running, so I can't update this alert.")
+		return
+	}
+
+	alertState.lock()
+	rec, ok := alertState.Records[action.Value]
+	if !ok {
+		alertState.mu.Unlock()
+		reply("I don't know about this alert anymore.")
+		return
+	}
+
+	now := time.Now()
+	var note string
+
+	switch action.ActionID {
+	case "alert_ack":
+		if rec.AckedBy != "" {
+			alertState.mu.Unlock()
+			reply(fmt.Sprintf("<@%s> already acknowledged this.", rec.AckedBy))
+			return
+		}
+		rec.AckedBy, rec.AckedAt = user, now
+		note = fmt.Sprintf(":eyes: <@%s> acknowledged.", user)
+
+	case "alert_resolve":
+		if rec.Alert.Status == "resolved" {
+			alertState.mu.Unlock()
+			reply("This alert is already resolved.")
+			return
+		}
+		rec.Alert.Status = "resolved"
+		rec.ResolvedBy, rec.ResolvedAt = user, now
+		note = fmt.Sprintf(":white_check_mark: <@%s> resolved this.", user)
+
+	case "alert_escalate":
+		snapshot := *rec
+		alertState.mu.Unlock()
+		if err := s.escalate(snapshot, user); err != nil {
+			reply("Couldn't escalate: " + err.Error())
+		}
+		return
+
+	default:
+		alertState.mu.Unlock()
+		return
+	}
+
+	alertState.save()
+	snapshot := *rec
+	alertState.mu.Unlock()
+
+	s.threadReply(&snapshot, note)
+	s.updateAlertMessage(&snapshot)
+}
+
+// isAlertAction reports whether a block action belongs to an alert message.
+func isAlertAction(actionID string) bool {
+	return strings.HasPrefix(actionID, "alert_") && actionID != "alert_link"
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// firingAlert stores a posted, firing alert and returns a copy of its record.
+func firingAlert(t *testing.T, title string, escalation EscalationPolicy) AlertRecord {
+	t.Helper()
+	alert := normalizeAlert(Alert{Title: title})
+	rec := &AlertRecord{
+		Alert:      alert,
+		Channel:    "C0000ALERTS",
+		TS:         "1600000000.000001",
+		FirstSeen:  time.Now().Add(-10 * time.Minute),
+		LastSeen:   time.Now(),
+		Count:      1,
+		Escalation: escalation,
+	}
+	alertState.lock()
+	alertState.Records[alert.Fingerprint] = rec
+	alertState.mu.Unlock()
+	return *rec
+}
+
+func storedAlert(fingerprint string) AlertRecord {
+	alertState.lock()
+	defer alertState.mu.Unlock()
+	return *alertState.Records[fingerprint]
+}
+
+func TestEscalateStopsAfterRotation(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+	rec := firingAlert(t, "Checkout down", EscalationPolicy{Timeout: "5m", Rotation: []string{"U0000000A1", "U0000000B2"}})
+
+	steps := []struct {
+		paged     string // who gets the DM, empty when nobody is left
+		level     int
+		exhausted int // "Escalation exhausted" replies so far
+	}{
+		{"U0000000A1", 1, 0},
+		{"U0000000B2", 2, 0},
+		{"", 2, 1},
+		{"", 2, 1},
+	}
+	for i, step := range steps {
+		before := len(fake.texts("chat.postMessage"))
+		err := s.escalate(storedAlert(rec.Alert.Fingerprint), "")
+
+		stored := storedAlert(rec.Alert.Fingerprint)
+		if step.paged != "" {
+			if err != nil {
+				t.Fatalf("escalation %d: %v", i+1, err)
+			}
+			if stored.EscalatedTo != step.paged {
+				t.Errorf("escalation %d paged %s, want %s", i+1, stored.EscalatedTo, step.paged)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "already been paged") {
+			t.Errorf("escalation %d: error %v, want everyone paged", i+1, err)
+		}
+		if stored.EscalationLevel != step.level {
+			t.Errorf("escalation %d: level %d, want %d", i+1, stored.EscalationLevel, step.level)
+		}
+
+		exhausted := 0
+		for _, text := range fake.texts("chat.postMessage") {
+			if strings.Contains(text, "Escalation exhausted") {
+				exhausted++
+			}
+		}
+		if exhausted != step.exhausted {
+			t.Errorf("escalation %d: %d exhausted replies, want %d", i+1, exhausted, step.exhausted)
+		}
+		if step.paged == "" && i == len(steps)-1 {
+			if posts := fake.texts("chat.postMessage")[before:]; len(posts) != 0 {
+				t.Errorf("escalating an exhausted alert posted %q", posts)
+			}
+		}
+	}
+
+	if !storedAlert(rec.Alert.Fingerprint).EscalationExhausted {
+		t.Error("alert not marked exhausted")
+	}
+	if due := dueEscalations(time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Errorf("exhausted alert still due: %+v", due)
+	}
+}
+
+func TestEscalateUsesOnCallRotation(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+	oncall.lock()
+	oncall.Rotations = map[string]*Rotation{
+		"payments": {Name: "payments", Members: []string{"U0000000B2", "U0000000A1"}, Length: "weekly", Handoff: "09:00", Timezone: "UTC", Start: time.Now().Add(-time.Hour)},
+	}
+	oncall.mu.Unlock()
+
+	rec := firingAlert(t, "Checkout down", EscalationPolicy{Timeout: "5m", OnCall: "payments"})
+	if err := s.escalate(rec, "U0000000C3"); err != nil {
+		t.Fatal(err)
+	}
+	if to := storedAlert(rec.Alert.Fingerprint).EscalatedTo; to != "U0000000B2" {
+		t.Errorf("paged %s, want whoever is on call", to)
+	}
+	posts := fake.texts("chat.postMessage")
+	if len(posts) == 0 || !strings.Contains(posts[0], "<@U0000000C3> escalated it") {
+		t.Errorf("DM %q doesn't say who escalated", posts)
+	}
+
+	rec = firingAlert(t, "Billing down", EscalationPolicy{Timeout: "5m", OnCall: "billing"})
+	if err := s.escalate(rec, ""); err == nil || !strings.Contains(err.Error(), "no on-call rotation called billing") {
+		t.Errorf("escalating to a missing rotation: %v", err)
+	}
+}
+
+func TestDueEscalations(t *testing.T) {
+	newTestWebhookServer(t)
+	rotation := []string{"U0000000A1"}
+	now := time.Now()
+
+	due := firingAlert(t, "due", EscalationPolicy{Timeout: "5m", Rotation: rotation})
+	firingAlert(t, "not yet", EscalationPolicy{Timeout: "1h", Rotation: rotation})
+	for _, rec := range []AlertRecord{
+		{Alert: Alert{Title: "acked"}, AckedBy: "U0000000A1"},
+		{Alert: Alert{Title: "resolved", Status: "resolved"}},
+		{Alert: Alert{Title: "not posted"}, TS: ""},
+		{Alert: Alert{Title: "no timeout"}, Escalation: EscalationPolicy{Rotation: rotation}},
+		{Alert: Alert{Title: "bad timeout"}, Escalation: EscalationPolicy{Timeout: "soon", Rotation: rotation}},
+		{Alert: Alert{Title: "exhausted"}, EscalationExhausted: true},
+	} {
+		rec := rec
+		if rec.Alert.Status == "" {
+			rec.Alert.Status = "firing"
+		}
+		if rec.TS == "" && rec.Alert.Title != "not posted" {
+			rec.TS = "1600000000.000002"
+		}
+		if rec.Escalation.Timeout == "" && rec.Alert.Title != "no timeout" {
+			rec.Escalation = EscalationPolicy{Timeout: "5m", Rotation: rotation}
+		}
+		rec.FirstSeen = now.Add(-10 * time.Minute)
+		rec.Alert.Fingerprint = alertFingerprint(rec.Alert)
+		alertState.lock()
+		alertState.Records[rec.Alert.Fingerprint] = &rec
+		alertState.mu.Unlock()
+	}
+
+	got := dueEscalations(now)
+	if len(got) != 1 || got[0].Alert.Fingerprint != due.Alert.Fingerprint {
+		t.Fatalf("due %+v, want only %q", got, due.Alert.Title)
+	}
+	// Not again until another timeout has passed.
+	if got := dueEscalations(now.Add(time.Minute)); len(got) != 0 {
+		t.Errorf("due again a minute later: %+v", got)
+	}
+	if got := dueEscalations(now.Add(6 * time.Minute)); len(got) != 1 {
+		t.Errorf("due %+v a timeout later, want it back", got)
+	}
+}
+
+func TestHandleAlertAction(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+	alertServer = s
+	t.Cleanup(func() { alertServer = nil })
+	client := socketmode.New(s.api)
+
+	rec := firingAlert(t, "Checkout down", EscalationPolicy{Timeout: "5m", Rotation: []string{"U0000000A1"}})
+	press := func(user, actionID, fingerprint string) {
+		var callback slack.InteractionCallback
+		callback.User.ID = user
+		callback.Channel.ID = rec.Channel
+		handleAlertAction(callback, &slack.BlockAction{ActionID: actionID, Value: fingerprint}, client)
+	}
+
+	steps := []struct {
+		user, action, fingerprint string
+		reply                     string // in the thread
+		ephemeral                 string
+	}{
+		{"U0000000A1", "alert_ack", rec.Alert.Fingerprint, "<@U0000000A1> acknowledged", ""},
+		{"U0000000B2", "alert_ack", rec.Alert.Fingerprint, "", "<@U0000000A1> already acknowledged"},
+		{"U0000000B2", "alert_resolve", rec.Alert.Fingerprint, "<@U0000000B2> resolved this", ""},
+		{"U0000000A1", "alert_resolve", rec.Alert.Fingerprint, "", "already resolved"},
+		{"U0000000A1", "alert_ack", "0000000000000000", "", "don't know about this alert"},
+	}
+	for _, step := range steps {
+		posts, ephemerals := len(fake.texts("chat.postMessage")), len(fake.texts("chat.postEphemeral"))
+		press(step.user, step.action, step.fingerprint)
+
+		newPosts := fake.texts("chat.postMessage")[posts:]
+		newEphemerals := fake.texts("chat.postEphemeral")[ephemerals:]
+		if step.reply != "" && (len(newPosts) != 1 || !strings.Contains(newPosts[0], step.reply)) {
+			t.Errorf("%s %s: replied %q, want %q", step.user, step.action, newPosts, step.reply)
+		}
+		if step.reply == "" && len(newPosts) != 0 {
+			t.Errorf("%s %s: replied %q, want nothing", step.user, step.action, newPosts)
+		}
+		if step.ephemeral != "" && (len(newEphemerals) != 1 || !strings.Contains(newEphemerals[0], step.ephemeral)) {
+			t.Errorf("%s %s: told them %q, want %q", step.user, step.action, newEphemerals, step.ephemeral)
+		}
+	}
+
+	stored := storedAlert(rec.Alert.Fingerprint)
+	if stored.AckedBy != "U0000000A1" || stored.AckedAt.IsZero() {
+		t.Errorf("ack not recorded: %+v", stored)
+	}
+	if stored.Alert.Status != "resolved" || stored.ResolvedBy != "U0000000B2" || stored.ResolvedAt.IsZero() {
+		t.Errorf("resolution not recorded: %+v", stored)
+	}
+}
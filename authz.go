@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Authorization for things the bot does on someone's behalf.
+//
+// Anyone may have the bot post in a channel they are a member of, and DM
+// other people unless BOT_RESTRICT_DMS is set; posting in the bot's DM with
+// someone counts as DMing them. Members of an on-call
+// rotation may override and swap its shifts. Admins, listed by user ID in
+// BOT_ADMINS, may do anything. Every path that makes the bot post for a user,
+// typed commands and model tool calls alike, goes through authorize.
+
+const (
+	actionPost   = "post"   // post in a channel, target is the channel ID
+	actionDM     = "dm"     // DM someone, target is their user ID
+	actionOnCall = "oncall" // change who is on call, target is the rotation name
+)
+
+var (
+	botAdmins     = parseUserList(os.Getenv("BOT_ADMINS"))
+	restrictedDMs = os.Getenv("BOT_RESTRICT_DMS") != ""
+	errNotAllowed = fmt.Errorf("you're not allowed to do that")
+	errNotAMember = fmt.Errorf("you can only have me post in channels you're a member of")
+	errDMsLimited = fmt.Errorf("only bot admins can have me message other people")
+	errNotOnCall  = fmt.Errorf("only the rotation's members and bot admins can change who is on call")
+)
+
+func parseUserList(s string) map[string]bool {
+	users := make(map[string]bool)
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			users[u] = true
+		}
+	}
+	return users
+}
+
+func isAdmin(userID string) bool {
+	return botAdmins[userID]
+}
+
+// authorize returns an error saying why userID may not do action to target.
+func authorize(api *slack.Client, userID, action, target string) error {
+	if isAdmin(userID) {
+		return nil
+	}
+
+	switch action {
+	case actionPost:
+		// The bot's DM with the user themselves is always fine, posting in
+		// its DM with someone else is DMing them.
+		if strings.HasPrefix(target, "D") {
+			channel, err := api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: target})
+			if err != nil {
+				return fmt.Errorf("couldn't check the channel: %v", err)
+			}
+			if channel.User == userID {
+				return nil
+			}
+			if channel.User != "" {
+				return authorize(api, userID, actionDM, channel.User)
+			}
+		}
+		ok, err := isMember(api, userID, target)
+		if err != nil {
+			return fmt.Errorf("couldn't check the channel: %v", err)
+		}
+		if !ok {
+			return errNotAMember
+		}
+		return nil
+
+	case actionDM:
+		if restrictedDMs && target != userID {
+			return errDMsLimited
+		}
+		return nil
+
+	case actionOnCall:
+		if !rotationMember(target, userID) {
+			return errNotOnCall
+		}
+		return nil
+	}
+
+	return errNotAllowed
+}
+
+// isMember reports whether userID is in the channel.
+func isMember(api *slack.Client, userID, channelID string) (bool, error) {
+	params := &slack.GetUsersInConversationParameters{ChannelID: channelID, Limit: 1000}
+	for {
+		members, cursor, err := api.GetUsersInConversation(params)
+		if err != nil {
+			return false, err
+		}
+		for _, m := range members {
+			if m == userID {
+				return true, nil
+			}
+		}
+		if cursor == "" {
+			return false, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// replyDenied tells the user why the bot won't do what they asked.
+func replyDenied(api *slack.Client, channelID string, err error) {
+	_, _, postErr := api.PostMessage(channelID, slack.MsgOptionText("Sorry, "+err.Error()+".", false))
+	if postErr != nil {
+		fmt.Printf("failed posting message: %v", postErr)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestAuthorizePostInDM(t *testing.T) {
+	api, fake := newFakeSlack(t)
+	fake.dmUsers["D0000000A1"] = "U0000000A1"
+	fake.dmUsers["D0000000B2"] = "U0000000B2"
+
+	saved := restrictedDMs
+	t.Cleanup(func() { restrictedDMs = saved })
+
+	tests := []struct {
+		restricted bool
+		target     string
+		want       error
+	}{
+		{false, "D0000000A1", nil},
+		{true, "D0000000A1", nil},
+		{false, "D0000000B2", nil},
+		{true, "D0000000B2", errDMsLimited},
+		// Not a DM the bot knows, so a channel they'd have to be in.
+		{true, "D0000000C3", errNotAMember},
+		{false, "C0000000C3", errNotAMember},
+	}
+	for _, tt := range tests {
+		restrictedDMs = tt.restricted
+		if err := authorize(api, "U0000000A1", actionPost, tt.target); err != tt.want {
+			t.Errorf("restricted %v, post in %s: %v, want %v", tt.restricted, tt.target, err, tt.want)
+		}
+	}
+}
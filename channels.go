@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// channelInfo is the row we print for each conversation.
+type channelInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Archived bool      `json:"archived"`
+	Members  int       `json:"members"`
+	Topic    string    `json:"topic"`
+	Purpose  string    `json:"purpose"`
+	Created  time.Time `json:"created"`
+}
+
+func conversationType(channel slack.Channel) string {
+	switch {
+	case channel.IsIM:
+		return "im"
+	case channel.IsMpIM:
+		return "mpim"
+	case channel.IsPrivate:
+		return "private"
+	}
+	return "public"
+}
+
+// typeNames maps our short names to conversations.list types.
+var typeNames = map[string]string{
+	"public":  "public_channel",
+	"private": "private_channel",
+	"im":      "im",
+	"mpim":    "mpim",
+}
+
+// getAllConversations follows the cursor to the end, waiting out rate limits.
+func getAllConversations(api *slack.Client, params *slack.GetConversationsParameters) ([]slack.Channel, error) {
+	var all []slack.Channel
+
+	for {
+		channels, cursor, err := api.GetConversations(params)
+
+		var rateLimited *slack.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			fmt.Fprintf(os.Stderr, "Rate limited, retrying in %s\n", rateLimited.RetryAfter)
+			time.Sleep(rateLimited.RetryAfter)
+			continue
+		}
+		if err != nil {
+			return all, err
+		}
+
+		all = append(all, channels...)
+
+		if cursor == "" {
+			return all, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+func listChannels(api *slack.Client, args []string) error {
+	flags := flag.NewFlagSet("channels list", flag.ExitOnError)
+	types := flags.String("types", "public", "comma separated conversation types: public, private, im, mpim")
+	archived := flags.String("archived", "include", "archived channels: include, exclude or only")
+	nameFilter := flags.String("name", "", "only channels whose name matches this regular expression")
+	topicFilter := flags.String("topic", "", "only channels whose topic or purpose matches this regular expression")
+	format := flags.String("format", "table", "output format: table, csv or json")
+	pageSize := flags.Int("limit", 200, "page size for conversations.list")
+	flags.Parse(args)
+
+	params := &slack.GetConversationsParameters{
+		Limit:           *pageSize,
+		ExcludeArchived: *archived == "exclude",
+	}
+	for _, t := range strings.Split(*types, ",") {
+		name, ok := typeNames[strings.TrimSpace(t)]
+		if !ok {
+			return fmt.Errorf("unknown conversation type %q", t)
+		}
+		params.Types = append(params.Types, name)
+	}
+
+	var nameRe, topicRe *regexp.Regexp
+	var err error
+	if *nameFilter != "" {
+		if nameRe, err = regexp.Compile(*nameFilter); err != nil {
+			return fmt.Errorf("bad -name: %v", err)
+		}
+	}
+	if *topicFilter != "" {
+		if topicRe, err = regexp.Compile(*topicFilter); err != nil {
+			return fmt.Errorf("bad -topic: %v", err)
+		}
+	}
+
+	channels, err := getAllConversations(api, params)
+	if err != nil {
+		return fmt.Errorf("listing channels: %v", err)
+	}
+
+	var rows []channelInfo
+	for _, channel := range channels {
+		if *archived == "only" && !channel.IsArchived {
+			continue
+		}
+
+		name := channel.Name
+		if channel.IsIM {
+			name = "@" + channel.User
+		}
+
+		if nameRe != nil && !nameRe.MatchString(name) {
+			continue
+		}
+		if topicRe != nil && !topicRe.MatchString(channel.Topic.Value) && !topicRe.MatchString(channel.Purpose.Value) {
+			continue
+		}
+
+		rows = append(rows, channelInfo{
+			ID:       channel.ID,
+			Name:     name,
+			Type:     conversationType(channel),
+			Archived: channel.IsArchived,
+			Members:  channel.NumMembers,
+			Topic:    channel.Topic.Value,
+			Purpose:  channel.Purpose.Value,
+			Created:  channel.Created.Time(),
+		})
+	}
+
+	return writeChannels(rows, *format)
+}
+
+func writeChannels(rows []channelInfo, format string) error {
+	var records [][]string
+	for _, r := range rows {
+		topic, purpose := r.Topic, r.Purpose
+		if format == "table" {
+			topic, purpose = truncate(topic, 40), truncate(purpose, 40)
+		}
+		records = append(records, []string{r.ID, r.Name, r.Type, strconv.FormatBool(r.Archived),
+			strconv.Itoa(r.Members), topic, purpose, r.Created.Format(time.RFC3339)})
+	}
+
+	header := []string{"id", "name", "type", "archived", "members", "topic", "purpose", "created"}
+	return writeOutput(format, header, records, rows)
+}
+
+// channelsCommand runs "channels list" (the default) and "channels stale".
+func channelsCommand(args []string) error {
+	if err := cfg.require("bot"); err != nil {
+		return err
+	}
+	api := newSlackClient(false)
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listChannels(api, args[1:])
+		case "stale":
+			return staleChannels(api, args[1:])
+		}
+	}
+	return listChannels(api, args)
+}
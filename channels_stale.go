@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Stale channel report and bulk archive.
+//
+// A dry run (the default) only reports. With -apply, stale channels get a
+// warning message first and are archived on a later run once the grace period
+// has passed without anyone posting. Warnings are remembered in -state so the
+// grace period survives between runs.
+
+type staleState struct {
+	// channel ID -> the warning we posted
+	Warned map[string]staleWarning `json:"warned"`
+}
+
+type staleWarning struct {
+	Timestamp string    `json:"ts"` // message ts of the warning
+	At        time.Time `json:"at"`
+}
+
+type staleRow struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	LastActivity time.Time `json:"last_activity"`
+	DaysInactive int       `json:"days_inactive"`
+	Status       string    `json:"status"` // active, stale, warned, archive, excluded, unknown
+	Note         string    `json:"note,omitempty"`
+}
+
+const defaultWarning = "This channel has had no activity for %d days and will be archived in %d days. " +
+	"Post anything here to keep it."
+
+func loadStaleState(path string) (*staleState, error) {
+	state := &staleState{Warned: make(map[string]staleWarning)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Warned == nil {
+		state.Warned = make(map[string]staleWarning)
+	}
+	return state, nil
+}
+
+func (s *staleState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+// lastMessage returns the newest message in the channel, waiting out rate limits.
+func lastMessage(api *slack.Client, channelID string) (*slack.Message, error) {
+	for {
+		resp, err := api.GetConversationHistory(&slack.GetConversationHistoryParameters{
+			ChannelID: channelID,
+			Limit:     1,
+		})
+
+		var rateLimited *slack.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			fmt.Fprintf(os.Stderr, "Rate limited, retrying in %s\n", rateLimited.RetryAfter)
+			time.Sleep(rateLimited.RetryAfter)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp.Messages) == 0 {
+			return nil, nil
+		}
+		return &resp.Messages[0], nil
+	}
+}
+
+func parseTS(ts string) time.Time {
+	f, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+func staleChannels(api *slack.Client, args []string) error {
+	flags := flag.NewFlagSet("channels stale", flag.ExitOnError)
+	days := flags.Int("days", 90, "channels with no messages for this many days are stale")
+	types := flags.String("types", "public", "comma separated conversation types: public, private")
+	exclude := flags.String("exclude", "general,random", "comma separated channel names or IDs never to touch")
+	format := flags.String("format", "table", "output format: table, csv or json")
+	apply := flags.Bool("apply", false, "post warnings and archive channels whose grace period is over")
+	yes := flags.Bool("yes", false, "don't ask for confirmation with -apply")
+	grace := flags.Int("grace", 7, "days between the warning and archiving")
+	message := flags.String("message", defaultWarning, "warning text, %d placeholders are days inactive and grace days")
+	statePath := flags.String("state", filepath.Join(cfg.DataDir, "stale_channels.json"), "file remembering which channels were warned")
+	flags.Parse(args)
+
+	excluded := make(map[string]bool)
+	for _, e := range strings.Split(*exclude, ",") {
+		if e = strings.TrimPrefix(strings.TrimSpace(e), "#"); e != "" {
+			excluded[e] = true
+		}
+	}
+
+	params := &slack.GetConversationsParameters{Limit: 200, ExcludeArchived: true}
+	for _, t := range strings.Split(*types, ",") {
+		name, ok := typeNames[strings.TrimSpace(t)]
+		if !ok || name == "im" || name == "mpim" {
+			return fmt.Errorf("unsupported conversation type %q", t)
+		}
+		params.Types = append(params.Types, name)
+	}
+
+	state, err := loadStaleState(*statePath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", *statePath, err)
+	}
+
+	channels, err := getAllConversations(api, params)
+	if err != nil {
+		return fmt.Errorf("listing channels: %v", err)
+	}
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -*days)
+	var rows []staleRow
+
+	for _, channel := range channels {
+		row := staleRow{ID: channel.ID, Name: channel.Name}
+
+		if excluded[channel.ID] || excluded[channel.Name] {
+			row.Status = "excluded"
+			rows = append(rows, row)
+			continue
+		}
+
+		msg, err := lastMessage(api, channel.ID)
+		if err != nil {
+			row.Status, row.Note = "unknown", err.Error()
+			rows = append(rows, row)
+			continue
+		}
+
+		row.LastActivity = channel.Created.Time()
+		if msg != nil {
+			row.LastActivity = parseTS(msg.Timestamp)
+		}
+		row.DaysInactive = int(now.Sub(row.LastActivity).Hours() / 24)
+
+		warning, warned := state.Warned[channel.ID]
+		switch {
+		case warned && msg != nil && msg.Timestamp != warning.Timestamp:
+			// Someone posted after our warning, the channel lives on.
+			delete(state.Warned, channel.ID)
+			row.Status = "active"
+			if row.LastActivity.Before(cutoff) {
+				row.Status = "stale"
+			}
+		case warned && now.Sub(warning.At) >= time.Duration(*grace)*24*time.Hour:
+			row.Status, row.Note = "archive", "warned "+warning.At.Format("2006-01-02")
+		case warned:
+			row.Status, row.Note = "warned", "warned "+warning.At.Format("2006-01-02")
+		case row.LastActivity.Before(cutoff):
+			row.Status = "stale"
+		default:
+			row.Status = "active"
+		}
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, k int) bool { return rows[i].DaysInactive > rows[k].DaysInactive })
+
+	if err := writeStaleReport(rows, *format); err != nil {
+		return err
+	}
+
+	toWarn, toArchive := 0, 0
+	for _, r := range rows {
+		switch r.Status {
+		case "stale":
+			toWarn++
+		case "archive":
+			toArchive++
+		}
+	}
+
+	if !*apply {
+		// A dry run leaves the state alone, pruning included.
+		fmt.Fprintf(os.Stderr, "\nDry run: would warn %d and archive %d channels. Re-run with -apply to act.\n", toWarn, toArchive)
+		return nil
+	}
+
+	if toWarn+toArchive == 0 {
+		return state.save(*statePath)
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Warn %d and archive %d channels?", toWarn, toArchive)) {
+		fmt.Fprintln(os.Stderr, "Aborted.")
+		return nil
+	}
+
+	var failed int
+	for _, r := range rows {
+		switch r.Status {
+		case "stale":
+			text := *message
+			if strings.Count(text, "%d") == 2 {
+				text = fmt.Sprintf(text, r.DaysInactive, *grace)
+			}
+			_, ts, err := api.PostMessage(r.ID, slack.MsgOptionText(text, false))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed warning #%s: %v\n", r.Name, err)
+				failed++
+				continue
+			}
+			state.Warned[r.ID] = staleWarning{Timestamp: ts, At: now}
+			fmt.Fprintf(os.Stderr, "Warned #%s\n", r.Name)
+
+		case "archive":
+			if err := api.ArchiveConversation(r.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed archiving #%s: %v\n", r.Name, err)
+				failed++
+				continue
+			}
+			delete(state.Warned, r.ID)
+			fmt.Fprintf(os.Stderr, "Archived #%s\n", r.Name)
+		}
+	}
+
+	if err := state.save(*statePath); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d channels failed", failed)
+	}
+	return nil
+}
+
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func writeStaleReport(rows []staleRow, format string) error {
+	var records [][]string
+	for _, r := range rows {
+		last := ""
+		if !r.LastActivity.IsZero() {
+			last = r.LastActivity.Format("2006-01-02")
+		}
+		records = append(records, []string{r.ID, r.Name, last, strconv.Itoa(r.DaysInactive), r.Status, r.Note})
+	}
+
+	header := []string{"id", "name", "last_activity", "days_inactive", "status", "note"}
+	return writeOutput(format, header, records, rows)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Config is everything the subcommands read from the environment.
+type Config struct {
+	AppToken    string // SLACK_APP_TOKEN, socket mode only
+	BotToken    string // SLACK_BOT_TOKEN
+	OpenAIToken string // OPENAI_API_KEY
+	DataDir     string // SLACK_BOT_DATA_DIR, state that survives restarts
+	Debug       bool   // SLACK_BOT_DEBUG, log Slack API traffic
+}
+
+var cfg = loadConfig()
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envFloat reads a number from the environment, fallback if unset or invalid.
+func envFloat(key string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
+
+// envDuration reads a duration like "24h" from the environment, fallback if
+// unset or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+func loadConfig() Config {
+	return Config{
+		AppToken:    os.Getenv("SLACK_APP_TOKEN"),
+		BotToken:    os.Getenv("SLACK_BOT_TOKEN"),
+		OpenAIToken: os.Getenv("OPENAI_API_KEY"),
+		DataDir:     envOrDefault("SLACK_BOT_DATA_DIR", "data"),
+		Debug:       envOrDefault("SLACK_BOT_DEBUG", "true") != "false",
+	}
+}
+
+// credential describes one of the tokens and how to recognize it.
+type credential struct {
+	name   string
+	env    string
+	prefix string
+	value  func(Config) string
+}
+
+var credentials = map[string]credential{
+	"app":    {"app token", "SLACK_APP_TOKEN", "xapp-", func(c Config) string { return c.AppToken }},
+	"bot":    {"bot token", "SLACK_BOT_TOKEN", "xoxb-", func(c Config) string { return c.BotToken }},
+	"openai": {"OpenAI key", "OPENAI_API_KEY", "sk-", func(c Config) string { return c.OpenAIToken }},
+}
+
+// require checks the named credentials ("app", "bot", "openai") are present
+// and look like the right kind of token.
+func (c Config) require(names ...string) error {
+	for _, name := range names {
+		cred := credentials[name]
+		value := cred.value(c)
+		if value == "" {
+			return fmt.Errorf("%s must be set", cred.env)
+		}
+		if !strings.HasPrefix(value, cred.prefix) {
+			return fmt.Errorf("%s must have the prefix %q", cred.env, cred.prefix)
+		}
+	}
+	return nil
+}
+
+// newSlackClient builds the bot's Web API client. Socket mode additionally
+// needs the app-level token.
+func newSlackClient(debug bool) *slack.Client {
+	options := []slack.Option{}
+	if debug {
+		options = append(options,
+			slack.OptionDebug(true),
+			slack.OptionLog(log.New(os.Stdout, "api: ", log.Lshortfile|log.LstdFlags)),
+		)
+	}
+	if cfg.AppToken != "" {
+		options = append(options, slack.OptionAppLevelToken(cfg.AppToken))
+	}
+	return slack.New(cfg.BotToken, options...)
+}
+
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: slack-bot config check [-offline]")
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "only check the settings are present, don't call Slack or OpenAI")
+	flags.Parse(args[1:])
+
+	failed := 0
+	report := func(ok bool, format string, a ...interface{}) {
+		status := "ok  "
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("[%s] %s\n", status, fmt.Sprintf(format, a...))
+	}
+
+	for _, name := range []string{"app", "bot", "openai"} {
+		cred := credentials[name]
+		err := cfg.require(name)
+		report(err == nil, "%s: %s", cred.env, describeCheck(err, cred.value(cfg)))
+	}
+
+	report(true, "SLACK_BOT_DATA_DIR: %s", cfg.DataDir)
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		report(false, "data directory is not writable: %v", err)
+	}
+
+	if !*offline {
+		if cfg.require("bot") == nil {
+			auth, err := newSlackClient(false).AuthTest()
+			if err != nil {
+				report(false, "Slack auth.test: %v", err)
+			} else {
+				report(true, "Slack auth.test: %s in %s", auth.User, auth.Team)
+			}
+		}
+
+		if cfg.require("openai") == nil {
+			_, err := openai.NewClient(cfg.OpenAIToken).ListModels(context.Background())
+			report(err == nil, "OpenAI models.list: %v", describeCheck(err, "reachable"))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+func describeCheck(err error, value string) string {
+	if err != nil {
+		return err.Error()
+	}
+	if len(value) > 10 && strings.Contains(value, "-") {
+		// Don't print secrets, the prefix is enough to tell tokens apart.
+		return value[:strings.Index(value, "-")+1] + "…"
+	}
+	return value
+}
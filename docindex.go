@@ -0,0 +1,432 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// An on-disk vector index of local documents.
+//
+// Each collection is a gob file under cfg.DataDir/index holding the chunks of
+// every indexed file with their embeddings. Collections are small enough to
+// search by brute force, a dot product per chunk, so there is no separate
+// index structure to keep in sync.
+
+const (
+	indexDir = "index"
+
+	// Target chunk size, in characters. Chunks break at paragraphs.
+	chunkChars   = 1500
+	chunkOverlap = 200
+)
+
+var indexExtensions = map[string]bool{
+	".md": true, ".markdown": true, ".txt": true, ".html": true, ".htm": true,
+}
+
+type Collection struct {
+	Name    string              `json:"name"`
+	Roots   []string            `json:"roots"` // paths given to "index", for reindex
+	Files   map[string]FileInfo `json:"files"`
+	Chunks  []Chunk             `json:"chunks"`
+	Updated time.Time           `json:"updated"`
+}
+
+type FileInfo struct {
+	Hash    string    `json:"hash"`
+	Indexed time.Time `json:"indexed"`
+}
+
+type Chunk struct {
+	Source string    `json:"source"` // file path
+	Line   int       `json:"line"`   // first line of the chunk
+	Title  string    `json:"title"`  // nearest heading
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+func collectionPath(name string) string {
+	return filepath.Join(cfg.DataDir, indexDir, name+".gob")
+}
+
+func loadCollection(name string) (*Collection, error) {
+	c := &Collection{Name: name, Files: make(map[string]FileInfo)}
+
+	f, err := os.Open(collectionPath(name))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("reading collection %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// save writes the collection through a temp file, like saveJSON.
+func (c *Collection) save() error {
+	path := collectionPath(c.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SearchResult is a chunk and its similarity to the query, 1 is identical.
+type SearchResult struct {
+	Chunk
+	Score float32
+}
+
+// Search returns the k chunks closest to the query vector.
+func (c *Collection) Search(query []float32, k int) []SearchResult {
+	results := make([]SearchResult, 0, len(c.Chunks))
+	for _, chunk := range c.Chunks {
+		if len(chunk.Vector) != len(query) {
+			continue // embedded with a different model
+		}
+		var score float32
+		for i, x := range chunk.Vector {
+			score += x * query[i]
+		}
+		results = append(results, SearchResult{Chunk: chunk, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// collectionCache keeps loaded collections, reloading them when the file on
+// disk changes so "slack-bot index" is picked up by a running bot.
+var collectionCache = struct {
+	sync.Mutex
+	loaded map[string]*Collection
+	mtime  map[string]time.Time
+}{loaded: make(map[string]*Collection), mtime: make(map[string]time.Time)}
+
+func cachedCollection(name string) (*Collection, error) {
+	collectionCache.Lock()
+	defer collectionCache.Unlock()
+
+	info, err := os.Stat(collectionPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := collectionCache.loaded[name]; ok && collectionCache.mtime[name].Equal(info.ModTime()) {
+		return c, nil
+	}
+
+	c, err := loadCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	collectionCache.loaded[name] = c
+	collectionCache.mtime[name] = info.ModTime()
+	return c, nil
+}
+
+//---
+
+var (
+	headingRe  = regexp.MustCompile(`^#{1,6}\s+(.+)`)
+	scriptRe   = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	blockTagRe = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|h[1-6]|tr|table|section|article|pre)[^>]*>`)
+	htmlTagRe  = regexp.MustCompile(`<[^>]+>`)
+	htmlHRe    = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]>`)
+)
+
+// documentText returns the text of a file, with HTML turned into plain text
+// and headings marked up Markdown style so chunks can carry a title.
+func documentText(path string, data []byte) string {
+	text := string(data)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		text = scriptRe.ReplaceAllString(text, "")
+		text = htmlHRe.ReplaceAllString(text, "\n\n# $1\n\n")
+		text = blockTagRe.ReplaceAllString(text, "\n")
+		text = htmlTagRe.ReplaceAllString(text, "")
+		text = html.UnescapeString(text)
+	}
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// chunkDocument splits text at paragraph breaks into chunks of about
+// chunkChars, each starting with the tail of the previous one.
+func chunkDocument(source, text string) []Chunk {
+	var chunks []Chunk
+	var b strings.Builder
+	title, start, line := "", 1, 1
+
+	flush := func(next int) {
+		body := strings.TrimSpace(b.String())
+		if body != "" {
+			chunks = append(chunks, Chunk{Source: source, Line: start, Title: title, Text: body})
+		}
+		b.Reset()
+		if len(body) > chunkOverlap {
+			i := len(body) - chunkOverlap
+			for i < len(body) && !utf8.RuneStart(body[i]) {
+				i++
+			}
+			tail := body[i:]
+			if i := strings.IndexAny(tail, " \n"); i >= 0 {
+				tail = tail[i+1:]
+			}
+			b.WriteString(tail + "\n")
+		}
+		start = next
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if m := headingRe.FindStringSubmatch(strings.TrimSpace(paragraph)); m != nil {
+			// A new section starts a new chunk.
+			if strings.TrimSpace(b.String()) != "" {
+				flush(line)
+				b.Reset()
+			}
+			title = strings.TrimSpace(m[1])
+		}
+
+		if b.Len()+len(paragraph) > chunkChars && strings.TrimSpace(b.String()) != "" {
+			flush(line)
+		}
+		for len(paragraph) > chunkChars {
+			head, rest := cutText(paragraph, chunkChars)
+			b.WriteString(head)
+			paragraph = rest
+			flush(line)
+		}
+		b.WriteString(paragraph + "\n\n")
+		line += strings.Count(paragraph, "\n") + 2
+	}
+	flush(line)
+
+	return chunks
+}
+
+// cutText splits s at most max bytes in, at the last line break or space
+// if there is one, and never inside a UTF-8 character.
+func cutText(s string, max int) (string, string) {
+	if len(s) <= max {
+		return s, ""
+	}
+	if i := strings.LastIndexAny(s[:max], "\n "); i > 0 {
+		return s[:i+1], s[i+1:]
+	}
+	i := max
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	if i == 0 {
+		i = max
+	}
+	return s[:i], s[i:]
+}
+
+// indexPaths adds the documents under paths to the collection, skipping
+// files that haven't changed and dropping files that are gone.
+func (c *Collection) indexPaths(paths []string) (added, removed int, err error) {
+	seen := make(map[string]bool)
+	var pending []Chunk
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !indexExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			seen[path] = true
+
+			sum := sha256.Sum256(data)
+			hash := hex.EncodeToString(sum[:])
+			if c.Files[path].Hash == hash {
+				return nil
+			}
+
+			c.dropSource(path)
+			pending = append(pending, chunkDocument(path, documentText(path, data))...)
+			c.Files[path] = FileInfo{Hash: hash, Indexed: time.Now()}
+			added++
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Files under the roots that no longer exist
+	for path := range c.Files {
+		if !seen[path] && underAny(path, paths) {
+			c.dropSource(path)
+			delete(c.Files, path)
+			removed++
+		}
+	}
+
+	texts := make([]string, len(pending))
+	for i, chunk := range pending {
+		texts[i] = chunk.Title + "\n" + chunk.Text
+	}
+	vectors, err := embed(texts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("embedding: %v", err)
+	}
+	for i := range pending {
+		pending[i].Vector = vectors[i]
+	}
+	c.Chunks = append(c.Chunks, pending...)
+
+	for _, root := range paths {
+		c.Roots = appendUnique(c.Roots, root)
+	}
+	c.Updated = time.Now()
+	return added, removed, nil
+}
+
+func (c *Collection) dropSource(path string) {
+	kept := c.Chunks[:0]
+	for _, chunk := range c.Chunks {
+		if chunk.Source != path {
+			kept = append(kept, chunk)
+		}
+	}
+	c.Chunks = kept
+}
+
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+//---
+
+// indexCommand implements "slack-bot index [-collection name] paths...".
+func indexCommand(args []string) error {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	name := flags.String("collection", "default", "collection to add the documents to")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: slack-bot index [-collection name] <file or directory>...")
+	}
+	if os.Getenv("LLM_PROVIDER") != "fake" {
+		if err := cfg.require("openai"); err != nil {
+			return err
+		}
+	}
+
+	c, err := loadCollection(*name)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, p := range flags.Args() {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+
+	added, removed, err := c.indexPaths(paths)
+	if err != nil {
+		return err
+	}
+	if err := c.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: indexed %d changed files, removed %d, %d files and %d chunks in total\n",
+		c.Name, added, removed, len(c.Files), len(c.Chunks))
+	return nil
+}
+
+// reindexCommand implements "slack-bot reindex [-collection name]", which
+// rebuilds a collection from scratch, e.g. after changing embedding model.
+func reindexCommand(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	name := flags.String("collection", "", "collection to rebuild, all of them if empty")
+	flags.Parse(args)
+
+	if os.Getenv("LLM_PROVIDER") != "fake" {
+		if err := cfg.require("openai"); err != nil {
+			return err
+		}
+	}
+
+	names := []string{*name}
+	if *name == "" {
+		matches, _ := filepath.Glob(filepath.Join(cfg.DataDir, indexDir, "*.gob"))
+		names = names[:0]
+		for _, m := range matches {
+			names = append(names, strings.TrimSuffix(filepath.Base(m), ".gob"))
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("there are no collections, create one with \"slack-bot index\"")
+	}
+
+	for _, n := range names {
+		old, err := loadCollection(n)
+		if err != nil {
+			return err
+		}
+
+		c := &Collection{Name: n, Files: make(map[string]FileInfo)}
+		added, _, err := c.indexPaths(old.Roots)
+		if err != nil {
+			return fmt.Errorf("%s: %v", n, err)
+		}
+		if err := c.save(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: reindexed %d files, %d chunks\n", n, added, len(c.Chunks))
+	}
+	return nil
+}
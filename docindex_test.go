@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkDocumentUTF8(t *testing.T) {
+	tests := map[string]string{
+		"one long paragraph without spaces": strings.Repeat("日本語のテキスト", 400),
+		"words":                             strings.Repeat("naïve café résumé ", 300),
+		"emoji":                             strings.Repeat("🚀", 1000) + "\n\n" + strings.Repeat("ü", 2000),
+		"paragraphs":                        strings.Repeat("Grüße aus München. "+strings.Repeat("ß", 150)+"\n\n", 40),
+	}
+
+	for name, text := range tests {
+		chunks := chunkDocument("doc.md", text)
+		if len(chunks) < 2 {
+			t.Errorf("%s: got %d chunks, want the text split", name, len(chunks))
+		}
+		for i, c := range chunks {
+			if !utf8.ValidString(c.Text) || strings.ContainsRune(c.Text, utf8.RuneError) {
+				t.Errorf("%s: chunk %d isn't valid UTF-8: %q", name, i, c.Text[:20])
+			}
+			if len(c.Text) > chunkChars+chunkOverlap+2 {
+				t.Errorf("%s: chunk %d is %d bytes", name, i, len(c.Text))
+			}
+		}
+	}
+}
+
+func TestCutText(t *testing.T) {
+	tests := []struct {
+		s          string
+		max        int
+		head, rest string
+	}{
+		{"short", 10, "short", ""},
+		{"hello world again", 10, "hello ", "world again"},
+		{"line one\nline two", 12, "line one\n", "line two"},
+		{"äöü", 3, "ä", "öü"},
+		{"日本語", 4, "日", "本語"},
+	}
+	for _, tt := range tests {
+		head, rest := cutText(tt.s, tt.max)
+		if head != tt.head || rest != tt.rest {
+			t.Errorf("cutText(%q, %d) = %q, %q; want %q, %q", tt.s, tt.max, head, rest, tt.head, tt.rest)
+		}
+	}
+}
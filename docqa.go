@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Questions about uploaded files.
+//
+// Drop a log, text file, CSV or PDF into a DM with the bot, or into a
+// thread while mentioning it, and its text is kept with that thread. Later
+// questions in the thread are answered from the file, citing line numbers
+// or pages, with the thread's earlier questions and answers as context.
+// Files are forgotten DOCUMENT_RETENTION (default 24h) after the thread was
+// last used.
+//
+// Each thread is a gob file under cfg.DataDir/documents, rewritten whenever
+// the thread is used, so its modification time is when it was last used.
+
+const (
+	documentsDir = "documents"
+
+	// Characters of a file that go into one prompt.
+	docContextBudget = 12000
+	// Characters per chunk.
+	docChunkSize = 2500
+	// Questions and answers of the thread remembered for context.
+	docHistory = 10
+)
+
+var (
+	documentRetention = envDuration("DOCUMENT_RETENTION", 24*time.Hour)
+	documentMaxBytes  = int(envFloat("DOCUMENT_MAX_BYTES", 10<<20))
+)
+
+// Extensions read as plain text, besides text/* types.
+var textExtensions = map[string]bool{
+	".txt": true, ".log": true, ".csv": true, ".tsv": true, ".md": true, ".json": true, ".yaml": true,
+	".yml": true, ".xml": true, ".ini": true, ".conf": true, ".cfg": true, ".out": true, ".err": true,
+}
+
+type DocChunk struct {
+	Ref    string    `json:"ref"` // "lines 10-80" or "page 3"
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector,omitempty"`
+}
+
+type Document struct {
+	Name   string     `json:"name"`
+	Length string     `json:"length"` // "120 lines", "12 pages"
+	Chunks []DocChunk `json:"chunks"`
+}
+
+// DocThread is a conversation about files.
+type DocThread struct {
+	Channel   string                         `json:"channel"`
+	ThreadTS  string                         `json:"thread_ts"`
+	Documents []*Document                    `json:"documents"`
+	History   []openai.ChatCompletionMessage `json:"history,omitempty"`
+}
+
+// documentsMu serializes reading, changing and writing back a thread.
+var documentsMu sync.Mutex
+
+func docThreadPath(channelID, threadTS string) string {
+	return filepath.Join(cfg.DataDir, documentsDir, channelID+"-"+threadTS+".gob")
+}
+
+// loadDocThread reads a thread's files, nil if it has none.
+func loadDocThread(channelID, threadTS string) (*DocThread, error) {
+	f, err := os.Open(docThreadPath(channelID, threadTS))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &DocThread{}
+	if err := gob.NewDecoder(f).Decode(t); err != nil {
+		return nil, fmt.Errorf("reading the files of thread %s: %v", threadTS, err)
+	}
+	return t, nil
+}
+
+// save writes the thread through a temp file, like saveJSON.
+func (t *DocThread) save() error {
+	path := docThreadPath(t.Channel, t.ThreadTS)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(t); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o600)
+}
+
+// hasDocumentThread reports whether files were shared in the thread.
+func hasDocumentThread(channelID, threadTS string) bool {
+	_, err := os.Stat(docThreadPath(channelID, threadTS))
+	return err == nil
+}
+
+// runDocumentRetention forgets files once their thread has been idle for
+// the retention period.
+func runDocumentRetention(stop <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		paths, _ := filepath.Glob(filepath.Join(cfg.DataDir, documentsDir, "*.gob"))
+		expired := 0
+		documentsMu.Lock()
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || time.Since(info.ModTime()) <= documentRetention {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				fmt.Printf("failed removing %s: %v\n", path, err)
+				continue
+			}
+			expired++
+		}
+		documentsMu.Unlock()
+		if expired > 0 {
+			fmt.Printf("forgot the files of %d threads\n", expired)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//---
+
+// sharedFile is what we need of a file, from an event or a fetched message.
+type sharedFile struct {
+	Name     string
+	Mimetype string
+	Size     int
+	URL      string
+}
+
+func newSharedFile(name, mimetype string, size int, download, private string) sharedFile {
+	if download == "" {
+		download = private
+	}
+	return sharedFile{name, mimetype, size, download}
+}
+
+func eventFiles(files []slackevents.File) []sharedFile {
+	shared := make([]sharedFile, len(files))
+	for i, f := range files {
+		shared[i] = newSharedFile(f.Name, f.Mimetype, f.Size, f.URLPrivateDownload, f.URLPrivate)
+	}
+	return shared
+}
+
+func messageFiles(files []slack.File) []sharedFile {
+	shared := make([]sharedFile, len(files))
+	for i, f := range files {
+		shared[i] = newSharedFile(f.Name, f.Mimetype, f.Size, f.URLPrivateDownload, f.URLPrivate)
+	}
+	return shared
+}
+
+func isPDF(f sharedFile) bool {
+	return f.Mimetype == "application/pdf" || strings.EqualFold(filepath.Ext(f.Name), ".pdf")
+}
+
+func isTextFile(f sharedFile) bool {
+	return strings.HasPrefix(f.Mimetype, "text/") || f.Mimetype == "application/json" ||
+		textExtensions[strings.ToLower(filepath.Ext(f.Name))]
+}
+
+// isDocument reports whether we can read the file's text.
+func isDocument(f sharedFile) bool {
+	return isPDF(f) || isTextFile(f)
+}
+
+func hasDocuments(files []sharedFile) bool {
+	for _, f := range files {
+		if isDocument(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// readDocument extracts and chunks a file's text.
+func readDocument(f sharedFile, data []byte) (*Document, error) {
+	doc := &Document{Name: f.Name}
+
+	if isPDF(f) {
+		pages, err := pdfPages(data)
+		if err != nil {
+			return nil, err
+		}
+		for i, page := range pages {
+			for _, text := range chunkLines(strings.Split(page, "\n"), docChunkSize) {
+				doc.Chunks = append(doc.Chunks, DocChunk{Ref: fmt.Sprintf("page %d", i+1), Text: text})
+			}
+		}
+		doc.Length = fmt.Sprintf("%d pages", len(pages))
+		return doc, nil
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, fmt.Errorf("it doesn't look like text")
+	}
+
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	ext := strings.ToLower(filepath.Ext(f.Name))
+	csv := ext == ".csv" || ext == ".tsv" || f.Mimetype == "text/csv"
+
+	// Chunks by lines, a CSV's header is repeated in each so columns stay named.
+	var b strings.Builder
+	start := 1
+	flush := func(end int) {
+		if b.Len() > 0 {
+			text := b.String()
+			if csv && start > 1 {
+				text = lines[0] + "\n" + text
+			}
+			doc.Chunks = append(doc.Chunks, DocChunk{Ref: fmt.Sprintf("lines %d-%d", start, end), Text: text})
+			b.Reset()
+		}
+	}
+	for i, line := range lines {
+		prefix := fmt.Sprintf("%d: ", i+1)
+		// A line longer than a chunk goes on in pieces with the same number.
+		for continued := false; ; continued = true {
+			piece, rest := cutText(line, docChunkSize-len(prefix)-1)
+			if b.Len() > 0 && b.Len()+len(prefix)+len(piece)+1 > docChunkSize {
+				if continued {
+					flush(i + 1)
+				} else {
+					flush(i)
+				}
+				start = i + 1
+			}
+			b.WriteString(prefix + piece + "\n")
+			if rest == "" {
+				break
+			}
+			line = rest
+		}
+	}
+	flush(len(lines))
+
+	if csv {
+		doc.Length = fmt.Sprintf("%d rows", len(lines)-1)
+	} else {
+		doc.Length = fmt.Sprintf("%d lines", len(lines))
+	}
+	return doc, nil
+}
+
+// attachDocuments reads the files and keeps them with the thread. It returns
+// what it read and why it skipped the rest.
+func attachDocuments(api *slack.Client, channelID, threadTS string, files []sharedFile) ([]*Document, []string) {
+	var read []*Document
+	var skipped []string
+
+	for _, f := range files {
+		if !isDocument(f) {
+			continue
+		}
+		if f.Size > documentMaxBytes {
+			skipped = append(skipped, fmt.Sprintf("%s is over the %d MB limit", f.Name, documentMaxBytes>>20))
+			continue
+		}
+
+		var b bytes.Buffer
+		if err := api.GetFile(f.URL, &b); err != nil {
+			fmt.Printf("failed downloading %s: %v\n", f.Name, err)
+			skipped = append(skipped, fmt.Sprintf("%s couldn't be downloaded", f.Name))
+			continue
+		}
+
+		doc, err := readDocument(f, b.Bytes())
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s couldn't be read: %v", f.Name, err))
+			continue
+		}
+
+		texts := make([]string, len(doc.Chunks))
+		for i, c := range doc.Chunks {
+			texts[i] = c.Text
+		}
+		if vectors, err := embed(texts); err == nil {
+			for i := range doc.Chunks {
+				doc.Chunks[i].Vector = vectors[i]
+			}
+		} else {
+			fmt.Printf("failed embedding %s, keyword matching only: %v\n", f.Name, err)
+		}
+		read = append(read, doc)
+	}
+
+	if len(read) > 0 {
+		documentsMu.Lock()
+		t, err := loadDocThread(channelID, threadTS)
+		if err != nil {
+			fmt.Printf("failed loading documents: %v\n", err)
+		}
+		if t == nil {
+			t = &DocThread{Channel: channelID, ThreadTS: threadTS}
+		}
+		t.Documents = append(t.Documents, read...)
+		if err := t.save(); err != nil {
+			fmt.Printf("failed saving documents: %v\n", err)
+		}
+		documentsMu.Unlock()
+	}
+	return read, skipped
+}
+
+// docExcerpt is a chunk picked for a prompt.
+type docExcerpt struct {
+	doc, chunk int
+	score      float32
+}
+
+// selectExcerpts picks the chunks to show the model: everything when it
+// fits, otherwise the ones closest to the question, in document order.
+func selectExcerpts(docs []*Document, question string) []docExcerpt {
+	var all []docExcerpt
+	total := 0
+	for d, doc := range docs {
+		for c, chunk := range doc.Chunks {
+			all = append(all, docExcerpt{doc: d, chunk: c})
+			total += len(chunk.Text)
+		}
+	}
+	if total <= docContextBudget {
+		return all
+	}
+
+	var vector []float32
+	if vectors, err := embed([]string{question}); err == nil {
+		vector = vectors[0]
+	}
+	words := keywords(question)
+
+	for i, e := range all {
+		chunk := docs[e.doc].Chunks[e.chunk]
+		if vector != nil && len(vector) == len(chunk.Vector) {
+			for k, x := range vector {
+				all[i].score += x * chunk.Vector[k]
+			}
+		} else {
+			all[i].score = keywordScore(words, keywords(chunk.Text))
+		}
+	}
+	sort.SliceStable(all, func(i, k int) bool { return all[i].score > all[k].score })
+
+	var picked []docExcerpt
+	used := 0
+	for _, e := range all {
+		size := len(docs[e.doc].Chunks[e.chunk].Text)
+		if used+size > docContextBudget {
+			continue
+		}
+		picked = append(picked, e)
+		used += size
+	}
+	sort.Slice(picked, func(i, k int) bool {
+		if picked[i].doc != picked[k].doc {
+			return picked[i].doc < picked[k].doc
+		}
+		return picked[i].chunk < picked[k].chunk
+	})
+	return picked
+}
+
+// answerFromDocuments answers a question about the thread's files.
+func answerFromDocuments(api *slack.Client, userID, channelID, threadTS, question string) (*Persona, string) {
+	persona := personaFor(api, userID, channelID, "")
+
+	documentsMu.Lock()
+	t, err := loadDocThread(channelID, threadTS)
+	documentsMu.Unlock()
+	if err != nil {
+		fmt.Printf("failed loading documents: %v\n", err)
+	}
+	if t == nil {
+		return persona, "I don't have any files for this thread any more, please share them again."
+	}
+	docs := t.Documents
+	history := t.History
+
+	var b strings.Builder
+	for _, e := range selectExcerpts(docs, question) {
+		chunk := docs[e.doc].Chunks[e.chunk]
+		fmt.Fprintf(&b, "--- %s, %s ---\n%s\n", docs[e.doc].Name, chunk.Ref, chunk.Text)
+	}
+
+	system := "Answer questions about the files the user shared, using only the excerpts below. " +
+		"Cite where each fact comes from like [app.log lines 120-180] or [report.pdf page 3]; text file lines " +
+		"are numbered, cite the exact lines. Say so if the excerpts don't contain the answer.\n\n" + b.String()
+
+	messages := append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: question})
+	resp, err := llm.CreateChatCompletion(context.Background(), persona.request(system, messages...))
+	if err != nil {
+		return persona, "ResponseError: " + err.Error()
+	}
+	if len(resp.Choices) == 0 {
+		return persona, "ResponseError: the model returned no answer"
+	}
+	answer := resp.Choices[0].Message.Content
+
+	// Reload, files may have been added while the model was answering.
+	documentsMu.Lock()
+	if t, err := loadDocThread(channelID, threadTS); err == nil && t != nil {
+		t.History = append(t.History,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: question},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: answer})
+		if len(t.History) > docHistory {
+			t.History = t.History[len(t.History)-docHistory:]
+		}
+		if err := t.save(); err != nil {
+			fmt.Printf("failed saving documents: %v\n", err)
+		}
+	}
+	documentsMu.Unlock()
+
+	return persona, answer
+}
+
+// shareDocuments reads files shared in a thread and answers the question
+// that came with them, or says what it read.
+func shareDocuments(api *slack.Client, userID, channelID, threadTS, question string, files []sharedFile) {
+	if strings.TrimSpace(question) != "" {
+		var ok bool
+		if question, ok = screen(api, userID, channelID, question); !ok {
+			return
+		}
+	}
+	read, skipped := attachDocuments(api, channelID, threadTS, files)
+
+	note := ""
+	if len(skipped) > 0 {
+		note = "\n_Skipped: " + strings.Join(skipped, "; ") + "._"
+	}
+
+	var options []slack.MsgOption
+	switch {
+	case len(read) == 0:
+		options = []slack.MsgOption{slack.MsgOptionText("I couldn't read any of those files."+note, false)}
+	case strings.TrimSpace(question) == "":
+		names := make([]string, len(read))
+		for i, doc := range read {
+			names[i] = fmt.Sprintf("%s (%s)", doc.Name, doc.Length)
+		}
+		text := fmt.Sprintf("I've read %s. Ask me about it in this thread, I'll keep it for %s after the last question.%s",
+			strings.Join(names, ", "), documentRetention, note)
+		options = []slack.MsgOption{slack.MsgOptionText(text, false)}
+	default:
+		persona, answer := answerFromDocuments(api, userID, channelID, threadTS, question)
+		options = personaReply(persona, answer+note)
+	}
+
+	options = append(options, slack.MsgOptionTS(threadTS))
+	if _, _, err := api.PostMessage(channelID, options...); err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// replyFromDocuments answers a follow-up question in a thread with files.
+func replyFromDocuments(api *slack.Client, userID, channelID, threadTS, question string) {
+	question, ok := screen(api, userID, channelID, question)
+	if !ok {
+		return
+	}
+	persona, answer := answerFromDocuments(api, userID, channelID, threadTS, question)
+	options := append(personaReply(persona, answer), slack.MsgOptionTS(threadTS))
+	if _, _, err := api.PostMessage(channelID, options...); err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// handleDocumentMessage handles a DM with files attached, or a question in
+// a DM thread that has them. It reports whether it did.
+func handleDocumentMessage(ev *slackevents.MessageEvent, client *socketmode.Client) bool {
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+
+	if files := eventFiles(ev.Files); hasDocuments(files) {
+		shareDocuments(&client.Client, ev.User, ev.Channel, threadTS, ev.Text, files)
+		return true
+	}
+	if ev.ThreadTimeStamp != "" && hasDocumentThread(ev.Channel, ev.ThreadTimeStamp) {
+		replyFromDocuments(&client.Client, ev.User, ev.Channel, ev.ThreadTimeStamp, ev.Text)
+		return true
+	}
+	return false
+}
+
+// handleDocumentMention handles a mention with files attached, or one in a
+// thread that has them. It reports whether it did.
+func handleDocumentMention(ev *slackevents.AppMentionEvent, files []sharedFile, client *socketmode.Client) bool {
+	api := &client.Client
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+	question := strings.TrimSpace(userMentionRe.ReplaceAllString(ev.Text, ""))
+
+	if hasDocuments(files) {
+		shareDocuments(api, ev.User, ev.Channel, threadTS, question, files)
+		return true
+	}
+	if ev.ThreadTimeStamp != "" && question != "" && hasDocumentThread(ev.Channel, ev.ThreadTimeStamp) {
+		replyFromDocuments(api, ev.User, ev.Channel, ev.ThreadTimeStamp, question)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReadDocumentSplitsLongLines(t *testing.T) {
+	long := strings.Repeat("é", docChunkSize) // twice a chunk, no spaces
+	text := "first\n" + long + "\nlast\n"
+
+	tests := []struct {
+		name string
+		file sharedFile
+		data []byte
+		refs []string
+	}{
+		{
+			name: "text",
+			file: sharedFile{Name: "notes.txt"},
+			data: []byte(text),
+			refs: []string{"lines 1-1", "lines 2-2", "lines 2-2", "lines 2-3"},
+		},
+		{
+			name: "PDF",
+			file: sharedFile{Name: "notes.pdf"},
+			data: testSimplePDF("BT (first) Tj T* (" + strings.Repeat("x", 2*docChunkSize) + ") Tj T* (last) Tj ET"),
+			refs: []string{"page 1", "page 1", "page 1", "page 1"},
+		},
+	}
+	for _, tt := range tests {
+		doc, err := readDocument(tt.file, tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var refs []string
+		var all strings.Builder
+		for _, c := range doc.Chunks {
+			refs = append(refs, c.Ref)
+			if len(c.Text) > docChunkSize+1 {
+				t.Errorf("%s: %s is %d bytes", tt.name, c.Ref, len(c.Text))
+			}
+			if !utf8.ValidString(c.Text) {
+				t.Errorf("%s: %s splits a character", tt.name, c.Ref)
+			}
+			all.WriteString(c.Text)
+		}
+		if strings.Join(refs, ", ") != strings.Join(tt.refs, ", ") {
+			t.Errorf("%s: chunks %q, want %q", tt.name, refs, tt.refs)
+		}
+		if !strings.Contains(all.String(), "last") {
+			t.Errorf("%s: lost the text after the long line", tt.name)
+		}
+	}
+
+	// Every piece of the long line keeps its number.
+	doc, _ := readDocument(sharedFile{Name: "notes.txt"}, []byte(text))
+	var pieces string
+	for _, c := range doc.Chunks {
+		for _, line := range strings.Split(strings.TrimSuffix(c.Text, "\n"), "\n") {
+			if strings.HasPrefix(line, "2: ") {
+				pieces += strings.TrimPrefix(line, "2: ")
+			}
+		}
+	}
+	if pieces != long {
+		t.Errorf("line 2 comes back as %d bytes, want %d", len(pieces), len(long))
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// A knowledge base learned from reactions.
+//
+// Reacting with FAQ_REACTION (default :bookmark:) to a helpful reply in a
+// thread saves the thread's question and that reply. When a similar question
+// is asked later the saved answer is suggested first, with a button to ask
+// the model instead. Answers from a channel are only suggested in that
+// channel, and in DMs when the channel is public.
+
+const (
+	faqFile           = "faq.json"
+	faqFallbackAction = "faq_ask_llm"
+
+	// Keyword overlap needed when embeddings aren't available.
+	faqMinKeywordScore = 0.6
+)
+
+var (
+	faqReaction = envOrDefault("FAQ_REACTION", "bookmark")
+	faqMinScore = float32(envFloat("FAQ_MIN_SCORE", 0.85))
+)
+
+type FAQEntry struct {
+	ID         string    `json:"id"`
+	Channel    string    `json:"channel"`
+	Public     bool      `json:"public"`
+	Question   string    `json:"question"`
+	Answer     string    `json:"answer"`
+	AnswerTS   string    `json:"answer_ts"`
+	AnswerUser string    `json:"answer_user"`
+	SavedBy    string    `json:"saved_by"`
+	Link       string    `json:"link"`
+	Vector     []float32 `json:"vector,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+type faqStore struct {
+	jsonStore
+	Entries []*FAQEntry `json:"entries"`
+}
+
+var faqs = &faqStore{}
+
+// lock loads the store on first use and locks it.
+func (s *faqStore) lock() {
+	s.lockJSON(faqFile, "FAQ", s, nil)
+}
+
+// save must be called with s.mu held.
+func (s *faqStore) save() {
+	s.saveLocked(faqFile, "FAQ", s)
+}
+
+//---
+
+// handleFAQReaction saves a thread reply and its question when someone
+// reacts to the reply with the FAQ emoji.
+func handleFAQReaction(ev *slackevents.ReactionAddedEvent, client *socketmode.Client) {
+	if ev.Reaction != faqReaction || ev.Item.Type != "message" {
+		return
+	}
+	api := &client.Client
+
+	reply := func(text string) {
+		_, err := api.PostEphemeral(ev.Item.Channel, ev.User, slack.MsgOptionText(text, false))
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+
+	answer, err := findMessage(api, ev.Item.Channel, ev.Item.Timestamp)
+	if err != nil {
+		fmt.Printf("failed fetching FAQ answer: %v\n", err)
+		return
+	}
+	if answer.ThreadTimestamp == "" || answer.ThreadTimestamp == answer.Timestamp {
+		reply(fmt.Sprintf("React with :%s: to the reply that answers a thread's question to save it to the FAQ.", faqReaction))
+		return
+	}
+
+	question, err := findMessage(api, ev.Item.Channel, answer.ThreadTimestamp)
+	if err != nil {
+		fmt.Printf("failed fetching FAQ question: %v\n", err)
+		return
+	}
+
+	entry := &FAQEntry{
+		ID:         newID(),
+		Channel:    ev.Item.Channel,
+		Question:   question.Text,
+		Answer:     answer.Text,
+		AnswerTS:   answer.Timestamp,
+		AnswerUser: answer.User,
+		SavedBy:    ev.User,
+		Created:    time.Now(),
+	}
+
+	if channel, err := api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: ev.Item.Channel}); err == nil {
+		entry.Public = !channel.IsPrivate && !channel.IsIM && !channel.IsMpIM
+	}
+	if link, err := api.GetPermalink(&slack.PermalinkParameters{Channel: ev.Item.Channel, Ts: answer.Timestamp}); err == nil {
+		entry.Link = link
+	}
+	if vectors, err := embed([]string{entry.Question}); err == nil {
+		entry.Vector = vectors[0]
+	} else {
+		fmt.Printf("failed embedding FAQ question, keyword matching only: %v\n", err)
+	}
+
+	faqs.lock()
+	for i, e := range faqs.Entries {
+		if e.Channel == entry.Channel && e.AnswerTS == entry.AnswerTS {
+			// Saved before, refresh it in place.
+			entry.ID = e.ID
+			faqs.Entries = append(faqs.Entries[:i], faqs.Entries[i+1:]...)
+			break
+		}
+	}
+	faqs.Entries = append(faqs.Entries, entry)
+	faqs.save()
+	faqs.mu.Unlock()
+
+	reply("Saved to the FAQ, I'll suggest this answer when someone asks something similar.")
+}
+
+// faqMatch returns the saved entry that best answers the question, if any
+// is close enough. The question is only embedded when there are entries the
+// channel could match.
+func faqMatch(channelID, question string) *FAQEntry {
+	dm := strings.HasPrefix(channelID, "D")
+
+	faqs.lock()
+	var candidates []*FAQEntry
+	for _, e := range faqs.Entries {
+		if e.Channel == channelID || (dm && e.Public) {
+			candidates = append(candidates, e)
+		}
+	}
+	faqs.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var vector []float32
+	if vectors, err := embed([]string{question}); err == nil {
+		vector = vectors[0]
+	}
+	words := keywords(question)
+
+	var best *FAQEntry
+	var bestScore float32
+	for _, e := range candidates {
+		var score float32
+		if vector != nil && len(vector) == len(e.Vector) {
+			for i, x := range vector {
+				score += x * e.Vector[i]
+			}
+			if score < faqMinScore {
+				continue
+			}
+		} else {
+			score = keywordScore(words, keywords(e.Question))
+			if score < faqMinKeywordScore {
+				continue
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "do": true, "does": true, "i": true, "we": true,
+	"you": true, "to": true, "of": true, "in": true, "on": true, "for": true, "and": true, "or": true, "it": true,
+	"how": true, "what": true, "where": true, "when": true, "can": true, "my": true, "our": true, "with": true,
+}
+
+// keywords returns the distinct significant words of a question.
+func keywords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !stopWords[w] && len(w) > 1 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// keywordScore is the share of the question's keywords found in the saved one.
+func keywordScore(query, saved map[string]bool) float32 {
+	if len(query) < 2 {
+		return 0
+	}
+	found := 0
+	for w := range query {
+		if saved[w] {
+			found++
+		}
+	}
+	return float32(found) / float32(len(query))
+}
+
+// faqBlocks suggests a saved answer, with a button to ask the model instead.
+func faqBlocks(e *FAQEntry, question string) []slack.Block {
+	text := fmt.Sprintf("This was answered before:\n>%s\n\n%s", truncate(e.Question, 300), e.Answer)
+	if e.AnswerUser != "" {
+		text += fmt.Sprintf("\n— <@%s>", e.AnswerUser)
+	}
+	if e.Link != "" {
+		text += fmt.Sprintf(" (<%s|original thread>)", e.Link)
+	}
+
+	button := slack.NewButtonBlockElement(faqFallbackAction, truncate(question, 2000),
+		slack.NewTextBlockObject(slack.PlainTextType, "Not what I asked, ask the AI", false, false))
+
+	return []slack.Block{
+		// Sections hold 3000 characters, the message text keeps the whole answer.
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, truncateText(text, 3000), false, false), nil, nil),
+		slack.NewActionBlock("", button),
+	}
+}
+
+// answerQuestion posts the FAQ suggestion for the question if there is one,
+// and otherwise lets the model answer or act. threadTS may be empty.
+func answerQuestion(api *slack.Client, userID, channelID, threadTS, question string) {
+	question, ok := screen(api, userID, channelID, question)
+	if !ok {
+		return
+	}
+
+	options := []slack.MsgOption{}
+	if threadTS != "" {
+		options = append(options, slack.MsgOptionTS(threadTS))
+	}
+
+	if e := faqMatch(channelID, question); e != nil {
+		options = append(options, slack.MsgOptionText(e.Answer, false), slack.MsgOptionBlocks(faqBlocks(e, question)...))
+	} else {
+		options = append(options, assist(api, userID, channelID, question)...)
+	}
+
+	if _, _, err := api.PostMessage(channelID, options...); err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// handleFAQMention suggests a saved answer to a question asked with a
+// mention. It reports whether there was one.
+func handleFAQMention(ev *slackevents.AppMentionEvent, client *socketmode.Client) bool {
+	question := strings.TrimSpace(userMentionRe.ReplaceAllString(ev.Text, ""))
+	if question == "" {
+		return false
+	}
+	e := faqMatch(ev.Channel, question)
+	if e == nil {
+		return false
+	}
+
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+	_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionTS(threadTS),
+		slack.MsgOptionText(e.Answer, false), slack.MsgOptionBlocks(faqBlocks(e, question)...))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+	return true
+}
+
+// handleFAQFallback answers with the model when the suggested answer didn't help.
+func handleFAQFallback(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	options := assist(&client.Client, callback.User.ID, callback.Channel.ID, action.Value)
+	if ts := callback.Message.ThreadTimestamp; ts != "" {
+		options = append(options, slack.MsgOptionTS(ts))
+	}
+	if _, _, err := client.Client.PostMessage(callback.Channel.ID, options...); err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
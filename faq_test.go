@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+)
+
+// countingEmbedder counts embedding requests and fails them, so matching
+// falls back to keywords.
+type countingEmbedder struct {
+	LLMProvider
+	calls int
+}
+
+func (p *countingEmbedder) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	p.calls++
+	return openai.EmbeddingResponse{}, errors.New("no embeddings in tests")
+}
+
+func TestFAQMatch(t *testing.T) {
+	cfg.DataDir = t.TempDir()
+	faqs.Entries = nil
+	faqs.loaded = sync.Once{}
+
+	saved := llm
+	counter := &countingEmbedder{}
+	llm = counter
+	t.Cleanup(func() { llm = saved })
+
+	// No entries anywhere: no embedding call.
+	if e := faqMatch("C0000000001", "how do I reset the staging database?"); e != nil || counter.calls != 0 {
+		t.Fatalf("faqMatch with no entries = %v after %d embedding calls", e, counter.calls)
+	}
+
+	faqs.lock()
+	faqs.Entries = []*FAQEntry{
+		{ID: "1", Channel: "C0000000001", Question: "How do I reset the staging database?", Answer: "Run make reset-staging.", Public: true},
+		{ID: "2", Channel: "C0000000002", Question: "Where is the VPN config?", Answer: "In the wiki."},
+	}
+	faqs.mu.Unlock()
+
+	// Entries only in other channels: still no embedding call.
+	if e := faqMatch("C0000000003", "how do I reset the staging database?"); e != nil || counter.calls != 0 {
+		t.Fatalf("faqMatch in a channel without entries = %v after %d embedding calls", e, counter.calls)
+	}
+
+	tests := []struct {
+		channel, question, want string
+	}{
+		{"C0000000001", "how can we reset the staging database", "1"},
+		{"C0000000001", "what's for lunch", ""},
+		// DMs see public entries only.
+		{"D0000000001", "reset staging database please", "1"},
+		{"D0000000001", "where is the vpn config", ""},
+		{"C0000000002", "vpn config where", "2"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if e := faqMatch(tt.channel, tt.question); e != nil {
+			got = e.ID
+		}
+		if got != tt.want {
+			t.Errorf("faqMatch(%s, %q) = %q, want %q", tt.channel, tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestFAQBlocksFitSlack(t *testing.T) {
+	e := &FAQEntry{Question: "How do I deploy?", Answer: "Run it.\n" + strings.Repeat("é", 4000), AnswerUser: "U0000000A1"}
+	blocks := faqBlocks(e, "how do I deploy")
+	text := blocks[0].(*slack.SectionBlock).Text.Text
+	if n := utf8.RuneCountInString(text); n > 3000 {
+		t.Errorf("section text has %d characters", n)
+	}
+	if !strings.Contains(text, "\n\nRun it.\n") {
+		t.Errorf("section text lost its line breaks: %.60q", text)
+	}
+}
@@ -2,9 +2,9 @@ module slack-bot
 
 go 1.18
 
-require github.com/slack-go/slack v0.12.2
-
 require (
-	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/sashabaranov/go-openai v1.14.1 // indirect
+	github.com/sashabaranov/go-openai v1.14.1
+	github.com/slack-go/slack v0.12.2
 )
+
+require github.com/gorilla/websocket v1.4.2 // indirect
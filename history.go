@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// conversationHistory returns the channel's messages since oldest, oldest
+// first, following pagination and waiting out rate limits.
+func conversationHistory(api *slack.Client, channelID string, oldest time.Time) ([]slack.Message, error) {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Limit:     200,
+	}
+	if !oldest.IsZero() {
+		params.Oldest = strconv.FormatInt(oldest.Unix(), 10)
+	}
+
+	var messages []slack.Message
+	for {
+		resp, err := api.GetConversationHistory(params)
+
+		var rateLimited *slack.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			fmt.Printf("Rate limited, retrying in %s\n", rateLimited.RetryAfter)
+			time.Sleep(rateLimited.RetryAfter)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+
+	sort.Slice(messages, func(i, k int) bool { return parseTSFloat(messages[i].Timestamp) < parseTSFloat(messages[k].Timestamp) })
+	return messages, nil
+}
+
+// findMessage fetches a single message, top-level or in a thread.
+func findMessage(api *slack.Client, channelID, ts string) (*slack.Message, error) {
+	resp, err := api.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Latest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Messages) == 1 && resp.Messages[0].Timestamp == ts {
+		return &resp.Messages[0], nil
+	}
+
+	// Not top-level, conversations.replies finds thread replies by their own ts.
+	replies, _, _, err := api.GetConversationReplies(&slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: ts,
+		Latest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range replies {
+		if m.Timestamp == ts {
+			return &m, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found in %s", ts, channelID)
+}
+
+func parseTSFloat(ts string) float64 {
+	f, _ := strconv.ParseFloat(ts, 64)
+	return f
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Image generation, with /imagine <prompt> or "imagine <prompt>" in a DM.
+// IMAGE_MODEL and IMAGE_SIZE pick the model and size. Images go through llm,
+// so they share the chat rate limits and are counted in the usage.
+
+var (
+	imageModel = envOrDefault("IMAGE_MODEL", "dall-e-3")
+	imageSize  = envOrDefault("IMAGE_SIZE", "1024x1024")
+)
+
+var imagineRe = regexp.MustCompile(`(?is)^\s*imagine\s+(.+)$`)
+
+// imaginePrompt returns the prompt of an "imagine ..." message.
+func imaginePrompt(text string) (string, bool) {
+	m := imagineRe.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	return strings.TrimSpace(m[1]), true
+}
+
+// imagine generates an image and uploads it to the channel, in the thread
+// if threadTS is set.
+func imagine(api *slack.Client, userID, channelID, threadTS, prompt string) error {
+	prompt, ok := screen(api, userID, channelID, prompt)
+	if !ok {
+		return nil
+	}
+
+	img, err := llm.GenerateImage(context.Background(), ImageGeneration{
+		Model:  imageModel,
+		Prompt: prompt,
+		Size:   imageSize,
+		User:   userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	comment := fmt.Sprintf("<@%s> imagined: %s", userID, prompt)
+	if img.RevisedPrompt != "" && img.RevisedPrompt != prompt {
+		comment += "\n_Revised prompt: " + img.RevisedPrompt + "_"
+	}
+
+	_, err = api.UploadFileV2(slack.UploadFileV2Parameters{
+		Reader:          bytes.NewReader(img.PNG),
+		FileSize:        len(img.PNG),
+		Filename:        "imagine.png",
+		Title:           truncate(prompt, 200),
+		AltTxt:          truncate(prompt, 1000),
+		InitialComment:  comment,
+		Channel:         channelID,
+		ThreadTimestamp: threadTS,
+	})
+	return err
+}
+
+// handleImagineMessage answers "imagine ..." in a DM.
+func handleImagineMessage(ev *slackevents.MessageEvent, client *socketmode.Client, prompt string) {
+	if err := imagine(&client.Client, ev.User, ev.Channel, "", prompt); err != nil {
+		fmt.Printf("failed imagining: %v\n", err)
+		_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText("Sorry, I couldn't make that image: "+err.Error(), false))
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+}
+
+func handleImagineCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleImagineCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	prompt := strings.TrimSpace(cmd.Text)
+	if prompt == "" {
+		client.Ack(*evt.Request, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          "Tell me what to draw, like `/imagine a lighthouse made of cheese`.",
+		})
+		return
+	}
+
+	// Images take longer than Slack waits for the command.
+	client.Ack(*evt.Request, map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          "Imagining...",
+	})
+
+	if err := imagine(&client.Client, cmd.UserID, cmd.ChannelID, "", prompt); err != nil {
+		fmt.Printf("failed imagining: %v\n", err)
+		text := "Sorry, I couldn't make that image: " + err.Error()
+		if strings.Contains(err.Error(), "not_in_channel") {
+			text = "Sorry, I need to be added to this channel to post images here."
+		}
+		err = slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{ResponseType: "ephemeral", Text: text})
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Incident channels.
+//
+// /incident <title> creates a channel named inc-<date>-<slug>, invites the
+// responders, sets the topic and pins a status message that is kept up to
+// date as the incident progresses. Reacting to a message in the channel with
+// the timeline emoji adds it to the incident's timeline, and /incident resolve
+// drafts a postmortem from the timeline and the channel history.
+//
+// Environment:
+//
+//	INCIDENT_PREFIX     channel name prefix, default "inc"
+//	INCIDENT_REACTION   timeline emoji, default "pushpin"
+//	INCIDENT_CHANNEL    where to announce new incidents, optional
+//	INCIDENT_ONCALL     /oncall rotation whose on-call person is always invited, optional
+
+const (
+	incidentsFile = "incidents.json"
+
+	// Keep the postmortem prompt well inside the model's context window.
+	maxTranscriptChars = 24000
+)
+
+var (
+	incidentPrefix   = envOrDefault("INCIDENT_PREFIX", "inc")
+	incidentReaction = envOrDefault("INCIDENT_REACTION", "pushpin")
+)
+
+var incidentStatuses = []string{"investigating", "identified", "monitoring", "resolved"}
+
+type Incident struct {
+	Channel    string          `json:"channel"`
+	Name       string          `json:"name"`
+	Title      string          `json:"title"`
+	Severity   string          `json:"severity,omitempty"`
+	Status     string          `json:"status"`
+	Summary    string          `json:"summary,omitempty"` // latest status note
+	Commander  string          `json:"commander"`
+	Responders []string        `json:"responders"`
+	StatusTS   string          `json:"status_ts"` // the pinned status message
+	Created    time.Time       `json:"created"`
+	Resolved   time.Time       `json:"resolved,omitempty"`
+	Timeline   []TimelineEntry `json:"timeline"`
+}
+
+type TimelineEntry struct {
+	At   time.Time `json:"at"`
+	User string    `json:"user"`
+	Text string    `json:"text"`
+	TS   string    `json:"ts,omitempty"` // the message, for entries added by reaction
+}
+
+type incidentStore struct {
+	jsonStore
+	Incidents map[string]*Incident `json:"incidents"` // by channel ID
+}
+
+var incidents = &incidentStore{Incidents: make(map[string]*Incident)}
+
+// lock loads the store on first use and locks it.
+func (s *incidentStore) lock() {
+	s.lockJSON(incidentsFile, "incidents", s, nil)
+}
+
+// save must be called with s.mu held.
+func (s *incidentStore) save() {
+	s.saveLocked(incidentsFile, "incidents", s)
+}
+
+//---
+
+var (
+	severityRe = regexp.MustCompile(`(?i)^sev[0-9]$`)
+	slugRe     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// incidentChannelName builds inc-20261018-checkout-errors, within Slack's
+// 80 character limit.
+func incidentChannelName(title string, now time.Time) string {
+	slug := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	name := incidentPrefix + "-" + now.Format("20060102")
+	if slug != "" {
+		name += "-" + slug
+	}
+	if len(name) > 80 {
+		name = strings.TrimRight(name[:80], "-")
+	}
+	return name
+}
+
+// startIncident creates the incident channel. text is what follows
+// /incident: the title, with responders and an optional severity mixed in.
+func startIncident(api *slack.Client, userID, text string) (*Incident, error) {
+	inc := &Incident{
+		Status:     "investigating",
+		Commander:  userID,
+		Responders: []string{userID},
+		Created:    time.Now(),
+	}
+
+	var title []string
+	for _, word := range strings.Fields(text) {
+		switch {
+		case userMentionRe.MatchString(word):
+			inc.Responders = appendUnique(inc.Responders, userMentionRe.FindStringSubmatch(word)[1])
+		case userGroupRe.MatchString(word):
+			members, err := api.GetUserGroupMembers(userGroupRe.FindStringSubmatch(word)[1])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't list the user group: %v", err)
+			}
+			for _, m := range members {
+				inc.Responders = appendUnique(inc.Responders, m)
+			}
+		case severityRe.MatchString(word):
+			inc.Severity = strings.ToUpper(word)
+		default:
+			title = append(title, word)
+		}
+	}
+	inc.Title = strings.Join(title, " ")
+	if inc.Title == "" {
+		return nil, fmt.Errorf("give the incident a title, e.g. `/incident checkout errors sev2 @alice`")
+	}
+
+	// Page in whoever is on call for INCIDENT_ONCALL, if set.
+	if rotation := os.Getenv("INCIDENT_ONCALL"); rotation != "" {
+		if order, ok := currentOnCall(rotation); ok {
+			inc.Responders = appendUnique(inc.Responders, order[0])
+		}
+	}
+
+	inc.Name = incidentChannelName(inc.Title, inc.Created)
+	channel, err := api.CreateConversation(slack.CreateConversationParams{ChannelName: inc.Name})
+	if err != nil && err.Error() == "name_taken" {
+		inc.Name = strings.TrimRight(fmt.Sprintf("%.75s", inc.Name), "-") + "-" + newID()[:4]
+		channel, err = api.CreateConversation(slack.CreateConversationParams{ChannelName: inc.Name})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create #%s: %v", inc.Name, err)
+	}
+	inc.Channel = channel.ID
+
+	if _, err := api.InviteUsersToConversation(inc.Channel, inc.Responders...); err != nil {
+		fmt.Printf("failed inviting responders to %s: %v\n", inc.Name, err)
+	}
+
+	if _, err := api.SetTopicOfConversation(inc.Channel, inc.topic()); err != nil {
+		fmt.Printf("failed setting topic of %s: %v\n", inc.Name, err)
+	}
+
+	_, ts, err := api.PostMessage(inc.Channel, slack.MsgOptionText(inc.Title, false), slack.MsgOptionBlocks(inc.statusBlocks()...))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't post the status message: %v", err)
+	}
+	inc.StatusTS = ts
+	if err := api.AddPin(inc.Channel, slack.NewRefToMessage(inc.Channel, ts)); err != nil {
+		fmt.Printf("failed pinning status in %s: %v\n", inc.Name, err)
+	}
+
+	inc.Timeline = append(inc.Timeline, TimelineEntry{At: inc.Created, User: userID, Text: "Incident declared: " + inc.Title})
+
+	incidents.lock()
+	incidents.Incidents[inc.Channel] = inc
+	incidents.save()
+	incidents.mu.Unlock()
+
+	if announce := os.Getenv("INCIDENT_CHANNEL"); announce != "" {
+		channelID, err := resolveChannel(api, announce)
+		if err == nil {
+			text := fmt.Sprintf(":rotating_light: <@%s> declared an incident: *%s* in <#%s>", userID, inc.Title, inc.Channel)
+			_, _, err = api.PostMessage(channelID, slack.MsgOptionText(text, false))
+		}
+		if err != nil {
+			fmt.Printf("failed announcing incident: %v\n", err)
+		}
+	}
+
+	return inc, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func (inc *Incident) topic() string {
+	topic := strings.ToUpper(inc.Status) + ": " + inc.Title
+	if inc.Severity != "" {
+		topic = inc.Severity + " " + topic
+	}
+	return topic
+}
+
+// statusBlocks renders the pinned status message.
+func (inc *Incident) statusBlocks() []slack.Block {
+	field := func(label, value string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, "*"+label+"*\n"+value, false, false)
+	}
+
+	severity := inc.Severity
+	if severity == "" {
+		severity = "not set"
+	}
+
+	summary := inc.Summary
+	if summary == "" {
+		summary = "_No updates yet._"
+	}
+
+	return []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, inc.Title, false, false)),
+		slack.NewSectionBlock(nil, []*slack.TextBlockObject{
+			field("Status", strings.ToUpper(inc.Status[:1])+inc.Status[1:]),
+			field("Severity", severity),
+			field("Commander", "<@"+inc.Commander+">"),
+			field("Started", slackDate(inc.Created, inc.Created.Format(time.RFC1123))),
+		}, nil),
+		slack.NewSectionBlock(field("Current status", summary), nil, nil),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf(
+			"Update with `/incident status identified <note>`, add messages to the timeline by reacting with :%s:, "+
+				"finish with `/incident resolve`.", incidentReaction), false, false)),
+	}
+}
+
+// refresh updates the pinned status message and the topic. inc is a
+// snapshot, it's called without holding incidents.mu.
+func (inc *Incident) refresh(api *slack.Client) {
+	_, _, _, err := api.UpdateMessage(inc.Channel, inc.StatusTS,
+		slack.MsgOptionText(inc.Title, false), slack.MsgOptionBlocks(inc.statusBlocks()...))
+	if err != nil {
+		fmt.Printf("failed updating incident status: %v\n", err)
+	}
+	if _, err := api.SetTopicOfConversation(inc.Channel, inc.topic()); err != nil {
+		fmt.Printf("failed setting topic of %s: %v\n", inc.Name, err)
+	}
+}
+
+//---
+
+// handleIncidentReaction adds a message to the timeline when someone reacts
+// to it with the timeline emoji in an incident channel.
+func handleIncidentReaction(ev *slackevents.ReactionAddedEvent, client *socketmode.Client) {
+	if ev.Reaction != incidentReaction || ev.Item.Type != "message" {
+		return
+	}
+
+	incidents.lock()
+	inc, ok := incidents.Incidents[ev.Item.Channel]
+	if ok {
+		for _, e := range inc.Timeline {
+			if e.TS == ev.Item.Timestamp {
+				ok = false // already on it
+			}
+		}
+	}
+	incidents.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	msg, err := findMessage(&client.Client, ev.Item.Channel, ev.Item.Timestamp)
+	if err != nil {
+		fmt.Printf("failed fetching timeline message: %v\n", err)
+		return
+	}
+
+	incidents.lock()
+	inc.Timeline = append(inc.Timeline, TimelineEntry{
+		At:   parseTS(msg.Timestamp),
+		User: msg.User,
+		Text: msg.Text,
+		TS:   msg.Timestamp,
+	})
+	incidents.save()
+	incidents.mu.Unlock()
+
+	_, err = client.Client.PostEphemeral(ev.Item.Channel, ev.User, slack.MsgOptionText("Added to the incident timeline.", false))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// timelineText renders the timeline, one line per entry.
+func (inc *Incident) timelineText(api *slack.Client) string {
+	loc := getUserLocation(api, inc.Commander)
+
+	lines := make([]string, len(inc.Timeline))
+	for i, e := range inc.Timeline {
+		lines[i] = fmt.Sprintf("%s %s: %s", e.At.In(loc).Format("Jan 2 15:04 MST"), userName(api, e.User), e.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// transcript renders the channel history for the postmortem prompt, keeping
+// the most recent messages when it's too long.
+func transcript(api *slack.Client, messages []slack.Message, loc *time.Location) string {
+	var lines []string
+	for _, m := range messages {
+		if m.Text == "" || m.SubType == "channel_join" || m.SubType == "channel_topic" {
+			continue
+		}
+		who := m.User
+		if who == "" {
+			who = m.Username
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", parseTS(m.Timestamp).In(loc).Format("15:04"), userName(api, who), m.Text))
+	}
+
+	text := strings.Join(lines, "\n")
+	if len(text) > maxTranscriptChars {
+		i := len(text) - maxTranscriptChars
+		for i < len(text) && !utf8.RuneStart(text[i]) {
+			i++
+		}
+		text = "...\n" + text[i:]
+	}
+	return text
+}
+
+// snapshot copies the incident, for use after releasing the lock. Must hold
+// incidents.mu.
+func (inc *Incident) snapshot() *Incident {
+	copied := *inc
+	copied.Responders = append([]string(nil), inc.Responders...)
+	copied.Timeline = append([]TimelineEntry(nil), inc.Timeline...)
+	return &copied
+}
+
+// markResolved resolves the incident and returns a copy of it, ok is false
+// when it was already resolved.
+func markResolved(inc *Incident, userID, note string) (*Incident, bool) {
+	incidents.lock()
+	defer incidents.mu.Unlock()
+
+	if inc.Status == "resolved" {
+		return nil, false
+	}
+	inc.Status = "resolved"
+	inc.Resolved = time.Now()
+	if note != "" {
+		inc.Summary = note
+	}
+	inc.Timeline = append(inc.Timeline, TimelineEntry{At: inc.Resolved, User: userID, Text: "Resolved. " + note})
+	incidents.save()
+	return inc.snapshot(), true
+}
+
+// resolveIncident updates a resolved incident's channel and posts a
+// postmortem draft. inc is a snapshot from markResolved.
+func resolveIncident(api *slack.Client, inc *Incident, userID string) {
+	inc.refresh(api)
+	timeline := inc.timelineText(api)
+
+	messages, err := conversationHistory(api, inc.Channel, inc.Created)
+	if err != nil {
+		fmt.Printf("failed reading incident history: %v\n", err)
+	}
+
+	prompt := fmt.Sprintf(`Write a blameless postmortem draft in Slack markdown for the incident below.
+Use the sections: Summary, Impact, Timeline, Root cause, What went well, What went wrong, Action items.
+Only state what the timeline and transcript support, mark guesses as such and leave a TODO where information is missing.
+
+Title: %s
+Severity: %s
+Started: %s
+Resolved: %s
+
+Timeline:
+%s
+
+Channel transcript:
+%s`, inc.Title, inc.Severity, inc.Created.Format(time.RFC1123), inc.Resolved.Format(time.RFC1123),
+		timeline, transcript(api, messages, getUserLocation(api, inc.Commander)))
+
+	draft, err := getOpenAIResponse(prompt)
+	if err != nil {
+		draft = "Couldn't draft a postmortem: " + err.Error() + "\n\nTimeline:\n" + timeline
+	}
+
+	text := fmt.Sprintf(":white_check_mark: <@%s> resolved this incident. Here's a postmortem draft to start from:\n\n%s", userID, draft)
+	if _, _, err := api.PostMessage(inc.Channel, slack.MsgOptionText(text, false)); err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+//---
+
+const incidentHelp = "Try `/incident checkout errors sev2 @alice @bob` to open an incident, and in the incident channel " +
+	"`/incident status identified <note>`, `/incident timeline` or `/incident resolve <note>`."
+
+// handleIncidentCommand handles /incident.
+func handleIncidentCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleIncidentCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+	api := &client.Client
+
+	reply := func(text string) {
+		client.Ack(*evt.Request, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          text,
+		})
+	}
+
+	verb, rest := cmd.Text, ""
+	if i := strings.Index(cmd.Text, " "); i >= 0 {
+		verb, rest = cmd.Text[:i], strings.TrimSpace(cmd.Text[i+1:])
+	}
+	verb = strings.ToLower(verb)
+
+	incidents.lock()
+	inc, inIncident := incidents.Incidents[cmd.ChannelID]
+	incidents.mu.Unlock()
+
+	switch verb {
+	case "", "help":
+		reply(incidentHelp)
+
+	case "status", "timeline", "resolve":
+		if !inIncident {
+			reply("Run this in an incident channel. " + incidentHelp)
+			return
+		}
+
+		switch verb {
+		case "timeline":
+			incidents.lock()
+			snapshot := inc.snapshot()
+			incidents.mu.Unlock()
+			reply("*Timeline*\n" + snapshot.timelineText(api))
+
+		case "status":
+			status, note := rest, ""
+			if i := strings.Index(rest, " "); i >= 0 {
+				status, note = rest[:i], strings.TrimSpace(rest[i+1:])
+			}
+			status = strings.ToLower(status)
+			if !contains(incidentStatuses[:3], status) {
+				reply("The status is one of investigating, identified or monitoring, use `/incident resolve` to finish.")
+				return
+			}
+
+			incidents.lock()
+			inc.Status = status
+			if note != "" {
+				inc.Summary = note
+			}
+			inc.Timeline = append(inc.Timeline, TimelineEntry{At: time.Now(), User: cmd.UserID, Text: "Status: " + status + " " + note})
+			incidents.save()
+			snapshot := inc.snapshot()
+			incidents.mu.Unlock()
+
+			client.Ack(*evt.Request, map[string]interface{}{
+				"response_type": "in_channel",
+				"text":          fmt.Sprintf("Status is now *%s*. %s", status, note),
+			})
+			snapshot.refresh(api)
+
+		case "resolve":
+			snapshot, ok := markResolved(inc, cmd.UserID, rest)
+			if !ok {
+				reply("This incident is already resolved.")
+				return
+			}
+			// Drafting takes longer than Slack waits for a slash command.
+			reply("Resolving, I'll post a postmortem draft here shortly.")
+			resolveIncident(api, snapshot, cmd.UserID)
+		}
+
+	default:
+		title := cmd.Text
+		if verb == "start" || verb == "new" {
+			title = rest
+		}
+
+		// Creating the channel takes a few calls, acknowledge first.
+		reply("Setting up the incident channel...")
+
+		text := "Sorry, "
+		inc, err := startIncident(api, cmd.UserID, title)
+		if err != nil {
+			text += err.Error()
+		} else {
+			text = fmt.Sprintf("Opened <#%s> for *%s*.", inc.Channel, inc.Title)
+		}
+		if _, err := api.PostEphemeral(cmd.ChannelID, cmd.UserID, slack.MsgOptionText(text, false)); err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
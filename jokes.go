@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Joke is a dad joke with an ID stable enough to remember we've told it.
+type Joke struct {
+	ID   string `json:"id"`
+	Text string `json:"joke"`
+}
+
+// JokeProvider hands out dad jokes.
+type JokeProvider interface {
+	Random(ctx context.Context) (Joke, error)
+	Search(ctx context.Context, term string) ([]Joke, error)
+}
+
+// jokeProvider tries icanhazdadjoke.com and falls back to the bundled corpus
+// when it's down or we're offline.
+var jokeProvider JokeProvider = fallbackJokeProvider{
+	primary:  &icanhazdadjokeProvider{client: &http.Client{Timeout: 5 * time.Second}},
+	fallback: newOfflineJokeProvider(jokeCorpus),
+}
+
+//---
+
+type icanhazdadjokeProvider struct {
+	client *http.Client
+}
+
+func (p *icanhazdadjokeProvider) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://icanhazdadjoke.com"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "slack-bot (https://github.com/karlrink/slack-bot)")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("icanhazdadjoke.com returned %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *icanhazdadjokeProvider) Random(ctx context.Context) (Joke, error) {
+	var joke Joke
+	err := p.get(ctx, "/", &joke)
+	return joke, err
+}
+
+func (p *icanhazdadjokeProvider) Search(ctx context.Context, term string) ([]Joke, error) {
+	var result struct {
+		Results []Joke `json:"results"`
+	}
+	params := url.Values{"term": {term}, "limit": {"30"}}
+	err := p.get(ctx, "/search?"+params.Encode(), &result)
+	return result.Results, err
+}
+
+//---
+
+//go:embed jokes.txt
+var jokeCorpus string
+
+// offlineJokeProvider serves jokes from a newline separated corpus.
+type offlineJokeProvider struct {
+	jokes []Joke
+}
+
+func newOfflineJokeProvider(corpus string) *offlineJokeProvider {
+	p := &offlineJokeProvider{}
+	for _, line := range strings.Split(corpus, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(line))
+		p.jokes = append(p.jokes, Joke{ID: "offline-" + strconv.FormatUint(uint64(h.Sum32()), 36), Text: line})
+	}
+	return p
+}
+
+func (p *offlineJokeProvider) Random(ctx context.Context) (Joke, error) {
+	if len(p.jokes) == 0 {
+		return Joke{}, fmt.Errorf("the joke book is empty")
+	}
+	return p.jokes[rand.Intn(len(p.jokes))], nil
+}
+
+func (p *offlineJokeProvider) Search(ctx context.Context, term string) ([]Joke, error) {
+	term = strings.ToLower(term)
+	var found []Joke
+	for _, joke := range p.jokes {
+		if strings.Contains(strings.ToLower(joke.Text), term) {
+			found = append(found, joke)
+		}
+	}
+	return found, nil
+}
+
+//---
+
+type fallbackJokeProvider struct {
+	primary  JokeProvider
+	fallback JokeProvider
+}
+
+func (p fallbackJokeProvider) Random(ctx context.Context) (Joke, error) {
+	joke, err := p.primary.Random(ctx)
+	if err != nil || joke.Text == "" {
+		fmt.Printf("joke provider failed, using offline jokes: %v\n", err)
+		return p.fallback.Random(ctx)
+	}
+	return joke, nil
+}
+
+func (p fallbackJokeProvider) Search(ctx context.Context, term string) ([]Joke, error) {
+	jokes, err := p.primary.Search(ctx, term)
+	if err != nil {
+		fmt.Printf("joke search failed, using offline jokes: %v\n", err)
+		return p.fallback.Search(ctx, term)
+	}
+	return jokes, nil
+}
+
+//---
+
+// Remember which jokes each channel has heard so we don't repeat ourselves
+// within JOKE_REPEAT_DAYS.
+const jokeHistoryFile = "joke_history.json"
+
+// jokeHistoryStore maps channel to joke ID to when it was last told. The
+// file is the bare map.
+type jokeHistoryStore struct {
+	jsonStore
+	heard map[string]map[string]time.Time
+}
+
+var jokeHistory = &jokeHistoryStore{}
+
+// lock loads the store on first use and locks it.
+func (s *jokeHistoryStore) lock() {
+	s.lockJSON(jokeHistoryFile, "joke history", &s.heard, func() {
+		if s.heard == nil {
+			s.heard = make(map[string]map[string]time.Time)
+		}
+	})
+}
+
+func jokeRepeatWindow() time.Duration {
+	days, err := strconv.Atoi(envOrDefault("JOKE_REPEAT_DAYS", "30"))
+	if err != nil || days < 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// heardJokes returns when each joke was last told in the channel, dropping
+// entries that have aged out of the repeat window. Must hold jokeHistory.mu.
+func heardJokes(channelID string) map[string]time.Time {
+	heard := jokeHistory.heard[channelID]
+	if heard == nil {
+		heard = make(map[string]time.Time)
+		jokeHistory.heard[channelID] = heard
+	}
+
+	window := jokeRepeatWindow()
+	for id, told := range heard {
+		if time.Since(told) > window {
+			delete(heard, id)
+		}
+	}
+	return heard
+}
+
+func recordJoke(channelID string, joke Joke) {
+	jokeHistory.lock()
+	defer jokeHistory.mu.Unlock()
+
+	heardJokes(channelID)[joke.ID] = time.Now()
+	jokeHistory.saveLocked(jokeHistoryFile, "joke history", jokeHistory.heard)
+}
+
+func alreadyHeard(channelID string, joke Joke) bool {
+	jokeHistory.lock()
+	defer jokeHistory.mu.Unlock()
+
+	_, heard := heardJokes(channelID)[joke.ID]
+	return heard
+}
+
+// getDadJoke returns a joke the channel hasn't heard lately, about term if given.
+func getDadJoke(channelID, term string) (string, error) {
+	ctx := context.Background()
+	term = strings.TrimSpace(term)
+
+	var joke Joke
+	if term != "" {
+		jokes, err := jokeProvider.Search(ctx, term)
+		if err != nil {
+			return "", err
+		}
+		if len(jokes) == 0 {
+			return "", fmt.Errorf("I don't know any jokes about %s", term)
+		}
+
+		rand.Shuffle(len(jokes), func(i, k int) { jokes[i], jokes[k] = jokes[k], jokes[i] })
+		joke = jokes[0]
+		for _, j := range jokes {
+			if !alreadyHeard(channelID, j) {
+				joke = j
+				break
+			}
+		}
+	} else {
+		// A handful of tries is plenty, the pool is large.
+		for i := 0; i < 5; i++ {
+			j, err := jokeProvider.Random(ctx)
+			if err != nil {
+				return "", err
+			}
+			joke = j
+			if !alreadyHeard(channelID, j) {
+				break
+			}
+		}
+	}
+
+	recordJoke(channelID, joke)
+	return joke.Text, nil
+}
+
+// jokeSearchTerm extracts the subject from "dadjoke cats" or
+// "tell me a dadjoke about cats".
+func jokeSearchTerm(text string) (string, bool) {
+	lower := strings.ToLower(text)
+	for _, prefix := range []string{"dadjoke ", "tell me a dadjoke about ", "tell me a dad joke about "} {
+		if strings.HasPrefix(lower, prefix) {
+			term := strings.TrimSpace(text[len(prefix):])
+			return term, term != ""
+		}
+	}
+	return "", false
+}
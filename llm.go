@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// All model calls go through llm so features share one client and model
+// choice, and so they can be pointed at a fake for offline development.
+
+// LLMProvider is the part of the OpenAI client the bot uses.
+type LLMProvider interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+	GenerateImage(ctx context.Context, req ImageGeneration) (GeneratedImage, error)
+	Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// llm is chosen by LLM_PROVIDER, "fake" answers without calling out. Every
+// call is rate limited and its cost recorded, see usage.go, and sensitive
+// text is masked, see redact.go.
+var llm LLMProvider = meteredProvider{redactingProvider{newLLMProvider(os.Getenv("LLM_PROVIDER"))}}
+
+// llmModel is the chat model, OPENAI_MODEL overrides it.
+var llmModel = envOrDefault("OPENAI_MODEL", openai.GPT3Dot5Turbo)
+
+func newLLMProvider(name string) LLMProvider {
+	switch name {
+	case "fake":
+		return fakeLLMProvider{}
+	default:
+		return &openAIProvider{
+			Client: openai.NewClient(cfg.OpenAIToken),
+			http:   &http.Client{Timeout: 2 * time.Minute},
+			token:  cfg.OpenAIToken,
+		}
+	}
+}
+
+type ImageGeneration struct {
+	Model  string
+	Prompt string
+	Size   string // "1024x1024"
+	User   string
+}
+
+type GeneratedImage struct {
+	PNG []byte
+	// RevisedPrompt is the prompt the model actually drew, when it rewrote ours.
+	RevisedPrompt string
+}
+
+// openAIProvider is the OpenAI client plus what it doesn't cover yet.
+type openAIProvider struct {
+	*openai.Client
+	http  *http.Client
+	token string
+}
+
+// GenerateImage is made directly, the go-openai version we use doesn't know
+// image models or return the revised prompt.
+func (p *openAIProvider) GenerateImage(ctx context.Context, req ImageGeneration) (GeneratedImage, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":           req.Model,
+		"prompt":          req.Prompt,
+		"size":            req.Size,
+		"n":               1,
+		"response_format": "b64_json",
+		"user":            req.User,
+	})
+	if err != nil {
+		return GeneratedImage{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/images/generations", bytes.NewReader(body))
+	if err != nil {
+		return GeneratedImage{}, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.token)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.http.Do(httpReq)
+	if err != nil {
+		return GeneratedImage{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			B64JSON       string `json:"b64_json"`
+			RevisedPrompt string `json:"revised_prompt"`
+		} `json:"data"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return GeneratedImage{}, fmt.Errorf("OpenAI returned %s", resp.Status)
+	}
+	if result.Error != nil {
+		return GeneratedImage{}, fmt.Errorf("OpenAI returned %s: %s", resp.Status, result.Error.Message)
+	}
+	if len(result.Data) == 0 {
+		return GeneratedImage{}, fmt.Errorf("the model returned no image")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(result.Data[0].B64JSON)
+	if err != nil {
+		return GeneratedImage{}, err
+	}
+	return GeneratedImage{PNG: data, RevisedPrompt: result.Data[0].RevisedPrompt}, nil
+}
+
+// complete sends a single prompt, with an optional system prompt, and returns
+// the reply.
+func complete(system, prompt string) (string, error) {
+	var messages []openai.ChatCompletionMessage
+	if system != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: system})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt})
+
+	resp, err := llm.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    llmModel,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("the model returned no answer")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// maxEmbeddingBatch is how many texts go into one embeddings request.
+const maxEmbeddingBatch = 100
+
+// embed returns one unit-length vector per text.
+func embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingBatch {
+		end := start + maxEmbeddingBatch
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		resp, err := llm.CreateEmbeddings(context.Background(), openai.EmbeddingRequestStrings{
+			Input: texts[start:end],
+			Model: openai.AdaEmbeddingV2,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Data) != end-start {
+			return nil, fmt.Errorf("asked for %d embeddings, got %d", end-start, len(resp.Data))
+		}
+
+		sort.Slice(resp.Data, func(i, k int) bool { return resp.Data[i].Index < resp.Data[k].Index })
+		for _, e := range resp.Data {
+			vectors = append(vectors, normalize(e.Embedding))
+		}
+	}
+	return vectors, nil
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// fakeLLMProvider echoes the start of the last message back.
+type fakeLLMProvider struct{}
+
+func (fakeLLMProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	last := ""
+	if len(req.Messages) > 0 {
+		last = req.Messages[len(req.Messages)-1].Content
+	}
+	if len(last) > 200 {
+		last = last[:200] + "..."
+	}
+
+	return openai.ChatCompletionResponse{
+		Model: "fake",
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: "You said: " + strings.TrimSpace(last),
+			},
+			FinishReason: openai.FinishReasonStop,
+		}},
+	}, nil
+}
+
+// CreateEmbeddings hashes words into a small vector, so texts sharing words
+// come out similar.
+func (fakeLLMProvider) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	req := conv.Convert()
+	texts, _ := req.Input.([]string)
+
+	resp := openai.EmbeddingResponse{Object: "list", Model: req.Model}
+	for i, text := range texts {
+		v := make([]float32, 256)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			v[h.Sum32()%uint32(len(v))]++
+		}
+		resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Embedding: v, Index: i})
+	}
+	return resp, nil
+}
+
+// GenerateImage draws a plain square, its shade taken from the prompt.
+func (fakeLLMProvider) GenerateImage(ctx context.Context, req ImageGeneration) (GeneratedImage, error) {
+	h := fnv.New32a()
+	h.Write([]byte(req.Prompt))
+	sum := h.Sum32()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{uint8(sum), uint8(sum >> 8), uint8(sum >> 16), 255}}, image.Point{}, draw.Src)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return GeneratedImage{}, err
+	}
+	return GeneratedImage{PNG: b.Bytes(), RevisedPrompt: "A plain square standing in for: " + req.Prompt}, nil
+}
+
+// Moderations flags nothing.
+func (fakeLLMProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Model: "fake", Results: []openai.Result{{}}}, nil
+}
@@ -1,35 +1,87 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
 
 	"github.com/slack-go/slack"
-
-	openai "github.com/sashabaranov/go-openai"
 )
 
 var (
-	version     = "1.0.0.🎃-2023-10-06"
-	appToken    = os.Getenv("SLACK_APP_TOKEN")
-	botToken    = os.Getenv("SLACK_BOT_TOKEN")
-	openaiToken = os.Getenv("OPENAI_API_KEY")
+	version = "1.0.0.🎃-2023-10-06"
 
 	// Create a channel to gracefully stop the application
 	stopChannel = make(chan struct{})
 )
 
+const usage = `usage: slack-bot [command] [arguments]
+
+commands:
+  run              connect to Slack and run the bot (the default)
+  channels list    list conversations, see "channels list -h"
+  channels stale   report and archive inactive channels
+  send             post a message as the bot
+  simulate         run a direct message through the bot without connecting to Slack
+  index            add Markdown, text and HTML documents to a collection for answers
+  reindex          rebuild document collections from scratch
+  usage            show AI requests, tokens and estimated cost per day
+  config check     check the configuration and credentials
+  version          print the version
+`
+
 func main() {
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "run":
+		err = runBot()
+	case "channels":
+		err = channelsCommand(args)
+	case "send":
+		err = sendCommand(args)
+	case "simulate":
+		err = simulateCommand(args)
+	case "index":
+		err = indexCommand(args)
+	case "reindex":
+		err = reindexCommand(args)
+	case "usage":
+		err = usageCommand(args)
+	case "config":
+		err = configCommand(args)
+	case "version", "-version", "--version":
+		fmt.Println(version)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
+		os.Exit(1)
+	}
+}
+
+// runBot connects with socket mode and handles events until stopped.
+func runBot() error {
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -40,43 +92,15 @@ func main() {
 		close(stopChannel)
 	}()
 
-	appToken := os.Getenv("SLACK_APP_TOKEN")
-	if appToken == "" {
-		panic("SLACK_APP_TOKEN must be set.\n")
+	if err := cfg.require("app", "bot", "openai"); err != nil {
+		return err
 	}
 
-	if !strings.HasPrefix(appToken, "xapp-") {
-		panic("SLACK_APP_TOKEN must have the prefix \"xapp-\".")
-	}
-
-	botToken := os.Getenv("SLACK_BOT_TOKEN")
-	if botToken == "" {
-		panic("SLACK_BOT_TOKEN must be set.\n")
-	}
-
-	if !strings.HasPrefix(botToken, "xoxb-") {
-		panic("SLACK_BOT_TOKEN must have the prefix \"xoxb-\".")
-	}
-
-	openaiToken := os.Getenv("OPENAI_API_KEY")
-	if botToken == "" {
-		panic("OPENAI_API_KEY must be set.\n")
-	}
-
-	if !strings.HasPrefix(openaiToken, "sk-") {
-		panic("OPENAI_API_KEY must have the prefix \"sk-\".")
-	}
-
-	api := slack.New(
-		botToken,
-		slack.OptionDebug(true),
-		slack.OptionLog(log.New(os.Stdout, "api: ", log.Lshortfile|log.LstdFlags)),
-		slack.OptionAppLevelToken(appToken),
-	)
+	api := newSlackClient(cfg.Debug)
 
 	client := socketmode.New(
 		api,
-		socketmode.OptionDebug(true),
+		socketmode.OptionDebug(cfg.Debug),
 		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
 	)
 
@@ -108,6 +132,18 @@ func main() {
 
 	//socketmodeHandler.RunEventLoop()
 
+	// Post reminders and recurring scheduled messages
+	go runScheduler(api, stopChannel)
+
+	// Announce on-call handoffs and keep user groups in sync
+	go runOnCall(api, stopChannel)
+
+	// Forget files shared for questions once they are no longer used
+	go runDocumentRetention(stopChannel)
+
+	// Accept alerts from monitoring, when WEBHOOK_ADDR is set
+	go runWebhookServer(api)
+
 	// Start the event loop in a separate goroutine
 	go func() {
 		if err := socketmodeHandler.RunEventLoop(); err != nil {
@@ -119,6 +155,7 @@ func main() {
 	// Wait for a signal to gracefully stop the application
 	<-stopChannel
 
+	return nil
 }
 
 //---
@@ -176,14 +213,35 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 				// Check if we have already responded to this message
 				if _, exists := respondedMessages[ev.ClientMsgID]; !exists {
 
+					// Voice clips and audio files, transcribed in a thread
+					if handleAudioMessage(ev, client) {
+						return
+					}
+
+					// Logs, CSVs and PDFs, and questions in their threads
+					if handleDocumentMessage(ev, client) {
+						return
+					}
+
+					// Screenshots and other images, answered by a vision model
+					if hasImages(ev.Files) {
+						handleImageMessage(ev, client)
+						return
+					}
+
 					// Check for the special message to send a joke to a channel
 					if strings.HasPrefix(ev.Text, "Tell a dad joke in channel") {
 						// Extract the channelID
 						channelID := strings.TrimPrefix(ev.Text, "Tell a dad joke in channel <#")
 						channelID = strings.Split(channelID, "|")[0] // Assuming the channel mention format is <#CHANNEL_ID|name>
 
+						if err := authorize(&client.Client, ev.User, actionPost, channelID); err != nil {
+							replyDenied(&client.Client, ev.Channel, err)
+							return
+						}
+
 						// Get a joke
-						jokeText, jokeErr := getDadJoke()
+						jokeText, jokeErr := getDadJoke(channelID, "")
 						if jokeErr != nil {
 							jokeText = "This is Not a Joke! " + jokeErr.Error()
 						}
@@ -210,17 +268,20 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 						userIDWithBrackets := strings.TrimPrefix(ev.Text, specialMessagePrefix)
 						userID := strings.Trim(userIDWithBrackets, "<@>")
 
+						if err := authorize(&client.Client, ev.User, actionDM, userID); err != nil {
+							replyDenied(&client.Client, ev.Channel, err)
+							return
+						}
+
 						// Open a direct message channel
-						channel, _, _, err := client.Client.OpenConversation(&slack.OpenConversationParameters{
-							Users: []string{userID},
-						})
+						channelID, err := openDirectMessage(&client.Client, userID)
 						if err != nil {
 							fmt.Printf("Failed opening channel: %v", err)
 							return
 						}
 
 						// Send the direct message
-						_, _, err = client.Client.PostMessage(channel.ID, slack.MsgOptionText("This is a direct message from the chat bot", false))
+						_, _, err = client.Client.PostMessage(channelID, slack.MsgOptionText("This is a direct message from the chat bot", false))
 						if err != nil {
 							fmt.Printf("Failed sending direct message: %v", err)
 						} else {
@@ -240,23 +301,26 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 						userIDWithBrackets := strings.TrimPrefix(ev.Text, specialMessagePrefix2)
 						userID := strings.Trim(userIDWithBrackets, "<@>")
 
+						if err := authorize(&client.Client, ev.User, actionDM, userID); err != nil {
+							replyDenied(&client.Client, ev.Channel, err)
+							return
+						}
+
 						// Open a direct message channel
-						channel, _, _, err := client.Client.OpenConversation(&slack.OpenConversationParameters{
-							Users: []string{userID},
-						})
+						channelID, err := openDirectMessage(&client.Client, userID)
 						if err != nil {
 							fmt.Printf("Failed opening channel: %v", err)
 							return
 						}
 
 						// Get a Dad joke
-						jokeText, jokeErr := getDadJoke()
+						jokeText, jokeErr := getDadJoke(channelID, "")
 						if jokeErr != nil {
 							jokeText = "This is Not a Joke! " + jokeErr.Error()
 						}
 
 						// Send the direct message
-						_, _, err = client.Client.PostMessage(channel.ID, slack.MsgOptionText(jokeText, false))
+						_, _, err = client.Client.PostMessage(channelID, slack.MsgOptionText(jokeText, false))
 						if err != nil {
 							fmt.Printf("Failed sending direct message: %v", err)
 						} else {
@@ -278,17 +342,20 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 						userID := strings.Trim(userIDWithBrackets, "<@>")
 						customMessage := strings.TrimPrefix(userIDAndCustomMessage, userIDWithBrackets+" ")
 
+						if err := authorize(&client.Client, ev.User, actionDM, userID); err != nil {
+							replyDenied(&client.Client, ev.Channel, err)
+							return
+						}
+
 						// Open a direct message channel
-						channel, _, _, err := client.Client.OpenConversation(&slack.OpenConversationParameters{
-							Users: []string{userID},
-						})
+						channelID, err := openDirectMessage(&client.Client, userID)
 						if err != nil {
 							fmt.Printf("Failed opening channel: %v", err)
 							return
 						}
 
 						// Send the custom direct message
-						_, _, err = client.Client.PostMessage(channel.ID, slack.MsgOptionText(customMessage, false))
+						_, _, err = client.Client.PostMessage(channelID, slack.MsgOptionText(customMessage, false))
 						if err != nil {
 							fmt.Printf("Failed sending custom direct message: %v", err)
 						} else {
@@ -301,14 +368,27 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 						return
 					}
 
-					lowerCaseMessage := strings.ToLower(ev.Text) // Convert to lowercase
+					// Check for reminders and scheduled messages
+					if isScheduleRequest(ev.Text) {
+						handleScheduleMessage(ev, client)
+						return
+					}
 
-					switch lowerCaseMessage {
+					// Check for "imagine a lighthouse made of cheese"
+					if prompt, ok := imaginePrompt(ev.Text); ok {
+						handleImagineMessage(ev, client, prompt)
+						return
+					}
 
-					case "dadjoke", "tell me a dadjoke", "tell me another dadjoke":
-						//response := "Yes, I can dad that"
+					// Check for a weather question, with or without a place
+					if place, ok := weatherQuestionPlace(ev.Text); ok {
+						handleWeatherMessage(ev, client, place)
+						return
+					}
 
-						jokeText, jokeErr := getDadJoke()
+					// Check for a dad joke about something, "dadjoke cats"
+					if term, ok := jokeSearchTerm(ev.Text); ok {
+						jokeText, jokeErr := getDadJoke(ev.Channel, term)
 						if jokeErr != nil {
 							jokeText = "This is Not a Joke! " + jokeErr.Error()
 						}
@@ -318,29 +398,40 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 							fmt.Printf("failed posting message: %v", err)
 						}
 
-					case "what is the weather like":
-						response := "I'm sorry, I can't provide weather information."
-						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
-						if err != nil {
-							fmt.Printf("failed posting message: %v", err)
-						}
+						return
+					}
 
-					case "time", "what time is it", "what time is it?", "do you know what time it is", "tell me the time":
+					// Check for "who is on call for payments"
+					if rotation, ok := oncallQuestion(ev.Text); ok {
+						handleOnCallMessage(ev, client, rotation)
+						return
+					}
 
-						// Get the current time
-						currentTime := time.Now()
+					// Check for the time, world clock and timezone conversion
+					if query, ok := timeQuestion(ev.Text); ok {
+						handleTimeMessage(ev, client, query)
+						return
+					}
 
-						// Format the current time as a string
-						timeString := currentTime.Format("2006-01-02 15:04:05")
+					lowerCaseMessage := strings.ToLower(ev.Text) // Convert to lowercase
 
-						response := "At the tone the time will be... \n" + timeString
-						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
+					switch lowerCaseMessage {
+
+					case "dadjoke", "tell me a dadjoke", "tell me another dadjoke":
+						//response := "Yes, I can dad that"
+
+						jokeText, jokeErr := getDadJoke(ev.Channel, "")
+						if jokeErr != nil {
+							jokeText = "This is Not a Joke! " + jokeErr.Error()
+						}
+
+						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(jokeText, false))
 						if err != nil {
 							fmt.Printf("failed posting message: %v", err)
 						}
 
 					case "what version are you?":
-						response := "I'm bot version " + version + " using openai.GPT3Dot5Turbo and an expert rules engine."
+						response := "I'm bot version " + version + " using " + llmModel + " and an expert rules engine."
 						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
 						if err != nil {
 							fmt.Printf("failed posting message: %v", err)
@@ -348,27 +439,25 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 
 					case "openai":
 						//response := "I am a chatbot designed to assist you with various tasks."
-						openaiResponse, openaiErr := getOpenAIResponse(ev.Text)
+						question, ok := screen(&client.Client, ev.User, ev.Channel, ev.Text)
+						if !ok {
+							return
+						}
+						persona := personaFor(&client.Client, ev.User, ev.Channel, "")
+						openaiResponse, openaiErr := getOpenAIAnswer(persona, ev.Channel, question)
 						if openaiErr != nil {
 							openaiResponse = "ResponseError: " + openaiErr.Error()
 						}
 
-						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(openaiResponse, false))
+						_, _, err := client.Client.PostMessage(ev.Channel, personaReply(persona, openaiResponse)...)
 						if err != nil {
 							fmt.Printf("failed posting message: %v", err)
 						}
 
 					default:
 						//response := fmt.Sprintf("Howdy, i got your message: %s", ev.Text)
-						openaiResponse, openaiErr := getOpenAIResponse(ev.Text)
-						if openaiErr != nil {
-							openaiResponse = "ResponseError: " + openaiErr.Error()
-						}
-
-						_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(openaiResponse, false))
-						if err != nil {
-							fmt.Printf("failed posting message: %v", err)
-						}
+						// A saved FAQ answer if there is one, otherwise ask openai
+						answerQuestion(&client.Client, ev.User, ev.Channel, "", ev.Text)
 
 					} // send-switch-case
 
@@ -378,14 +467,22 @@ func middlewareEventsAPI(evt *socketmode.Event, client *socketmode.Client) {
 			}
 
 		case *slackevents.AppMentionEvent:
+			// Answered by middlewareAppMentionEvent
 			fmt.Printf("We have been mentioned in %v", ev.Channel)
-			_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText("Yes, mentioned, ", false))
-			if err != nil {
-				fmt.Printf("failed posting message: %v", err)
+
+		case *slackevents.AppHomeOpenedEvent:
+			if ev.Tab == "home" {
+				publishPromptHome(&client.Client, ev.User)
 			}
 
+		case *slackevents.ReactionAddedEvent:
+			handleIncidentReaction(ev, client)
+			handleFAQReaction(ev, client)
+			handleTranslationReaction(ev, client)
+
 		case *slackevents.MemberJoinedChannelEvent:
 			fmt.Printf("user %q joined to channel %q", ev.User, ev.Channel)
+			go handleMemberJoined(ev, client)
 		}
 
 	default:
@@ -418,6 +515,35 @@ func middlewareAppMentionEvent(evt *socketmode.Event, client *socketmode.Client)
 	}
 
 	fmt.Printf("We have been mentioned in %v\n", ev.Channel)
+
+	// Mention events don't carry files, fetch the message for them.
+	var files []sharedFile
+	if msg, err := findMessage(&client.Client, ev.Channel, ev.TimeStamp); err == nil {
+		files = messageFiles(msg.Files)
+	} else {
+		fmt.Printf("failed fetching the mention: %v\n", err)
+	}
+
+	// Audio shared with a mention
+	if handleAudioMention(ev, files, client) {
+		return
+	}
+
+	// Files shared with a mention, and questions about them
+	if handleDocumentMention(ev, files, client) {
+		return
+	}
+
+	if isSummarizeMention(ev.Text) {
+		handleSummarizeMention(ev, client)
+		return
+	}
+
+	// A saved answer to a question asked with a mention
+	if handleFAQMention(ev, client) {
+		return
+	}
+
 	_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText("Oh, hello.", false))
 	if err != nil {
 		fmt.Printf("failed posting message: %v", err)
@@ -445,9 +571,39 @@ func middlewareInteractive(evt *socketmode.Event, client *socketmode.Client) {
 	case slack.InteractionTypeBlockActions:
 		// See https://api.slack.com/apis/connections/socket-implement#button
 		client.Debugf("button clicked!")
+
+		for _, action := range callback.ActionCallback.BlockActions {
+			switch {
+			case isAlertAction(action.ActionID):
+				go handleAlertAction(callback, action, client)
+			case action.ActionID == faqFallbackAction:
+				go handleFAQFallback(callback, action, client)
+			case isToolAction(action.ActionID):
+				go handleToolConfirmation(callback, action, client)
+			case action.ActionID == promptOpenAction:
+				go handlePromptOpen(callback, action, client)
+			case action.ActionID == transcriptSummarizeAction:
+				go handleTranscriptSummary(callback, action, client)
+			case action.ActionID == welcomeCheckAction:
+				go handleWelcomeCheck(callback, action, client)
+			}
+		}
 	case slack.InteractionTypeShortcut:
+	case slack.InteractionTypeMessageAction:
+		if callback.CallbackID == summarizeShortcut {
+			go handleSummarizeShortcut(callback, client)
+		}
 	case slack.InteractionTypeViewSubmission:
 		// See https://api.slack.com/apis/connections/socket-implement#modal
+		if callback.View.CallbackID == promptModalID {
+			go handlePromptSubmission(callback, client)
+		}
+		// Saved before the Ack, so a bad template keeps the modal open.
+		if callback.View.CallbackID == welcomeModalID {
+			if resp := handleWelcomeSubmission(callback, client); resp != nil {
+				payload = resp
+			}
+		}
 	case slack.InteractionTypeDialogSubmission:
 	default:
 
@@ -482,6 +638,26 @@ func middlewareSlashCommand(evt *socketmode.Event, client *socketmode.Client) {
 		handleWeatherCommand(evt, client)
 	case "/openai":
 		handleOpenAICommand(evt, client)
+	case "/schedule":
+		handleScheduleCommand(evt, client)
+	case "/time":
+		handleTimeCommand(evt, client)
+	case "/oncall":
+		handleOnCallCommand(evt, client)
+	case "/incident":
+		handleIncidentCommand(evt, client)
+	case "/summarize":
+		handleSummarizeCommand(evt, client)
+	case "/persona":
+		handlePersonaCommand(evt, client)
+	case "/prompt":
+		handlePromptCommand(evt, client)
+	case "/translate":
+		handleTranslateCommand(evt, client)
+	case "/imagine":
+		handleImagineCommand(evt, client)
+	case "/welcome":
+		handleWelcomeCommand(evt, client)
 	default:
 		// If the command is not one of the specified commands, ignore and return
 		fmt.Printf("Ignored %+v\n", evt)
@@ -498,11 +674,13 @@ func handleDadJokeCommand(evt *socketmode.Event, client *socketmode.Client) {
 	}
 	//client.Debugf("Slash command '/dadjoke' received: %+v", evt)
 
+	cmd := evt.Data.(slack.SlashCommand)
+
 	// Add your response logic for the "/dadjoke" command here
 	// Example response with a Dad joke
 	//responseText := "Why don't scientists trust atoms? Because they make up everything! 😄"
 
-	jokeText, err := getDadJoke()
+	jokeText, err := getDadJoke(cmd.ChannelID, cmd.Text)
 	if err != nil {
 		jokeText = "Not a Joke! " + err.Error()
 	}
@@ -532,39 +710,6 @@ func handleDadJokeCommand(evt *socketmode.Event, client *socketmode.Client) {
 	client.Ack(*evt.Request, payload)
 }
 
-type JokeResponse struct {
-	Joke string `json:"joke"`
-}
-
-func getDadJoke() (string, error) {
-	url := "https://icanhazdadjoke.com/"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var jokeResp JokeResponse
-	err = json.Unmarshal(body, &jokeResp)
-	if err != nil {
-		return "", err
-	}
-
-	return jokeResp.Joke, nil
-}
-
 func handleOpenAICommand(evt *socketmode.Event, client *socketmode.Client) {
 
 	if evt == nil || evt.Request == nil {
@@ -575,60 +720,52 @@ func handleOpenAICommand(evt *socketmode.Event, client *socketmode.Client) {
 
 	// Add your response logic for the "/openai" command here
 
-	inputTxt := evt.Data.(slack.SlashCommand).Text
+	cmd := evt.Data.(slack.SlashCommand)
 
-	openaiResponse, openaiErr := getOpenAIResponse(inputTxt)
+	question, ok := screen(&client.Client, cmd.UserID, cmd.ChannelID, cmd.Text)
+	if !ok {
+		client.Ack(*evt.Request)
+		return
+	}
+
+	persona := personaFor(&client.Client, cmd.UserID, cmd.ChannelID, cmd.Command)
+	openaiResponse, openaiErr := getOpenAIAnswer(persona, cmd.ChannelID, question)
 	if openaiErr != nil {
 		openaiResponse = "ResponseError: " + openaiErr.Error()
 	}
 
-	payload := map[string]interface{}{
-		"blocks": []slack.Block{
-			slack.NewSectionBlock(
-				&slack.TextBlockObject{
-					Type: slack.MarkdownType,
-					Text: openaiResponse,
-				},
-				nil,
-				slack.NewAccessory(
-					slack.NewButtonBlockElement(
-						"",
-						"somevalue",
-						&slack.TextBlockObject{
-							Type: slack.PlainTextType,
-							Text: "openai",
-						},
-					),
+	blocks := []slack.Block{
+		slack.NewSectionBlock(
+			&slack.TextBlockObject{
+				Type: slack.MarkdownType,
+				Text: openaiResponse,
+			},
+			nil,
+			slack.NewAccessory(
+				slack.NewButtonBlockElement(
+					"",
+					"somevalue",
+					&slack.TextBlockObject{
+						Type: slack.PlainTextType,
+						Text: "openai",
+					},
 				),
 			),
-		},
+		),
+	}
+	if block := persona.contextBlock(); block != nil {
+		blocks = append(blocks, block)
+	}
+
+	payload := map[string]interface{}{
+		"blocks": blocks,
 	}
 
 	client.Ack(*evt.Request, payload)
 }
 
 func getOpenAIResponse(prompt string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	client := openai.NewClient(apiKey)
-
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-		},
-	)
-
-	if err != nil {
-		return "", err
-	}
-
-	return resp.Choices[0].Message.Content, nil
+	return complete("", prompt)
 }
 
 func handleWeatherCommand(evt *socketmode.Event, client *socketmode.Client) {
@@ -640,31 +777,19 @@ func handleWeatherCommand(evt *socketmode.Event, client *socketmode.Client) {
 
 	client.Debugf("Slash command '/weather' received: %+v", evt)
 
-	// Add your response logic for the "/weather" command here
+	cmd := evt.Data.(slack.SlashCommand)
 
-	// Example response
-	responseText := "102 °F Temperatures are on the up!  the water is warm."
+	blocks, responseText := runWeatherRequest(cmd.UserID, cmd.Text)
 
 	payload := map[string]interface{}{
-		"blocks": []slack.Block{
-			slack.NewSectionBlock(
-				&slack.TextBlockObject{
-					Type: slack.MarkdownType,
-					Text: responseText,
-				},
-				nil,
-				slack.NewAccessory(
-					slack.NewButtonBlockElement(
-						"",
-						"somevalue",
-						&slack.TextBlockObject{
-							Type: slack.PlainTextType,
-							Text: "wet bar",
-						},
-					),
-				),
-			),
-		},
+		"response_type": "in_channel",
+		"blocks":        blocks,
+	}
+	if blocks == nil {
+		payload = map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          responseText,
+		}
 	}
 
 	client.Ack(*evt.Request, payload)
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// On-call rotations.
+//
+// A rotation hands off between its members every day or week at a fixed time
+// in its timezone. Overrides put someone else on call for a stretch of time,
+// and a swap is an override covering the rest of the current shift. When the
+// on-call person changes the bot announces it in the rotation's channel and
+// can keep a Slack user group's membership pointed at them.
+//
+// Rotations live in oncall.json in the data directory and can be edited
+// there or with /oncall.
+
+const (
+	oncallFile     = "oncall.json"
+	oncallInterval = time.Minute
+)
+
+type Rotation struct {
+	Name       string     `json:"name"`
+	Members    []string   `json:"members"` // user IDs, in order
+	Length     string     `json:"length"`  // "daily" or "weekly"
+	HandoffDay string     `json:"handoff_day,omitempty"`
+	Handoff    string     `json:"handoff"` // time of day, "09:00"
+	Timezone   string     `json:"timezone"`
+	Start      time.Time  `json:"start"` // a handoff when Members[0] took over
+	Channel    string     `json:"channel,omitempty"`
+	UserGroup  string     `json:"usergroup,omitempty"`
+	Overrides  []Override `json:"overrides,omitempty"`
+
+	// Who we last announced, to notice handoffs
+	Announced string `json:"announced,omitempty"`
+}
+
+type Override struct {
+	User   string    `json:"user"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (r *Rotation) location() *time.Location {
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func (r *Rotation) periodDays() int {
+	if r.Length == "daily" {
+		return 1
+	}
+	return 7
+}
+
+// shift returns the scheduled shift number at t, before overrides.
+func (r *Rotation) shift(t time.Time) int {
+	loc := r.location()
+	hour, minute, _ := parseClock(r.Handoff)
+
+	// Count whole handoff-to-handoff days between Start and t.
+	civil := func(t time.Time) time.Time {
+		t = t.In(loc)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if t.Hour() < hour || (t.Hour() == hour && t.Minute() < minute) {
+			day = day.AddDate(0, 0, -1)
+		}
+		return day
+	}
+
+	days := int(civil(t).Sub(civil(r.Start)).Hours() / 24)
+	shift := days / r.periodDays()
+	if days < 0 && days%r.periodDays() != 0 {
+		shift--
+	}
+	return shift
+}
+
+func (r *Rotation) member(shift int) string {
+	n := len(r.Members)
+	if n == 0 {
+		return ""
+	}
+	return r.Members[((shift%n)+n)%n]
+}
+
+// OnCall returns who is on call at t.
+func (r *Rotation) OnCall(t time.Time) string {
+	for _, o := range r.Overrides {
+		if !t.Before(o.Start) && t.Before(o.End) {
+			return o.User
+		}
+	}
+	return r.member(r.shift(t))
+}
+
+// NextHandoff returns when the current scheduled shift ends.
+func (r *Rotation) NextHandoff(t time.Time) time.Time {
+	loc := r.location()
+	hour, minute, _ := parseClock(r.Handoff)
+	start := r.Start.In(loc)
+
+	next := time.Date(start.Year(), start.Month(), start.Day(), hour, minute, 0, 0, loc).
+		AddDate(0, 0, (r.shift(t)+1)*r.periodDays())
+	return next
+}
+
+// escalationOrder lists who to page, starting with whoever is on call now.
+func (r *Rotation) escalationOrder(t time.Time) []string {
+	order := []string{r.OnCall(t)}
+	shift := r.shift(t)
+	for i := 1; i < len(r.Members); i++ {
+		if m := r.member(shift + i); m != order[0] {
+			order = append(order, m)
+		}
+	}
+	return order
+}
+
+func (r *Rotation) Describe(now time.Time) string {
+	current := r.OnCall(now)
+	if current == "" {
+		return fmt.Sprintf("*%s*: nobody, the rotation has no members", r.Name)
+	}
+
+	handoff := r.NextHandoff(now)
+	next := r.member(r.shift(now) + 1)
+	text := fmt.Sprintf("*%s*: <@%s> is on call, handing off to <@%s> %s",
+		r.Name, current, next, slackDate(handoff, handoff.Format(time.RFC1123)))
+
+	if current != r.member(r.shift(now)) {
+		text += " (override)"
+	}
+	return text
+}
+
+//---
+
+type oncallStore struct {
+	jsonStore
+	Rotations map[string]*Rotation `json:"rotations"`
+}
+
+var oncall = &oncallStore{Rotations: make(map[string]*Rotation)}
+
+// lock loads the store on first use and locks it.
+func (s *oncallStore) lock() {
+	s.lockJSON(oncallFile, "rotations", s, func() {
+		if s.Rotations == nil {
+			s.Rotations = make(map[string]*Rotation)
+		}
+	})
+}
+
+// save must be called with s.mu held.
+func (s *oncallStore) save() {
+	s.saveLocked(oncallFile, "rotations", s)
+}
+
+// find looks a rotation up by name, must be called with s.mu held.
+func (s *oncallStore) find(name string) (*Rotation, bool) {
+	r, ok := s.Rotations[strings.ToLower(strings.TrimSpace(name))]
+	return r, ok
+}
+
+// currentOnCall returns the escalation order for a rotation, for alerts.
+func currentOnCall(name string) ([]string, bool) {
+	oncall.lock()
+	defer oncall.mu.Unlock()
+
+	r, ok := oncall.find(name)
+	if !ok || len(r.Members) == 0 {
+		return nil, false
+	}
+	return r.escalationOrder(time.Now()), true
+}
+
+//---
+
+// runOnCall announces handoffs and syncs user groups until stop is closed.
+func runOnCall(api *slack.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(oncallInterval)
+	defer ticker.Stop()
+
+	for {
+		// Find handoffs under the lock, and announce them after releasing it.
+		type due struct {
+			r       Rotation
+			current string
+		}
+		var handoffs []due
+
+		oncall.lock()
+		now := time.Now()
+		changed := false
+		for _, r := range oncall.Rotations {
+			// Drop overrides that are over.
+			live := r.Overrides[:0]
+			for _, o := range r.Overrides {
+				if o.End.After(now) {
+					live = append(live, o)
+				}
+			}
+			if len(live) != len(r.Overrides) {
+				r.Overrides, changed = live, true
+			}
+
+			current := r.OnCall(now)
+			if current == "" || current == r.Announced {
+				continue
+			}
+			handoffs = append(handoffs, due{*r, current})
+		}
+		if changed {
+			oncall.save()
+		}
+		oncall.mu.Unlock()
+
+		for _, h := range handoffs {
+			handoff(api, &h.r, h.current)
+		}
+
+		if len(handoffs) > 0 {
+			oncall.lock()
+			for _, h := range handoffs {
+				// Unless it was deleted or announced meanwhile.
+				if r, ok := oncall.Rotations[h.r.Name]; ok && r.Announced == h.r.Announced {
+					r.Announced = h.current
+				}
+			}
+			oncall.save()
+			oncall.mu.Unlock()
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handoff announces that current took over r and moves its user group to
+// them. r is a copy, it's called without holding oncall.mu.
+func handoff(api *slack.Client, r *Rotation, current string) {
+	if r.Channel != "" {
+		text := fmt.Sprintf(":pager: <@%s> is now on call for *%s*.", current, r.Name)
+		if r.Announced != "" {
+			text = fmt.Sprintf(":pager: <@%s> is now on call for *%s*, taking over from <@%s>.", current, r.Name, r.Announced)
+		}
+		if _, _, err := api.PostMessage(r.Channel, slack.MsgOptionText(text, false)); err != nil {
+			fmt.Printf("failed announcing handoff for %s: %v\n", r.Name, err)
+		}
+	}
+
+	if r.UserGroup != "" {
+		if _, err := api.UpdateUserGroupMembers(r.UserGroup, current); err != nil {
+			fmt.Printf("failed syncing user group for %s: %v\n", r.Name, err)
+		}
+	}
+}
+
+//---
+
+var userGroupRe = regexp.MustCompile(`<!subteam\^([A-Z0-9]+)(\|[^>]*)?>`)
+
+// runOnCallRequest handles /oncall and returns the reply. Creating and
+// deleting rotations is for bot admins, overrides and swaps for admins and
+// the rotation's members.
+//
+//	/oncall                                  everyone on call now
+//	/oncall <rotation>                       one rotation
+//	/oncall create <name> @a @b [daily|weekly] [monday] [09:00] [America/New_York] [#channel] [@usergroup]
+//	/oncall override <rotation> @user <2h|until 2026-10-20>
+//	/oncall swap <rotation> @user            @user covers the rest of the current shift
+//	/oncall delete <rotation>
+func runOnCallRequest(api *slack.Client, userID, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return lookupOnCall("")
+	}
+
+	now := time.Now()
+
+	switch strings.ToLower(fields[0]) {
+	case "help":
+		return oncallHelp
+
+	case "create":
+		if len(fields) < 3 {
+			return oncallHelp
+		}
+		if !isAdmin(userID) {
+			return "Sorry, only bot admins can create rotations."
+		}
+		// Users and channels are looked up before taking the lock.
+		r, err := parseRotation(api, userID, fields[1], fields[2:], now)
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+
+		oncall.lock()
+		defer oncall.mu.Unlock()
+		oncall.Rotations[r.Name] = r
+		oncall.save()
+		return "Created. " + r.Describe(now)
+
+	case "delete":
+		if len(fields) < 2 {
+			return oncallHelp
+		}
+		if !isAdmin(userID) {
+			return "Sorry, only bot admins can delete rotations."
+		}
+
+		oncall.lock()
+		defer oncall.mu.Unlock()
+		r, ok := oncall.find(fields[1])
+		if !ok {
+			return fmt.Sprintf("There's no rotation called %s.", fields[1])
+		}
+		delete(oncall.Rotations, r.Name)
+		oncall.save()
+		return fmt.Sprintf("Deleted the %s rotation.", r.Name)
+
+	case "override", "swap":
+		if len(fields) < 3 {
+			return oncallHelp
+		}
+		if err := authorize(api, userID, actionOnCall, fields[1]); err != nil {
+			return "Sorry, " + err.Error() + "."
+		}
+		cover, err := resolveUser(api, fields[2])
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+
+		oncall.lock()
+		defer oncall.mu.Unlock()
+		r, ok := oncall.find(fields[1])
+		if !ok {
+			return fmt.Sprintf("There's no rotation called %s.", fields[1])
+		}
+
+		o := Override{User: cover, Start: now, End: r.NextHandoff(now), Reason: "swap with <@" + userID + ">"}
+		if strings.ToLower(fields[0]) == "override" {
+			if len(fields) < 4 {
+				return "For how long? e.g. `/oncall override " + r.Name + " @someone 4h` or `... until 2026-10-20`."
+			}
+			end, err := overrideEnd(strings.Join(fields[3:], " "), now.In(r.location()))
+			if err != nil {
+				return "Sorry, " + err.Error()
+			}
+			o.End, o.Reason = end, "override by <@"+userID+">"
+		}
+
+		r.Overrides = append(r.Overrides, o)
+		oncall.save()
+		return fmt.Sprintf("<@%s> is on call for %s until %s.", cover, r.Name, slackDate(o.End, o.End.Format(time.RFC1123)))
+	}
+
+	return lookupOnCall(strings.Join(fields, " "))
+}
+
+// lookupOnCall says who is on call for a rotation, or for all of them when
+// name is empty. It never changes anything.
+func lookupOnCall(name string) string {
+	oncall.lock()
+	defer oncall.mu.Unlock()
+
+	now := time.Now()
+
+	if name == "" {
+		if len(oncall.Rotations) == 0 {
+			return "There are no rotations yet. " + oncallHelp
+		}
+		names := make([]string, 0, len(oncall.Rotations))
+		for name := range oncall.Rotations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = oncall.Rotations[name].Describe(now)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	r, ok := oncall.find(name)
+	if !ok {
+		return fmt.Sprintf("There's no rotation called %s. %s", name, oncallHelp)
+	}
+	return r.Describe(now)
+}
+
+// rotationMember reports whether userID is in the named rotation.
+func rotationMember(name, userID string) bool {
+	oncall.lock()
+	defer oncall.mu.Unlock()
+
+	r, ok := oncall.find(name)
+	return ok && contains(r.Members, userID)
+}
+
+const oncallHelp = "Try `/oncall`, `/oncall payments`, " +
+	"`/oncall create payments @alice @bob weekly monday 09:00 America/New_York #payments`, " +
+	"`/oncall override payments @carol 4h`, `/oncall swap payments @dave` or `/oncall delete payments`."
+
+// parseRotation builds a rotation from the words after "/oncall create <name>".
+func parseRotation(api *slack.Client, userID, name string, args []string, now time.Time) (*Rotation, error) {
+	r := &Rotation{
+		Name:     strings.ToLower(name),
+		Length:   "weekly",
+		Handoff:  "09:00",
+		Timezone: getUserLocation(api, userID).String(),
+	}
+
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+		switch {
+		case userMentionRe.MatchString(arg):
+			r.Members = append(r.Members, userMentionRe.FindStringSubmatch(arg)[1])
+		case userGroupRe.MatchString(arg):
+			r.UserGroup = userGroupRe.FindStringSubmatch(arg)[1]
+		case strings.HasPrefix(arg, "<#"), strings.HasPrefix(arg, "#"):
+			channelID, err := resolveChannel(api, arg)
+			if err != nil {
+				return nil, err
+			}
+			r.Channel = channelID
+		case lower == "daily" || lower == "weekly":
+			r.Length = lower
+		case isWeekdayName(lower):
+			r.HandoffDay = weekdayNames[lower].String()
+		case strings.Contains(arg, "/"):
+			if _, err := time.LoadLocation(arg); err != nil {
+				return nil, fmt.Errorf("unknown timezone %s", arg)
+			}
+			r.Timezone = arg
+		default:
+			if _, _, ok := parseClock(arg); ok {
+				r.Handoff = arg
+				continue
+			}
+			userID, err := resolveUser(api, arg)
+			if err != nil {
+				return nil, fmt.Errorf("I don't understand %q", arg)
+			}
+			r.Members = append(r.Members, userID)
+		}
+	}
+
+	if len(r.Members) == 0 {
+		return nil, fmt.Errorf("a rotation needs at least one member")
+	}
+
+	// Anchor the first shift on the most recent handoff, so the first member
+	// is on call from now.
+	loc := r.location()
+	hour, minute, _ := parseClock(r.Handoff)
+	t := now.In(loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, loc)
+	if start.After(t) {
+		start = start.AddDate(0, 0, -1)
+	}
+	if r.Length == "weekly" && r.HandoffDay != "" {
+		for start.Weekday().String() != r.HandoffDay {
+			start = start.AddDate(0, 0, -1)
+		}
+	}
+	r.Start = start
+
+	return r, nil
+}
+
+// overrideEnd parses "4h", "2 days", "until 2026-10-20" or "until friday at 5pm".
+func overrideEnd(text string, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(strings.ToLower(text), "until ") {
+		when, _, err := parseWhen(text[len("until "):], now)
+		return when, err
+	}
+	d, err := parseRelative(text)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(d), nil
+}
+
+// oncallQuestion extracts the rotation from "who is on call for payments".
+func oncallQuestion(text string) (string, bool) {
+	lower := strings.TrimRight(strings.ToLower(strings.TrimSpace(text)), "?")
+	for _, prefix := range []string{"who is on call", "who's on call", "whos on call", "who is oncall", "who's oncall"} {
+		if lower == prefix {
+			return "", true
+		}
+		if strings.HasPrefix(lower, prefix+" for ") {
+			return strings.TrimSpace(lower[len(prefix+" for "):]), true
+		}
+	}
+	return "", false
+}
+
+// handleOnCallMessage answers "who is on call" in a DM. Changing rotations
+// is only done with /oncall.
+func handleOnCallMessage(ev *slackevents.MessageEvent, client *socketmode.Client, rotation string) {
+	response := lookupOnCall(rotation)
+
+	_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+func handleOnCallCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleOnCallCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	// Creating a rotation looks up users and channels, which can take
+	// longer than Slack waits, answer through the response URL.
+	client.Ack(*evt.Request)
+
+	text := runOnCallRequest(&client.Client, cmd.UserID, cmd.Text)
+
+	err := slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{ResponseType: "ephemeral", Text: text})
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
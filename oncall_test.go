@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnCallQuestionIsReadOnly(t *testing.T) {
+	cfg.DataDir = t.TempDir()
+	oncall.lock()
+	oncall.Rotations = map[string]*Rotation{
+		"payments": {Name: "payments", Members: []string{"U0000000A1", "U0000000B2"}, Length: "weekly", Handoff: "09:00", Timezone: "UTC", Start: time.Now().Add(-time.Hour)},
+	}
+	oncall.mu.Unlock()
+
+	for _, text := range []string{"who is on call for delete payments", "who's on call for create x <@U1>"} {
+		rotation, ok := oncallQuestion(text)
+		if !ok {
+			t.Fatalf("oncallQuestion(%q) not recognized", text)
+		}
+		if reply := lookupOnCall(rotation); !strings.HasPrefix(reply, "There's no rotation called") {
+			t.Errorf("lookupOnCall(%q) = %q", rotation, reply)
+		}
+	}
+	if _, ok := oncall.Rotations["payments"]; !ok {
+		t.Fatal("a question deleted the rotation")
+	}
+}
+
+func TestOnCallChangesNeedPermission(t *testing.T) {
+	cfg.DataDir = t.TempDir()
+	oncall.lock()
+	oncall.Rotations = map[string]*Rotation{
+		"payments": {Name: "payments", Members: []string{"U0000000A1", "U0000000B2"}, Length: "weekly", Handoff: "09:00", Timezone: "UTC", Start: time.Now().Add(-time.Hour)},
+	}
+	oncall.mu.Unlock()
+
+	saved := botAdmins
+	botAdmins = map[string]bool{"U00000ADM1": true}
+	t.Cleanup(func() { botAdmins = saved })
+
+	tests := []struct {
+		user, text, want string
+	}{
+		{"U0000000C3", "delete payments", "only bot admins"},
+		{"U0000000C3", "create billing <@U0000000C3>", "only bot admins"},
+		{"U0000000C3", "swap payments <@U0000000C3>", "only the rotation's members"},
+		{"U0000000C3", "override payments <@U0000000C3> 4h", "only the rotation's members"},
+		{"U0000000B2", "override payments <@U0000000B2> 4h", "is on call for payments"},
+		{"U00000ADM1", "delete payments", "Deleted the payments rotation"},
+	}
+	for _, tt := range tests {
+		// None of these reach Slack: mentions and IDs resolve locally.
+		if got := runOnCallRequest(nil, tt.user, tt.text); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: /oncall %s = %q, want %q", tt.user, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRunOnCallAnnouncesHandoff(t *testing.T) {
+	cfg.DataDir = t.TempDir()
+	oncall.lock()
+	oncall.Rotations = map[string]*Rotation{
+		"payments": {Name: "payments", Members: []string{"U0000000A1", "U0000000B2"}, Length: "weekly", Handoff: "09:00", Timezone: "UTC",
+			Start: time.Now().Add(-time.Hour), Channel: "C0000000OPS", Announced: "U0000000B2"},
+	}
+	oncall.mu.Unlock()
+
+	api, fake := newFakeSlack(t)
+	stop := make(chan struct{})
+	close(stop)
+	runOnCall(api, stop) // one pass
+
+	posts := fake.texts("chat.postMessage")
+	if len(posts) != 1 || !strings.Contains(posts[0], "<@U0000000A1> is now on call for *payments*, taking over from <@U0000000B2>") {
+		t.Errorf("announced %q", posts)
+	}
+	oncall.lock()
+	announced := oncall.Rotations["payments"].Announced
+	oncall.mu.Unlock()
+	if announced != "U0000000A1" {
+		t.Errorf("Announced = %s after the handoff", announced)
+	}
+
+	runOnCall(api, stop)
+	if posts := fake.texts("chat.postMessage"); len(posts) != 1 {
+		t.Errorf("announced the same handoff again: %q", posts)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// writeOutput prints rows under header as an aligned table or CSV, or v as
+// JSON, for subcommands whose output ends up in scripts.
+func writeOutput(format string, header []string, rows [][]string, v interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+		return w.Error()
+
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q, use table, csv or json", format)
+}
+
+func truncate(s string, n int) string {
+	return truncateText(strings.ReplaceAll(s, "\n", " "), n)
+}
+
+// truncateText is truncate keeping line breaks, for multi-line mrkdwn.
+func truncateText(s string, n int) string {
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
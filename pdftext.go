@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Text from PDFs.
+//
+// This reads the text layer of ordinary PDFs: it finds the pages, inflates
+// their content streams and pulls out the strings drawn with the text
+// operators, mapping them through the fonts' ToUnicode tables when they
+// have one. Scanned PDFs have no text layer, and fonts with custom encodings
+// and no ToUnicode table come out garbled; pdfPages returns an error for
+// both rather than feeding the model nonsense.
+
+var (
+	pdfObjRe      = regexp.MustCompile(`(?s)(\d+)\s+(\d+)\s+obj\b(.*?)\bendobj`)
+	pdfStreamRe   = regexp.MustCompile(`(?s)^(.*?)stream\r?\n(.*)\bendstream`)
+	pdfRefRe      = regexp.MustCompile(`(\d+)\s+\d+\s+R`)
+	pdfPageRe     = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfPagesRe    = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfKidsRe     = regexp.MustCompile(`(?s)/Kids\s*\[(.*?)\]`)
+	pdfContentsRe = regexp.MustCompile(`(?s)/Contents\s*(\[.*?\]|\d+\s+\d+\s+R)`)
+	pdfParentRe   = regexp.MustCompile(`/Parent\s+\d+\s+\d+\s+R`)
+	pdfIntRe      = regexp.MustCompile(`/(N|First)\s+(\d+)`)
+	pdfLeadRefRe  = regexp.MustCompile(`^(\d+)\s+\d+\s+R`)
+	pdfFontRefRe  = regexp.MustCompile(`/([^\s/<>\[\]()%]+)\s+(\d+)\s+\d+\s+R`)
+	pdfCMapRe     = regexp.MustCompile(`<[0-9A-Fa-f\s]*>|\[|\]|[A-Za-z]+`)
+)
+
+type pdfObject struct {
+	dict   []byte
+	stream []byte
+}
+
+// data returns the object's stream, inflated if needed.
+func (o *pdfObject) data() ([]byte, error) {
+	if o.stream == nil {
+		return nil, nil
+	}
+	if !bytes.Contains(o.dict, []byte("/FlateDecode")) {
+		return o.stream, nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(o.stream))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// Streams are often cut short of their checksum, keep what inflated.
+	data, err := io.ReadAll(r)
+	if len(data) > 0 {
+		err = nil
+	}
+	return data, err
+}
+
+// pdfObjects indexes the file's objects by number, including the ones packed
+// into object streams.
+func pdfObjects(data []byte) map[int]*pdfObject {
+	objects := make(map[int]*pdfObject)
+
+	for _, m := range pdfObjRe.FindAllSubmatch(data, -1) {
+		num, _ := strconv.Atoi(string(m[1]))
+		obj := &pdfObject{dict: m[3]}
+		if s := pdfStreamRe.FindSubmatch(m[3]); s != nil {
+			obj.dict, obj.stream = s[1], s[2]
+		}
+		objects[num] = obj
+	}
+
+	for _, obj := range objects {
+		if !bytes.Contains(obj.dict, []byte("/ObjStm")) {
+			continue
+		}
+		packed, err := obj.data()
+		if err != nil {
+			continue
+		}
+		var n, first int
+		for _, m := range pdfIntRe.FindAllSubmatch(obj.dict, -1) {
+			v, _ := strconv.Atoi(string(m[2]))
+			if string(m[1]) == "N" {
+				n = v
+			} else {
+				first = v
+			}
+		}
+		if first > len(packed) {
+			continue
+		}
+
+		// The header is pairs of object number and offset from first.
+		header := strings.Fields(string(packed[:first]))
+		for i := 0; i+1 < len(header) && i/2 < n; i += 2 {
+			num, err1 := strconv.Atoi(header[i])
+			start, err2 := strconv.Atoi(header[i+1])
+			if err1 != nil || err2 != nil || start < 0 || start > len(packed)-first {
+				continue
+			}
+			end := len(packed)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && next >= start && next <= len(packed)-first {
+					end = first + next
+				}
+			}
+			if _, ok := objects[num]; !ok {
+				objects[num] = &pdfObject{dict: packed[first+start : end]}
+			}
+		}
+	}
+	return objects
+}
+
+func pdfRefs(b []byte) []int {
+	var refs []int
+	for _, m := range pdfRefRe.FindAllSubmatch(b, -1) {
+		num, _ := strconv.Atoi(string(m[1]))
+		refs = append(refs, num)
+	}
+	return refs
+}
+
+// pdfPageOrder returns the page objects in reading order.
+func pdfPageOrder(objects map[int]*pdfObject) []int {
+	var pages []int
+	seen := make(map[int]bool)
+
+	var walk func(num int)
+	walk = func(num int) {
+		obj, ok := objects[num]
+		if !ok || seen[num] {
+			return
+		}
+		seen[num] = true
+		if pdfPagesRe.Match(obj.dict) {
+			if kids := pdfKidsRe.FindSubmatch(obj.dict); kids != nil {
+				for _, kid := range pdfRefs(kids[1]) {
+					walk(kid)
+				}
+			}
+			return
+		}
+		if pdfPageRe.Match(obj.dict) {
+			pages = append(pages, num)
+		}
+	}
+
+	// Start from the root of the page tree, the Pages without a parent.
+	nums := make([]int, 0, len(objects))
+	for num := range objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if d := objects[num].dict; pdfPagesRe.Match(d) && !pdfParentRe.Match(d) {
+			walk(num)
+		}
+	}
+
+	// A broken page tree, fall back to the order in the file.
+	if len(pages) == 0 {
+		for _, num := range nums {
+			if pdfPageRe.Match(objects[num].dict) {
+				pages = append(pages, num)
+			}
+		}
+	}
+	return pages
+}
+
+// pdfPages returns the text of each page.
+func pdfPages(data []byte) ([]string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \r\n\t"), []byte("%PDF")) {
+		return nil, fmt.Errorf("not a PDF")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return nil, fmt.Errorf("the PDF is encrypted")
+	}
+
+	objects := pdfObjects(data)
+	pageNums := pdfPageOrder(objects)
+	if len(pageNums) == 0 {
+		return nil, fmt.Errorf("no pages found")
+	}
+
+	pages := make([]string, len(pageNums))
+	total := 0
+	fontCache := make(map[int]*pdfFont)
+	for i, num := range pageNums {
+		m := pdfContentsRe.FindSubmatch(objects[num].dict)
+		if m == nil {
+			continue
+		}
+		fonts := pdfPageFonts(objects, objects[num], fontCache)
+		for _, f := range fonts {
+			if f.cid && f.toUnicode == nil {
+				return nil, fmt.Errorf("the PDF's fonts don't say which characters they draw, I can't read its text")
+			}
+		}
+		var text strings.Builder
+		for _, ref := range pdfRefs(m[1]) {
+			obj, ok := objects[ref]
+			if !ok {
+				continue
+			}
+			content, err := obj.data()
+			if err != nil {
+				continue
+			}
+			text.WriteString(pdfContentText(content, fonts))
+			text.WriteString("\n")
+		}
+		pages[i] = strings.TrimSpace(text.String())
+		total += len(pages[i])
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("the PDF has no text layer, it may be scanned")
+	}
+	if !readable(strings.Join(pages, "")) {
+		return nil, fmt.Errorf("the PDF's text uses an encoding I can't read")
+	}
+	return pages, nil
+}
+
+// readable reports whether text is mostly printable.
+func readable(text string) bool {
+	bad := 0
+	for _, r := range text {
+		if r == '�' || (r < ' ' && r != '\n' && r != '\t' && r != '\r') {
+			bad++
+		}
+	}
+	return bad*10 < len([]rune(text))
+}
+
+// pdfContentText pulls the text out of a page content stream. fonts are the
+// page's fonts by resource name, nil when they aren't known.
+func pdfContentText(content []byte, fonts map[string]*pdfFont) string {
+	var out, line strings.Builder
+	var pending []string
+	var font *pdfFont
+	name := ""
+	inArray := false
+
+	flush := func() {
+		if s := strings.TrimSpace(line.String()); s != "" {
+			out.WriteString(s)
+			out.WriteString("\n")
+		}
+		line.Reset()
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+
+		case c == '(':
+			raw, n := pdfLiteralString(content[i:])
+			pending = append(pending, font.text(raw, false))
+			i += n
+
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				// Unterminated, take the rest of the stream.
+				end = len(content) - i
+			}
+			pending = append(pending, font.text(pdfHexString(content[i+1:i+end]), true))
+			i += end + 1
+
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+
+		case c == '/':
+			i++
+			start := i
+			for i < len(content) && !pdfDelimiter(content[i]) {
+				i++
+			}
+			name = string(content[start:i])
+
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			for i < len(content) && (content[i] == '.' || (content[i] >= '0' && content[i] <= '9')) {
+				i++
+			}
+			// In a TJ array a large negative gap is a space between words.
+			if inArray {
+				if f, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && f <= -250 {
+					pending = append(pending, " ")
+				}
+			}
+
+		case c == '\'' || c == '"' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '*':
+			start := i
+			i++
+			for i < len(content) && !pdfDelimiter(content[i]) && content[i] != '\'' && content[i] != '"' {
+				i++
+			}
+			op := string(content[start:i])
+
+			switch op {
+			case "Tf":
+				font = fonts[name]
+			case "Tj", "TJ":
+				line.WriteString(strings.Join(pending, ""))
+			case "'", "\"":
+				flush()
+				line.WriteString(strings.Join(pending, ""))
+			case "Td", "TD", "T*", "Tm", "ET":
+				flush()
+			case "ID":
+				// Inline image data runs up to EI.
+				if end := bytes.Index(content[i:], []byte("EI")); end >= 0 {
+					i += end + 2
+				}
+			}
+			pending = pending[:0]
+
+		default:
+			i++
+		}
+	}
+	flush()
+	return out.String()
+}
+
+func pdfDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00()<>[]{}/%", c) >= 0
+}
+
+// pdfLiteralString reads a (string) and returns its bytes and the number of
+// bytes read.
+func pdfLiteralString(b []byte) ([]byte, int) {
+	var s []byte
+	depth := 0
+	i := 0
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r', 't', 'b', 'f':
+				s = append(s, ' ')
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for k := 0; k < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; k++ {
+						n = n*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(n))
+				} else {
+					s = append(s, e)
+				}
+			}
+			continue
+		}
+		s = append(s, c)
+	}
+	return s, i
+}
+
+// pdfHexString decodes the digits of a <hex> string.
+func pdfHexString(b []byte) []byte {
+	hex := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return r
+		}
+		return -1
+	}, string(b))
+	if len(hex)%2 == 1 {
+		hex += "0"
+	}
+
+	raw := make([]byte, len(hex)/2)
+	for i := range raw {
+		v, _ := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		raw[i] = byte(v)
+	}
+	return raw
+}
+
+// pdfFont is what the text extraction needs to know about a font.
+type pdfFont struct {
+	cid       bool              // a Type0 font, its codes are glyph IDs
+	toUnicode map[string]string // text by character code, from ToUnicode
+}
+
+// text decodes a string drawn with the font. Without a ToUnicode table,
+// hex strings that look like UTF-16 are read as such and everything else
+// as PDFDocEncoding, which is close enough to Latin-1 for text.
+func (f *pdfFont) text(raw []byte, hex bool) string {
+	var s strings.Builder
+
+	if f != nil && f.toUnicode != nil {
+		for i := 0; i < len(raw); {
+			n := 4
+			if len(raw)-i < n {
+				n = len(raw) - i
+			}
+			for ; n > 0; n-- {
+				if t, ok := f.toUnicode[string(raw[i:i+n])]; ok {
+					s.WriteString(t)
+					break
+				}
+			}
+			if n == 0 {
+				s.WriteRune('�')
+				n = 1
+				if f.cid {
+					n = 2
+				}
+			}
+			i += n
+		}
+		return s.String()
+	}
+
+	if hex && len(raw) >= 2 && len(raw)%2 == 0 && (raw[0] == 0 || (raw[0] == 0xFE && raw[1] == 0xFF)) {
+		start := 0
+		if raw[0] == 0xFE {
+			start = 2
+		}
+		for i := start; i+1 < len(raw); i += 2 {
+			s.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return s.String()
+	}
+
+	for _, c := range raw {
+		s.WriteRune(rune(c))
+	}
+	return s.String()
+}
+
+// pdfPageFonts returns the page's fonts by resource name. cache holds the
+// fonts already read, by object number.
+func pdfPageFonts(objects map[int]*pdfObject, page *pdfObject, cache map[int]*pdfFont) map[string]*pdfFont {
+	// Resources are inherited from the page tree when the page has none.
+	var resources []byte
+	seen := make(map[int]bool)
+	for dict := page.dict; dict != nil; {
+		if resources = pdfDictValue(objects, dict, "/Resources"); resources != nil {
+			break
+		}
+		parent, ok := pdfDictRef(dict, "/Parent")
+		if !ok || seen[parent] || objects[parent] == nil {
+			break
+		}
+		seen[parent] = true
+		dict = objects[parent].dict
+	}
+
+	fonts := make(map[string]*pdfFont)
+	for _, m := range pdfFontRefRe.FindAllSubmatch(pdfDictValue(objects, resources, "/Font"), -1) {
+		num, _ := strconv.Atoi(string(m[2]))
+		f, ok := cache[num]
+		if !ok {
+			if obj, ok := objects[num]; ok {
+				f = pdfReadFont(objects, obj)
+			}
+			cache[num] = f
+		}
+		if f != nil {
+			fonts[string(m[1])] = f
+		}
+	}
+	return fonts
+}
+
+func pdfReadFont(objects map[int]*pdfObject, obj *pdfObject) *pdfFont {
+	f := &pdfFont{cid: bytes.Contains(obj.dict, []byte("/Type0"))}
+	if num, ok := pdfDictRef(obj.dict, "/ToUnicode"); ok {
+		if cmap, ok := objects[num]; ok {
+			if data, err := cmap.data(); err == nil {
+				f.toUnicode = pdfToUnicode(data)
+			}
+		}
+	}
+	return f
+}
+
+// pdfToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap.
+func pdfToUnicode(data []byte) map[string]string {
+	const maxEntries = 1 << 16
+
+	m := make(map[string]string)
+	section := ""
+	var operands [][]byte
+	inArray := false
+	var array [][]byte
+
+	for _, tok := range pdfCMapRe.FindAll(data, -1) {
+		switch {
+		case tok[0] == '<':
+			code := pdfHexString(tok[1 : len(tok)-1])
+			if inArray {
+				array = append(array, code)
+				continue
+			}
+			operands = append(operands, code)
+		case tok[0] == '[':
+			inArray, array = true, nil
+			continue
+		case tok[0] == ']':
+			inArray = false
+		default:
+			section, operands = string(tok), nil
+			continue
+		}
+
+		switch section {
+		case "beginbfchar":
+			if len(operands) == 2 {
+				m[string(operands[0])] = pdfUTF16(operands[1])
+				operands = nil
+			}
+		case "beginbfrange":
+			if len(operands) == 2 && tok[0] == ']' {
+				lo, hi := operands[0], operands[1]
+				for k, dst := range array {
+					code, ok := pdfCodeAdd(lo, k)
+					if !ok || pdfCodeCmp(code, hi) > 0 || len(m) >= maxEntries {
+						break
+					}
+					m[string(code)] = pdfUTF16(dst)
+				}
+				operands = nil
+			} else if len(operands) == 3 {
+				lo, hi, dst := operands[0], operands[1], operands[2]
+				for k := 0; len(m) < maxEntries; k++ {
+					code, ok := pdfCodeAdd(lo, k)
+					if !ok || pdfCodeCmp(code, hi) > 0 {
+						break
+					}
+					// The destination's last byte counts up with the code.
+					next, ok := pdfCodeAdd(dst, k)
+					if !ok {
+						break
+					}
+					m[string(code)] = pdfUTF16(next)
+				}
+				operands = nil
+			}
+		}
+		if len(operands) > 3 {
+			operands = nil
+		}
+	}
+	return m
+}
+
+// pdfCodeAdd adds k to a big-endian character code, keeping its length.
+func pdfCodeAdd(code []byte, k int) ([]byte, bool) {
+	out := append([]byte(nil), code...)
+	carry := k
+	for i := len(out) - 1; i >= 0 && carry > 0; i-- {
+		sum := int(out[i]) + carry
+		out[i] = byte(sum)
+		carry = sum >> 8
+	}
+	return out, carry == 0 && len(out) > 0
+}
+
+func pdfCodeCmp(a, b []byte) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return bytes.Compare(a, b)
+}
+
+// pdfUTF16 decodes the UTF-16BE text of a ToUnicode destination.
+func pdfUTF16(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfDictRef returns the object number key refers to in dict.
+func pdfDictRef(dict []byte, key string) (int, bool) {
+	rest := pdfDictRest(dict, key)
+	m := pdfLeadRefRe.FindSubmatch(rest)
+	if m == nil {
+		return 0, false
+	}
+	num, err := strconv.Atoi(string(m[1]))
+	return num, err == nil
+}
+
+// pdfDictValue returns the dictionary key holds in dict, either inline or
+// in the object it refers to.
+func pdfDictValue(objects map[int]*pdfObject, dict []byte, key string) []byte {
+	rest := pdfDictRest(dict, key)
+	if bytes.HasPrefix(rest, []byte("<<")) {
+		depth := 0
+		for i := 0; i+1 < len(rest); i++ {
+			switch {
+			case rest[i] == '<' && rest[i+1] == '<':
+				depth++
+				i++
+			case rest[i] == '>' && rest[i+1] == '>':
+				depth--
+				i++
+				if depth == 0 {
+					return rest[:i+1]
+				}
+			}
+		}
+		return rest
+	}
+	if num, ok := pdfDictRef(dict, key); ok {
+		if obj, ok := objects[num]; ok {
+			return obj.dict
+		}
+	}
+	return nil
+}
+
+// pdfDictRest returns what follows key in dict, or nil if it isn't there.
+func pdfDictRest(dict []byte, key string) []byte {
+	for i := 0; i < len(dict); {
+		j := bytes.Index(dict[i:], []byte(key))
+		if j < 0 {
+			return nil
+		}
+		end := i + j + len(key)
+		if end == len(dict) || pdfDelimiter(dict[end]) {
+			return bytes.TrimLeft(dict[end:], " \t\r\n\f")
+		}
+		i = end
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPDF numbers objects from 1 in the order given, leaving out empty ones.
+func testPDF(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	for i, obj := range objects {
+		if obj == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer << /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func testStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func testFlate(data string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(data))
+	w.Close()
+	return b.Bytes()
+}
+
+// testSimplePDF is a one page PDF drawing content with a plain Helvetica.
+func testSimplePDF(content string) []byte {
+	return testPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		testStream("", []byte(content)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+}
+
+func TestPDFPages(t *testing.T) {
+	toUnicode := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"2 beginbfchar <0003> <0020> <0011> <00E9> endbfchar\n" +
+		"2 beginbfrange <0024> <0026> <0041> <0030> <0031> [<0078> <0079>] endbfrange\n" +
+		"endcmap CMapName currentdict /CMap defineresource pop end end"
+
+	// Pages 3 and 4 packed into an object stream, as newer writers do.
+	page3 := "<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>"
+	page4 := "<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>"
+	header := fmt.Sprintf("3 0 4 %d ", len(page3)+1)
+	packed := header + page3 + " " + page4
+
+	tests := []struct {
+		name  string
+		pdf   []byte
+		pages []string
+		err   string
+	}{
+		{
+			name:  "literal strings",
+			pdf:   testSimplePDF("BT /F1 12 Tf 72 720 Td (Hello \\(world\\)) Tj 0 -14 Td (caf\\351) Tj ET"),
+			pages: []string{"Hello (world)\ncafé"},
+		},
+		{
+			name:  "hex strings",
+			pdf:   testSimplePDF("BT /F1 12 Tf <48656c6c6f> Tj T* <FEFF00480069> Tj ET"),
+			pages: []string{"Hello\nHi"},
+		},
+		{
+			name:  "TJ spacing",
+			pdf:   testSimplePDF("BT /F1 12 Tf [(Hel) 20 (lo) -300 (world)] TJ ET"),
+			pages: []string{"Hello world"},
+		},
+		{
+			name: "FlateDecode",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				testStream("/Filter /FlateDecode", testFlate("BT (compressed text) Tj ET")),
+			),
+			pages: []string{"compressed text"},
+		},
+		{
+			name: "page tree order",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [4 0 R 3 0 R] /Count 2 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+				testStream("", []byte("BT (second) Tj ET")),
+				testStream("", []byte("BT (first) Tj ET")),
+			),
+			pages: []string{"first", "second"},
+		},
+		{
+			name: "object stream",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+				"",
+				"",
+				testStream(fmt.Sprintf("/Type /ObjStm /N 2 /First %d /Filter /FlateDecode", len(header)), testFlate(packed)),
+				testStream("", []byte("BT (packed one) Tj ET")),
+				testStream("", []byte("BT (packed two) Tj ET")),
+			),
+			pages: []string{"packed one", "packed two"},
+		},
+		{
+			name: "broken page tree",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [9 0 R] /Count 1 >>",
+				"<< /Type /Page /Contents 4 0 R >>",
+				testStream("", []byte("BT (orphan) Tj ET")),
+			),
+			pages: []string{"orphan"},
+		},
+		{
+			name: "Identity-H with ToUnicode",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 5 0 R >> >> >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				testStream("", []byte("BT /F1 11 Tf <002400250026000300300031000300110011> Tj ET")),
+				"<< /Type /Font /Subtype /Type0 /BaseFont /ABCDEF+Arial /Encoding /Identity-H /ToUnicode 6 0 R >>",
+				testStream("", []byte(toUnicode)),
+			),
+			pages: []string{"ABC xy éé"},
+		},
+		{
+			name: "Identity-H without ToUnicode",
+			pdf: testPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Resources 6 0 R /Contents 4 0 R >>",
+				testStream("", []byte("BT /F1 11 Tf <00480065006c006c006f> Tj ET")),
+				"<< /Type /Font /Subtype /Type0 /BaseFont /ABCDEF+Arial /Encoding /Identity-H >>",
+				"<< /Font << /F1 5 0 R >> >>",
+			),
+			err: "fonts don't say which characters",
+		},
+		{
+			name: "no text layer",
+			pdf:  testSimplePDF("q 612 0 0 792 0 0 cm /Im1 Do Q"),
+			err:  "no text layer",
+		},
+		{
+			name: "not a PDF",
+			pdf:  []byte("PK\x03\x04 a zip file"),
+			err:  "not a PDF",
+		},
+		{
+			name: "encrypted",
+			pdf:  append(testSimplePDF("BT (secret) Tj ET"), "trailer << /Encrypt 9 0 R >>"...),
+			err:  "encrypted",
+		},
+		{
+			name: "truncated",
+			pdf:  testSimplePDF("BT (hi) Tj ET")[:60],
+			err:  "no pages found",
+		},
+	}
+
+	for _, tt := range tests {
+		pages, err := pdfPages(tt.pdf)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(pages, tt.pages) {
+			t.Errorf("%s: pages %q, want %q", tt.name, pages, tt.pages)
+		}
+	}
+}
+
+func TestPDFContentText(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{"BT (hi) Tj ET <", "hi\n"},
+		{"BT (hi) Tj ET <4142", "hi\n"},
+		{"BT (unterminated", ""},
+		{"BT (a (nested) b) Tj ET", "a (nested) b\n"},
+		{"BT (one) Tj T* (two) Tj ET", "one\ntwo\n"},
+		{"BT (line one) ' (line two) ' ET", "line one\nline two\n"},
+		{"BT (split \\\nline) Tj ET", "split line\n"},
+		{"% a comment (not text) Tj\nBT (text) Tj ET", "text\n"},
+		{"BI /W 1 /H 1 ID (junk) Tj EI BT (after) Tj ET", "after\n"},
+		{"BT <<>> (dict) Tj ET", "dict\n"},
+	}
+	for _, tt := range tests {
+		if got := pdfContentText([]byte(tt.content), nil); got != tt.want {
+			t.Errorf("pdfContentText(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func FuzzPDFContentText(f *testing.F) {
+	for _, seed := range []string{
+		"BT /F1 12 Tf (Hello) Tj ET",
+		"BT [(a) -300 (b)] TJ ET <",
+		"BT <FEFF0048> Tj ET",
+		"BI ID \x00\xff EI",
+		"(\\",
+		"(\\777",
+	} {
+		f.Add([]byte(seed))
+	}
+	fonts := map[string]*pdfFont{
+		"F1": {cid: true, toUnicode: map[string]string{"\x00\x24": "A"}},
+	}
+	f.Fuzz(func(t *testing.T, content []byte) {
+		pdfContentText(content, nil)
+		pdfContentText(content, fonts)
+	})
+}
+
+func FuzzPDFPages(f *testing.F) {
+	f.Add(testSimplePDF("BT /F1 12 Tf (Hello) Tj ET"))
+	f.Add(testPDF(
+		"<< /Type /Pages /Kids [2 0 R] >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 3 0 R >>",
+		testStream("/Type /ObjStm /N 99 /First 4", []byte("2 -5 9 99999999999999999999 <<>>")),
+	))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pdfPages(data)
+	})
+}
+
+func TestPDFToUnicode(t *testing.T) {
+	cmap := "begincodespacerange <00> <FF> endcodespacerange\n" +
+		"1 beginbfchar <01> <D83DDE00> endbfchar\n" +
+		"1 beginbfrange <10> <FF> <0061> endbfrange\n" +
+		"1 beginbfrange <0000> <FFFF> <0041> endbfrange"
+	m := pdfToUnicode([]byte(cmap))
+	if m["\x01"] != "😀" {
+		t.Errorf("surrogate pair mapped to %q", m["\x01"])
+	}
+	if m["\x10"] != "a" || m["\x12"] != "c" {
+		t.Errorf("range mapped to %q, %q", m["\x10"], m["\x12"])
+	}
+	if len(m) > 1<<16 {
+		t.Errorf("%d entries, the ranges should be capped", len(m))
+	}
+}
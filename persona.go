@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Personas: the system prompt, model and limits used for answers.
+//
+// personas.json in the data directory defines them and where they apply:
+//
+//	{
+//	  "personas": {
+//	    "support": {"system": "You are our friendly support bot...", "model": "gpt-4",
+//	                "temperature": 0.2, "max_tokens": 500, "tools": ["get_time", "get_weather"]}
+//	  },
+//	  "channels":   {"C0123456789": "support"},
+//	  "usergroups": {"S0123456789": "support"},
+//	  "commands":   {"/openai": "support"},
+//	  "default":    "support"
+//	}
+//
+// A slash command's persona wins over the channel's, which wins over the
+// user's user groups, then the default. "tools" limits which tools the
+// persona may use, leave it out for all of them or make it [] for none.
+// Admins can view and set a channel's persona with /persona.
+
+const personasFile = "personas.json"
+
+type Persona struct {
+	Name        string   `json:"-"`
+	System      string   `json:"system"`
+	Model       string   `json:"model,omitempty"`
+	Temperature float32  `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Tools       []string `json:"tools"`
+}
+
+type personaStore struct {
+	jsonStore
+	Personas   map[string]*Persona `json:"personas"`
+	Channels   map[string]string   `json:"channels"`
+	UserGroups map[string]string   `json:"usergroups"`
+	Commands   map[string]string   `json:"commands"`
+	Default    string              `json:"default,omitempty"`
+}
+
+var personas = &personaStore{}
+
+// lock loads the store on first use and locks it.
+func (s *personaStore) lock() {
+	s.lockJSON(personasFile, "personas", s, func() {
+		if s.Personas == nil {
+			s.Personas = make(map[string]*Persona)
+		}
+		if s.Channels == nil {
+			s.Channels = make(map[string]string)
+		}
+		if s.UserGroups == nil {
+			s.UserGroups = make(map[string]string)
+		}
+		if s.Commands == nil {
+			s.Commands = make(map[string]string)
+		}
+		for name, p := range s.Personas {
+			p.Name = name
+		}
+	})
+}
+
+// save must be called with s.mu held.
+func (s *personaStore) save() {
+	s.saveLocked(personasFile, "personas", s)
+}
+
+// defaultPersona is used when no persona applies.
+var defaultPersona = &Persona{
+	System: "You are a helpful Slack bot. Use the tools when the user asks for something they can do, " +
+		"otherwise answer directly.",
+}
+
+// personaFor picks the persona for a request. command is the slash command,
+// empty for messages.
+func personaFor(api *slack.Client, userID, channelID, command string) *Persona {
+	p, _ := personaSource(api, userID, channelID, command)
+	return p
+}
+
+// personaSource picks the persona for a request and says where the choice
+// came from: "the /openai command", "this channel", a user group or "the
+// default".
+func personaSource(api *slack.Client, userID, channelID, command string) (*Persona, string) {
+	personas.lock()
+	if p, ok := personas.Personas[personas.Commands[command]]; ok && command != "" {
+		personas.mu.Unlock()
+		return p, "the " + command + " command"
+	}
+	if p, ok := personas.Personas[personas.Channels[channelID]]; ok {
+		personas.mu.Unlock()
+		return p, "this channel"
+	}
+
+	// User groups in a stable order, so the pick doesn't change between calls.
+	type groupPersona struct {
+		group   string
+		persona *Persona
+	}
+	var groups []groupPersona
+	for group, name := range personas.UserGroups {
+		if p, ok := personas.Personas[name]; ok {
+			groups = append(groups, groupPersona{group, p})
+		}
+	}
+	fallback, hasDefault := personas.Personas[personas.Default]
+	personas.mu.Unlock()
+
+	// Membership is looked up in Slack, outside the lock.
+	sort.Slice(groups, func(i, j int) bool { return groups[i].group < groups[j].group })
+	for _, g := range groups {
+		if inUserGroup(api, userID, g.group) {
+			return g.persona, "your user group <!subteam^" + g.group + ">"
+		}
+	}
+
+	if hasDefault {
+		return fallback, "the default"
+	}
+	return defaultPersona, "the default"
+}
+
+// request builds a chat request with the persona's settings.
+func (p *Persona) request(system string, messages ...openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	if p.System != "" {
+		system = strings.TrimSpace(p.System + "\n\n" + system)
+	}
+
+	model := p.Model
+	if model == "" {
+		model = llmModel
+	}
+
+	return openai.ChatCompletionRequest{
+		Model:       model,
+		Temperature: p.Temperature,
+		MaxTokens:   p.MaxTokens,
+		Messages:    append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: system}}, messages...),
+	}
+}
+
+// allows reports whether the persona may use a tool.
+func (p *Persona) allows(tool string) bool {
+	if p.Tools == nil {
+		return true
+	}
+	return contains(p.Tools, tool)
+}
+
+// contextBlock names the persona under a reply, nil for the default.
+func (p *Persona) contextBlock() slack.Block {
+	if p.Name == "" {
+		return nil
+	}
+	return slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "_persona: "+p.Name+"_", false, false))
+}
+
+//---
+
+// Cache user group members, they change rarely and the API is rate limited.
+var userGroupCache = struct {
+	sync.Mutex
+	members map[string][]string
+	fetched map[string]time.Time
+}{members: make(map[string][]string), fetched: make(map[string]time.Time)}
+
+func inUserGroup(api *slack.Client, userID, group string) bool {
+	userGroupCache.Lock()
+	defer userGroupCache.Unlock()
+
+	if time.Since(userGroupCache.fetched[group]) > 10*time.Minute {
+		members, err := api.GetUserGroupMembers(group)
+		if err != nil {
+			fmt.Printf("failed listing user group %s: %v\n", group, err)
+			return false
+		}
+		userGroupCache.members[group] = members
+		userGroupCache.fetched[group] = time.Now()
+	}
+	return contains(userGroupCache.members[group], userID)
+}
+
+//---
+
+// runPersonaRequest handles /persona and returns the reply.
+//
+//	/persona              the persona you get here, and why
+//	/persona list         all personas
+//	/persona set <name>   use a persona in this channel (admins)
+//	/persona clear        go back to the default (admins)
+func runPersonaRequest(api *slack.Client, userID, channelID, text string) string {
+	fields := strings.Fields(text)
+
+	if len(fields) == 0 {
+		p, source := personaSource(api, userID, channelID, "")
+		if p.Name == "" {
+			return "You get the default persona here. " + personaHelp
+		}
+		return fmt.Sprintf("You get *%s* here, from %s:\n>%s", p.Name, source, p.System)
+	}
+
+	personas.lock()
+	defer personas.mu.Unlock()
+
+	switch strings.ToLower(fields[0]) {
+	case "list":
+		if len(personas.Personas) == 0 {
+			return "There are no personas, define them in " + personasFile + " in the data directory."
+		}
+		names := make([]string, 0, len(personas.Personas))
+		for name := range personas.Personas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = fmt.Sprintf("*%s*: %s", name, truncate(personas.Personas[name].System, 100))
+		}
+		return strings.Join(lines, "\n")
+
+	case "set", "clear":
+		if !isAdmin(userID) {
+			return "Sorry, only bot admins can change personas."
+		}
+		if strings.ToLower(fields[0]) == "clear" {
+			delete(personas.Channels, channelID)
+			personas.save()
+			return "This channel now uses the default persona."
+		}
+		if len(fields) < 2 {
+			return personaHelp
+		}
+		name := fields[1]
+		if _, ok := personas.Personas[name]; !ok {
+			return fmt.Sprintf("There's no persona called %s, see `/persona list`.", name)
+		}
+		personas.Channels[channelID] = name
+		personas.save()
+		return fmt.Sprintf("This channel now uses *%s*.", name)
+	}
+
+	return personaHelp
+}
+
+const personaHelp = "Try `/persona`, `/persona list`, `/persona set <name>` or `/persona clear`."
+
+func handlePersonaCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handlePersonaCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	payload := map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          runPersonaRequest(&client.Client, cmd.UserID, cmd.ChannelID, cmd.Text),
+	}
+
+	client.Ack(*evt.Request, payload)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	embedfs "embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Prompt templates.
+//
+// A template is a .tmpl file with a short header and a text/template body:
+//
+//	---
+//	name: translate
+//	version: 2
+//	description: Translate text into another language
+//	params: language, text
+//	---
+//	Translate the following text into {{.language}}: {{.text}}
+//
+// The built-in templates in prompts/ are compiled in, and templates in the
+// prompts directory under cfg.DataDir are added to them. Several versions of
+// a template can exist side by side, the highest is used unless one is asked
+// for with name@version. Run them with /prompt, from a modal, or from the
+// list in App Home.
+
+const (
+	promptsDir         = "prompts"
+	promptModalID      = "prompt_modal"
+	promptOpenAction   = "prompt_open"
+	promptValueAction  = "value"
+	promptParamBlockID = "param_"
+)
+
+//go:embed prompts/*.tmpl
+var builtinPrompts embedfs.FS
+
+type PromptTemplate struct {
+	Name        string
+	Version     int
+	Description string
+	Params      []string
+	Body        string
+	Source      string
+}
+
+// ID is name@version.
+func (t *PromptTemplate) ID() string {
+	return fmt.Sprintf("%s@%d", t.Name, t.Version)
+}
+
+var templateParamRe = regexp.MustCompile(`{{\s*\.(\w+)\s*}}`)
+
+// parsePromptTemplate reads a template file.
+func parsePromptTemplate(source string, data []byte) (*PromptTemplate, error) {
+	t := &PromptTemplate{Source: source, Version: 1}
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if strings.HasPrefix(text, "---\n") {
+		end := strings.Index(text[4:], "\n---\n")
+		if end < 0 {
+			return nil, fmt.Errorf("%s: unterminated header", source)
+		}
+		for _, line := range strings.Split(text[4:4+end], "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			switch strings.TrimSpace(key) {
+			case "name":
+				t.Name = value
+			case "version":
+				v, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: bad version %q", source, value)
+				}
+				t.Version = v
+			case "description":
+				t.Description = value
+			case "params":
+				for _, p := range strings.Split(value, ",") {
+					if p = strings.TrimSpace(p); p != "" {
+						t.Params = append(t.Params, p)
+					}
+				}
+			}
+		}
+		text = text[4+end+len("\n---\n"):]
+	}
+	t.Body = strings.TrimSpace(text)
+
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	}
+	// Without a params line, take them from the body in order.
+	if t.Params == nil {
+		for _, m := range templateParamRe.FindAllStringSubmatch(t.Body, -1) {
+			t.Params = appendUnique(t.Params, m[1])
+		}
+	}
+
+	if _, err := template.New(t.Name).Parse(t.Body); err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return t, nil
+}
+
+// loadPromptTemplates returns every template version, by name and then
+// newest first.
+func loadPromptTemplates() []*PromptTemplate {
+	var list []*PromptTemplate
+
+	add := func(fsys fs.FS, dir string) {
+		paths, _ := fs.Glob(fsys, dir+"/*.tmpl")
+		for _, path := range paths {
+			data, err := fs.ReadFile(fsys, path)
+			if err == nil {
+				var t *PromptTemplate
+				if t, err = parsePromptTemplate(path, data); err == nil {
+					list = append(list, t)
+					continue
+				}
+			}
+			fmt.Printf("skipping prompt template %s: %v\n", path, err)
+		}
+	}
+	add(builtinPrompts, promptsDir)
+	add(os.DirFS(cfg.DataDir), promptsDir)
+
+	sort.SliceStable(list, func(i, k int) bool {
+		if list[i].Name != list[k].Name {
+			return list[i].Name < list[k].Name
+		}
+		return list[i].Version > list[k].Version
+	})
+
+	// A data directory template replaces a built-in one with the same version.
+	kept := list[:0]
+	for i, t := range list {
+		if i > 0 && list[i-1].ID() == t.ID() {
+			kept[len(kept)-1] = t
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept
+}
+
+// findPromptTemplate looks up "name" (the newest version) or "name@version".
+func findPromptTemplate(id string) (*PromptTemplate, bool) {
+	name, version, _ := strings.Cut(id, "@")
+	for _, t := range loadPromptTemplates() {
+		if t.Name == name && (version == "" || strconv.Itoa(t.Version) == version) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// latestPromptTemplates returns the newest version of each template.
+func latestPromptTemplates() []*PromptTemplate {
+	var latest []*PromptTemplate
+	for _, t := range loadPromptTemplates() {
+		if len(latest) == 0 || latest[len(latest)-1].Name != t.Name {
+			latest = append(latest, t)
+		}
+	}
+	return latest
+}
+
+// promptVersions lists the versions of a template, newest first.
+func promptVersions(name string) []string {
+	var versions []string
+	for _, t := range loadPromptTemplates() {
+		if t.Name == name {
+			versions = append(versions, strconv.Itoa(t.Version))
+		}
+	}
+	return versions
+}
+
+// Render fills in the template.
+func (t *PromptTemplate) Render(values map[string]string) (string, error) {
+	tmpl, err := template.New(t.Name).Option("missingkey=zero").Parse(t.Body)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// parseArgs fills a template's parameters from "/prompt name" text:
+// leading key=value words fill the named parameters, the rest of the text
+// fills the last one that's still empty.
+func (t *PromptTemplate) parseArgs(text string) map[string]string {
+	values := make(map[string]string)
+
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		word := strings.Fields(rest)[0]
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || !contains(t.Params, key) {
+			break
+		}
+		values[key] = value
+		rest = strings.TrimSpace(rest[len(word):])
+	}
+
+	for i := len(t.Params) - 1; i >= 0 && rest != ""; i-- {
+		if _, ok := values[t.Params[i]]; !ok {
+			values[t.Params[i]] = rest
+			break
+		}
+	}
+	return values
+}
+
+// runPrompt renders a template and sends it to the model.
+func runPrompt(api *slack.Client, userID, channelID string, t *PromptTemplate, values map[string]string) string {
+	var missing []string
+	for _, p := range t.Params {
+		if strings.TrimSpace(values[p]) == "" {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("%s needs %s.", t.Name, strings.Join(missing, ", "))
+	}
+
+	prompt, err := t.Render(values)
+	if err != nil {
+		return "Sorry, the template failed: " + err.Error()
+	}
+	prompt, ok := screen(api, userID, channelID, prompt)
+	if !ok {
+		return "I didn't run " + t.Name + "."
+	}
+
+	persona := personaFor(api, userID, channelID, "/prompt")
+	resp, err := llm.CreateChatCompletion(context.Background(),
+		persona.request("", openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}))
+	if err != nil {
+		return "ResponseError: " + err.Error()
+	}
+	if len(resp.Choices) == 0 {
+		return "ResponseError: the model returned no answer"
+	}
+	return fmt.Sprintf("*%s* (v%d)\n%s", t.Name, t.Version, resp.Choices[0].Message.Content)
+}
+
+func promptList() string {
+	templates := latestPromptTemplates()
+	if len(templates) == 0 {
+		return "There are no prompt templates."
+	}
+
+	lines := make([]string, len(templates))
+	for i, t := range templates {
+		lines[i] = fmt.Sprintf("`%s` v%s: %s (%s)", t.Name, strings.Join(promptVersions(t.Name), ", v"), t.Description, strings.Join(t.Params, ", "))
+	}
+	return strings.Join(lines, "\n") + "\nRun one with `/prompt <name> <text>`, or just `/prompt <name>` for a form."
+}
+
+//---
+
+// promptModal builds the form for a template, one field per parameter.
+func promptModal(t *PromptTemplate, channelID string) slack.ModalViewRequest {
+	plain := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+
+	blocks := []slack.Block{}
+	if t.Description != "" {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, t.Description, false, false)))
+	}
+	for _, p := range t.Params {
+		input := slack.NewPlainTextInputBlockElement(nil, promptValueAction)
+		input.Multiline = true
+		blocks = append(blocks, slack.NewInputBlock(promptParamBlockID+p, plain(p), nil, input))
+	}
+
+	metadata, _ := json.Marshal(map[string]string{"template": t.ID(), "channel": channelID})
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      promptModalID,
+		Title:           plain(truncate(t.Name, 24)),
+		Submit:          plain("Run"),
+		Close:           plain("Cancel"),
+		Blocks:          slack.Blocks{BlockSet: blocks},
+		PrivateMetadata: string(metadata),
+	}
+}
+
+// handlePromptSubmission runs the template from a submitted modal and sends
+// the result to the channel it was opened from, or a DM.
+func handlePromptSubmission(callback slack.InteractionCallback, client *socketmode.Client) {
+	api := &client.Client
+
+	var metadata map[string]string
+	json.Unmarshal([]byte(callback.View.PrivateMetadata), &metadata)
+
+	t, ok := findPromptTemplate(metadata["template"])
+	if !ok {
+		fmt.Printf("prompt template %s is gone\n", metadata["template"])
+		return
+	}
+
+	values := make(map[string]string)
+	for blockID, actions := range callback.View.State.Values {
+		if strings.HasPrefix(blockID, promptParamBlockID) {
+			values[strings.TrimPrefix(blockID, promptParamBlockID)] = actions[promptValueAction].Value
+		}
+	}
+
+	user := callback.User.ID
+	channelID := metadata["channel"]
+	text := runPrompt(api, user, channelID, t, values)
+
+	if channelID != "" {
+		if _, err := api.PostEphemeral(channelID, user, slack.MsgOptionText(text, false)); err == nil {
+			return
+		}
+	}
+	dm, err := openDirectMessage(api, user)
+	if err == nil {
+		_, _, err = api.PostMessage(dm, slack.MsgOptionText(text, false))
+	}
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// handlePromptOpen opens the form for a template from its App Home button.
+func handlePromptOpen(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	t, ok := findPromptTemplate(action.Value)
+	if !ok {
+		return
+	}
+	if _, err := client.Client.OpenView(callback.TriggerID, promptModal(t, "")); err != nil {
+		fmt.Printf("failed opening prompt form: %v\n", err)
+	}
+}
+
+// publishPromptHome lists the templates on the user's App Home tab.
+func publishPromptHome(api *slack.Client, userID string) {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Prompt templates", false, false)),
+	}
+
+	for _, t := range latestPromptTemplates() {
+		text := fmt.Sprintf("*%s*  _v%s_\n%s", t.Name, strings.Join(promptVersions(t.Name), ", v"), t.Description)
+		if len(t.Params) > 0 {
+			text += "\nParameters: " + strings.Join(t.Params, ", ")
+		}
+		button := slack.NewButtonBlockElement(promptOpenAction, t.ID(), slack.NewTextBlockObject(slack.PlainTextType, "Use", false, false))
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, slack.NewAccessory(button)))
+	}
+
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+		"Or type `/prompt <name> <text>` anywhere.", false, false)))
+
+	view := slack.HomeTabViewRequest{Type: slack.VTHomeTab, Blocks: slack.Blocks{BlockSet: blocks}}
+	if _, err := api.PublishView(userID, view, ""); err != nil {
+		fmt.Printf("failed publishing App Home: %v\n", err)
+	}
+}
+
+// handlePromptCommand handles /prompt [list | <name> [text]].
+func handlePromptCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handlePromptCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+	api := &client.Client
+
+	reply := func(text string) {
+		client.Ack(*evt.Request, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          text,
+		})
+	}
+
+	name, text, _ := strings.Cut(strings.TrimSpace(cmd.Text), " ")
+	if name == "" || name == "list" || name == "help" {
+		reply(promptList())
+		return
+	}
+
+	t, ok := findPromptTemplate(name)
+	if !ok {
+		reply(fmt.Sprintf("There's no template called %s.\n%s", name, promptList()))
+		return
+	}
+
+	if strings.TrimSpace(text) == "" && len(t.Params) > 0 {
+		client.Ack(*evt.Request)
+		if _, err := api.OpenView(cmd.TriggerID, promptModal(t, cmd.ChannelID)); err != nil {
+			fmt.Printf("failed opening prompt form: %v\n", err)
+		}
+		return
+	}
+
+	// The model takes longer than Slack waits, answer through the response URL.
+	reply("Running " + t.ID() + "...")
+
+	result := runPrompt(api, cmd.UserID, cmd.ChannelID, t, t.parseArgs(text))
+	err := slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{ResponseType: "ephemeral", Text: result})
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
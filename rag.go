@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Answers grounded in our own documents.
+//
+// Before a question goes to the model the collections scoped to the channel
+// are searched and the closest chunks are added to the prompt, with the
+// reply citing where they came from. rag.json in the data directory maps
+// channels to collections:
+//
+//	{
+//	  "channels": {"C0123456789": ["payments"]},
+//	  "default": ["handbook"],
+//	  "top_k": 4,
+//	  "min_score": 0.75
+//	}
+//
+// Channels not listed use "default", including DMs.
+
+const ragFile = "rag.json"
+
+type RAGConfig struct {
+	Channels map[string][]string `json:"channels"`
+	Default  []string            `json:"default"`
+	TopK     int                 `json:"top_k"`
+	MinScore float32             `json:"min_score"`
+}
+
+var (
+	ragConfigOnce sync.Once
+	ragConfig     = RAGConfig{TopK: 4, MinScore: 0.75}
+)
+
+func loadRAGConfig() RAGConfig {
+	ragConfigOnce.Do(func() {
+		if err := loadJSON(ragFile, &ragConfig); err != nil {
+			fmt.Printf("failed loading %s: %v\n", ragFile, err)
+		}
+		// Without a config, use the default collection if one was indexed.
+		if ragConfig.Channels == nil && ragConfig.Default == nil {
+			if _, err := os.Stat(collectionPath("default")); err == nil {
+				ragConfig.Default = []string{"default"}
+			}
+		}
+	})
+	return ragConfig
+}
+
+// collectionsFor returns the collections a channel's questions search.
+func collectionsFor(channelID string) []string {
+	conf := loadRAGConfig()
+	if names, ok := conf.Channels[channelID]; ok {
+		return names
+	}
+	return conf.Default
+}
+
+// retrieve returns the chunks most relevant to the question, best first.
+func retrieve(channelID, question string) ([]SearchResult, error) {
+	names := collectionsFor(channelID)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := embed([]string{question})
+	if err != nil {
+		return nil, err
+	}
+
+	conf := loadRAGConfig()
+	var results []SearchResult
+	for _, name := range names {
+		c, err := cachedCollection(name)
+		if err != nil {
+			fmt.Printf("failed loading collection %s: %v\n", name, err)
+			continue
+		}
+		for _, r := range c.Search(vectors[0], conf.TopK) {
+			if r.Score >= conf.MinScore {
+				results = append(results, r)
+			}
+		}
+	}
+
+	// Keep the best top_k across collections.
+	sort.Slice(results, func(i, k int) bool { return results[i].Score > results[k].Score })
+	if len(results) > conf.TopK {
+		results = results[:conf.TopK]
+	}
+	return results, nil
+}
+
+// getOpenAIAnswer answers a question as the persona, using the channel's
+// documents when any are relevant and citing them.
+func getOpenAIAnswer(persona *Persona, channelID, question string) (string, error) {
+	system, prompt, sources := withDocuments(channelID, question)
+
+	resp, err := llm.CreateChatCompletion(context.Background(),
+		persona.request(system, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("the model returned no answer")
+	}
+	return resp.Choices[0].Message.Content + sources, nil
+}
+
+// withDocuments returns the system prompt and prompt for a question, with
+// the channel's relevant excerpts added, and the sources line for the reply.
+// Without relevant documents the question goes as it is.
+func withDocuments(channelID, question string) (system, prompt, sources string) {
+	results, err := retrieve(channelID, question)
+	if err != nil {
+		fmt.Printf("failed searching documents: %v\n", err)
+	}
+	if len(results) == 0 {
+		return "", question, ""
+	}
+
+	var context strings.Builder
+	labels := make([]string, len(results))
+	for i, r := range results {
+		fmt.Fprintf(&context, "[%d] %s\n%s\n\n", i+1, sourceLabel(r.Chunk), r.Text)
+		labels[i] = fmt.Sprintf("[%d] %s", i+1, sourceLabel(r.Chunk))
+	}
+
+	system = "Answer using the numbered excerpts from our internal documents when they are relevant, " +
+		"citing them like [1]. If they don't answer the question, say so and answer from general knowledge."
+	prompt = "Excerpts:\n\n" + context.String() + "Question: " + question
+	sources = "\n\n_Sources: " + strings.Join(labels, ", ") + "_"
+	return system, prompt, sources
+}
+
+// sourceLabel names a chunk for citations: file name, heading and line.
+func sourceLabel(c Chunk) string {
+	label := fmt.Sprintf("%s:%d", filepath.Base(c.Source), c.Line)
+	if c.Title != "" {
+		label += " (" + c.Title + ")"
+	}
+	return label
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+)
+
+// Redaction and moderation of what's sent to the model.
+//
+// Tokens, keys, email addresses and phone numbers are masked in everything
+// that goes to the model. Where a person's message is sent, they are told
+// what was masked, and with LLM_MODERATION set the message must also pass
+// OpenAI's moderation check first. redact.json in the data directory adds
+// patterns and can turn built-in ones off:
+//
+//	{
+//	  "patterns": [{"name": "customer ID", "regex": "CUST-[0-9]{6}"}],
+//	  "disabled": ["phone number"]
+//	}
+
+const redactFile = "redact.json"
+
+var moderationEnabled = os.Getenv("LLM_MODERATION") != ""
+
+type RedactPattern struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+
+	re *regexp.Regexp
+	// group is the submatch to mask, 0 for all of it.
+	group int
+}
+
+// Built-in patterns, the most specific first so a key isn't half masked as
+// something else.
+var builtinRedactPatterns = []*RedactPattern{
+	{Name: "private key", Regex: `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{Name: "Slack token", Regex: `\bxox[abposre]-[A-Za-z0-9-]{10,}|\bxapp-[A-Za-z0-9-]{10,}`},
+	{Name: "OpenAI key", Regex: `\bsk-[A-Za-z0-9_-]{20,}`},
+	{Name: "AWS access key", Regex: `\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`},
+	{Name: "AWS secret key", Regex: `(?i)aws.{0,20}(?:secret|key).{0,20}?[=:]\s*"?([A-Za-z0-9/+=]{40})\b`, group: 1},
+	{Name: "GitHub token", Regex: `\bgh[pousr]_[A-Za-z0-9]{36,}\b`},
+	{Name: "email address", Regex: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	// Phone numbers either start with + or look like (415) 555-2671 or
+	// 415-555-2671. Dots aren't taken as separators, they are IP addresses
+	// and version numbers far more often.
+	{Name: "phone number", Regex: `\+\d{1,3}(?:[ -]?\(\d{1,4}\))?(?:[ -]?\d{2,5}){2,5}\b|(?:\b1[ -])?(?:\(\d{3}\)[ -]?|\b\d{3}[ -])\d{3}[ -]\d{4}\b`},
+}
+
+func init() {
+	for _, p := range builtinRedactPatterns {
+		p.re = regexp.MustCompile(p.Regex)
+	}
+}
+
+var (
+	redactPatternsOnce sync.Once
+	redactPatterns     []*RedactPattern
+)
+
+// loadRedactPatterns returns the built-in patterns that are on plus the
+// configured ones.
+func loadRedactPatterns() []*RedactPattern {
+	redactPatternsOnce.Do(func() {
+		var conf struct {
+			Patterns []*RedactPattern `json:"patterns"`
+			Disabled []string         `json:"disabled"`
+		}
+		if err := loadJSON(redactFile, &conf); err != nil {
+			fmt.Printf("failed loading %s: %v\n", redactFile, err)
+		}
+
+		for _, p := range builtinRedactPatterns {
+			if !contains(conf.Disabled, p.Name) {
+				redactPatterns = append(redactPatterns, p)
+			}
+		}
+		for _, p := range conf.Patterns {
+			re, err := regexp.Compile(p.Regex)
+			if err != nil {
+				fmt.Printf("skipping redaction pattern %q: %v\n", p.Name, err)
+				continue
+			}
+			p.re = re
+			redactPatterns = append(redactPatterns, p)
+		}
+	})
+	return redactPatterns
+}
+
+// redact masks sensitive text and counts what it masked, by pattern name.
+func redact(text string) (string, map[string]int) {
+	found := make(map[string]int)
+	for _, p := range loadRedactPatterns() {
+		placeholder := "[redacted " + p.Name + "]"
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.group == 0 {
+				found[p.Name]++
+				return placeholder
+			}
+			// Mask only the group, keeping the label before it.
+			m := p.re.FindStringSubmatchIndex(match)
+			if m == nil || m[2*p.group] < 0 {
+				return match
+			}
+			found[p.Name]++
+			return match[:m[2*p.group]] + placeholder + match[m[2*p.group+1]:]
+		})
+	}
+	return text, found
+}
+
+// describeRedactions says what was masked: "an OpenAI key and 2 email addresses".
+func describeRedactions(found map[string]int) string {
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		if n := found[name]; n == 1 {
+			article := "a"
+			if strings.ContainsRune("aeiouAEIOU", rune(name[0])) {
+				article = "an"
+			}
+			parts[i] = article + " " + name
+		} else if strings.HasSuffix(name, "s") {
+			parts[i] = fmt.Sprintf("%d %ses", n, name)
+		} else {
+			parts[i] = fmt.Sprintf("%d %ss", n, name)
+		}
+	}
+
+	if len(parts) <= 1 {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
+
+// moderate returns the categories the text is flagged for, if any.
+func moderate(text string) ([]string, error) {
+	resp, err := llm.Moderations(context.Background(), openai.ModerationRequest{Input: text})
+	if err != nil {
+		return nil, err
+	}
+
+	var flagged []string
+	for _, r := range resp.Results {
+		if !r.Flagged {
+			continue
+		}
+		var categories map[string]bool
+		b, _ := json.Marshal(r.Categories)
+		json.Unmarshal(b, &categories)
+		for name, on := range categories {
+			if on {
+				flagged = appendUnique(flagged, name)
+			}
+		}
+		if len(flagged) == 0 {
+			flagged = append(flagged, "policy")
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
+
+// screen prepares a person's message for the model: it masks sensitive text
+// and tells them what was masked, and runs the moderation check when it's
+// on. ok is false when the message shouldn't be sent, they have been told why.
+func screen(api *slack.Client, userID, channelID, text string) (clean string, ok bool) {
+	tell := func(msg string) {
+		if _, err := api.PostEphemeral(channelID, userID, slack.MsgOptionText(msg, false)); err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+
+	clean, found := redact(text)
+	if len(found) > 0 {
+		fmt.Printf("masked %v in a message from %s\n", found, userID)
+		tell(fmt.Sprintf(":lock: I masked %s before sending your message to the AI.", describeRedactions(found)))
+	}
+
+	if moderationEnabled {
+		flagged, err := moderate(clean)
+		if err != nil {
+			fmt.Printf("failed moderating message: %v\n", err)
+			tell("Sorry, I couldn't check your message, so I didn't send it to the AI. Please try again.")
+			return "", false
+		}
+		if len(flagged) > 0 {
+			fmt.Printf("message from %s flagged for %v\n", userID, flagged)
+			tell(fmt.Sprintf("Sorry, your message was flagged by content moderation (%s), so I didn't send it to the AI.", strings.Join(flagged, ", ")))
+			return "", false
+		}
+	}
+	return clean, true
+}
+
+//---
+
+// redactingProvider masks sensitive text in every request, whatever sent it.
+type redactingProvider struct {
+	LLMProvider
+}
+
+func (p redactingProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		m.Content, _ = redact(m.Content)
+		messages[i] = m
+	}
+	req.Messages = messages
+	return p.LLMProvider.CreateChatCompletion(ctx, req)
+}
+
+func (p redactingProvider) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	req := conv.Convert()
+	if texts, ok := req.Input.([]string); ok {
+		clean := make([]string, len(texts))
+		for i, text := range texts {
+			clean[i], _ = redact(text)
+		}
+		return p.LLMProvider.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Input: clean, Model: req.Model, User: req.User})
+	}
+	return p.LLMProvider.CreateEmbeddings(ctx, conv)
+}
+
+func (p redactingProvider) GenerateImage(ctx context.Context, req ImageGeneration) (GeneratedImage, error) {
+	req.Prompt, _ = redact(req.Prompt)
+	return p.LLMProvider.GenerateImage(ctx, req)
+}
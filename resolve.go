@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// openDirectMessage opens (or finds) the DM channel between the bot and a user.
+func openDirectMessage(api *slack.Client, userID string) (string, error) {
+	channel, _, _, err := api.OpenConversation(&slack.OpenConversationParameters{
+		Users: []string{userID},
+	})
+	if err != nil {
+		return "", err
+	}
+	return channel.ID, nil
+}
+
+// resolveUser turns <@U123>, U123, @name or name into a user ID. Names are
+// matched against the username, display name and real name.
+func resolveUser(api *slack.Client, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if m := userMentionRe.FindStringSubmatch(ref); m != nil {
+		return m[1], nil
+	}
+
+	name := strings.TrimPrefix(ref, "@")
+	if looksLikeID(name, "U", "W") {
+		return name, nil
+	}
+
+	users, err := api.GetUsers()
+	if err != nil {
+		return "", fmt.Errorf("listing users: %v", err)
+	}
+
+	for _, user := range users {
+		if user.Deleted {
+			continue
+		}
+		if strings.EqualFold(user.Name, name) ||
+			strings.EqualFold(user.Profile.DisplayName, name) ||
+			strings.EqualFold(user.RealName, name) {
+			return user.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("no user named %q", name)
+}
+
+// resolveChannel turns a channel reference into something chat.postMessage
+// accepts: <#C123|name>, #name and IDs for channels, and <@U123>, @name or a
+// user ID for a DM with that user.
+func resolveChannel(api *slack.Client, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+
+	switch {
+	case strings.HasPrefix(ref, "<#"):
+		return parseChannelRef(ref), nil
+
+	case strings.HasPrefix(ref, "<@"), strings.HasPrefix(ref, "@"), looksLikeID(ref, "U", "W"):
+		userID, err := resolveUser(api, ref)
+		if err != nil {
+			return "", err
+		}
+		return openDirectMessage(api, userID)
+
+	case looksLikeID(ref, "C", "G", "D"):
+		return ref, nil
+	}
+
+	name := strings.TrimPrefix(ref, "#")
+	channels, err := getAllConversations(api, &slack.GetConversationsParameters{
+		Types:           []string{"public_channel", "private_channel"},
+		ExcludeArchived: true,
+		Limit:           1000,
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing channels: %v", err)
+	}
+
+	for _, channel := range channels {
+		if strings.EqualFold(channel.Name, name) {
+			return channel.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("no channel named #%s", name)
+}
+
+// parseChannelRef extracts the ID from a channel mention like <#C123|general>.
+func parseChannelRef(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "<#") {
+		s = strings.TrimPrefix(s, "<#")
+		s = strings.TrimSuffix(s, ">")
+		s = strings.Split(s, "|")[0]
+	}
+	return s
+}
+
+// looksLikeID reports whether s is a Slack ID starting with one of prefixes,
+// e.g. C024BE91L. Channel names are lower case, so upper case means an ID.
+func looksLikeID(s string, prefixes ...string) bool {
+	if len(s) < 9 || strings.ToUpper(s) != s {
+		return false
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Reminders and scheduled messages.
+//
+// One-off jobs are handed to Slack with chat.scheduleMessage so they are
+// delivered even if the bot is down at the time. Recurring jobs, and one-off
+// jobs Slack refuses (too soon, too far out), are run by the scheduler loop.
+// Either way every job is kept in the job store so it can be listed and
+// cancelled, and the store is persisted to disk to survive restarts.
+
+const (
+	jobsFile          = "jobs.json"
+	schedulerInterval = 20 * time.Second
+
+	// chat.scheduleMessage only accepts times up to 120 days out
+	maxSlackScheduleAhead = 120 * 24 * time.Hour
+	// and rejects anything that's about to happen
+	minSlackScheduleAhead = 2 * time.Minute
+)
+
+// Job is a reminder or scheduled message.
+type Job struct {
+	ID         string      `json:"id"`
+	UserID     string      `json:"user_id"`    // who asked for it
+	ChannelID  string      `json:"channel_id"` // where it gets posted
+	Text       string      `json:"text"`
+	Reminder   bool        `json:"reminder"` // post as "Reminder: ..." to the user
+	Timezone   string      `json:"timezone"`
+	NextRun    time.Time   `json:"next_run"`
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+
+	// Set when delivery was handed to chat.scheduleMessage. The ID is only
+	// needed to cancel and may not be known even though Slack has the message.
+	SlackScheduled     bool   `json:"slack_scheduled,omitempty"`
+	ScheduledMessageID string `json:"scheduled_message_id,omitempty"`
+
+	Created time.Time `json:"created"`
+}
+
+func (j *Job) location() *time.Location {
+	loc, err := time.LoadLocation(j.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func (j *Job) message() string {
+	if j.Reminder {
+		return "⏰ Reminder: " + j.Text
+	}
+	return j.Text
+}
+
+// Describe renders a job as a single line for "list reminders".
+func (j *Job) Describe() string {
+	when := j.NextRun.In(j.location()).Format("Mon Jan 2 15:04 MST")
+	if j.Recurrence != nil {
+		when = j.Recurrence.String() + " (next " + when + ")"
+	}
+
+	where := "to you"
+	if !j.Reminder {
+		where = "in <#" + j.ChannelID + ">"
+	}
+
+	return fmt.Sprintf("`%s` %s %s: %s", j.ID, when, where, j.Text)
+}
+
+type jobStore struct {
+	jsonStore
+	Jobs map[string]*Job `json:"jobs"`
+}
+
+var jobs = &jobStore{Jobs: make(map[string]*Job)}
+
+// lock loads the store on first use and locks it.
+func (s *jobStore) lock() {
+	s.lockJSON(jobsFile, "jobs", s, func() {
+		if s.Jobs == nil {
+			s.Jobs = make(map[string]*Job)
+		}
+	})
+}
+
+// save must be called with s.mu held.
+func (s *jobStore) save() {
+	s.saveLocked(jobsFile, "jobs", s)
+}
+
+func (s *jobStore) add(job *Job) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.Jobs[job.ID] = job
+	s.save()
+}
+
+func (s *jobStore) remove(id string) (*Job, bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	job, ok := s.Jobs[id]
+	if ok {
+		delete(s.Jobs, id)
+		s.save()
+	}
+	return job, ok
+}
+
+// forUser returns the user's jobs, soonest first.
+func (s *jobStore) forUser(userID string) []*Job {
+	s.lock()
+	defer s.mu.Unlock()
+
+	var list []*Job
+	for _, job := range s.Jobs {
+		if job.UserID == userID {
+			list = append(list, job)
+		}
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].NextRun.Before(list[k].NextRun) })
+	return list
+}
+
+// due removes and returns the jobs the scheduler loop has to post now.
+// Recurring jobs are re-armed rather than removed, and one-off jobs that
+// Slack already delivered are pruned.
+func (s *jobStore) due(now time.Time) []Job {
+	s.lock()
+	defer s.mu.Unlock()
+
+	var list []Job
+	changed := false
+	for id, job := range s.Jobs {
+		if job.NextRun.After(now) {
+			continue
+		}
+		changed = true
+
+		if !job.SlackScheduled && job.ScheduledMessageID == "" {
+			list = append(list, *job)
+		}
+
+		if job.Recurrence != nil {
+			// Skip occurrences missed while the bot was down rather than replaying them all.
+			job.NextRun = job.Recurrence.Next(now.In(job.location()))
+		} else {
+			delete(s.Jobs, id)
+		}
+	}
+
+	if changed {
+		s.save()
+	}
+	return list
+}
+
+// runScheduler posts due jobs until stop is closed.
+func runScheduler(api *slack.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, job := range jobs.due(time.Now()) {
+			_, _, err := api.PostMessage(job.ChannelID, slack.MsgOptionText(job.message(), false))
+			if err != nil {
+				fmt.Printf("failed posting scheduled job %s: %v\n", job.ID, err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleJob stores the job, delegating one-off jobs to chat.scheduleMessage
+// when Slack will take them.
+func scheduleJob(api *slack.Client, job *Job) {
+	ahead := time.Until(job.NextRun)
+	if job.Recurrence == nil && ahead > minSlackScheduleAhead && ahead < maxSlackScheduleAhead {
+		postAt := strconv.FormatInt(job.NextRun.Unix(), 10)
+		_, _, err := api.ScheduleMessage(job.ChannelID, postAt, slack.MsgOptionText(job.message(), false))
+		if err != nil {
+			fmt.Printf("chat.scheduleMessage failed, using internal scheduler: %v\n", err)
+		} else {
+			job.SlackScheduled = true
+			job.ScheduledMessageID = findScheduledMessageID(api, job.ChannelID, job.NextRun, job.message())
+		}
+	}
+
+	jobs.add(job)
+}
+
+// findScheduledMessageID looks up the id of a message we just scheduled, the
+// client library doesn't hand back the one chat.scheduleMessage returns.
+func findScheduledMessageID(api *slack.Client, channelID string, postAt time.Time, text string) string {
+	messages, _, err := api.GetScheduledMessages(&slack.GetScheduledMessagesParameters{Channel: channelID})
+	if err != nil {
+		fmt.Printf("failed listing scheduled messages: %v\n", err)
+		return ""
+	}
+
+	for _, m := range messages {
+		if int64(m.PostAt) == postAt.Unix() && m.Text == text {
+			return m.ID
+		}
+	}
+	return ""
+}
+
+// cancelJob removes one of userID's jobs, also withdrawing it from Slack.
+func cancelJob(api *slack.Client, userID, id string) error {
+	for _, job := range jobs.forUser(userID) {
+		if job.ID != id {
+			continue
+		}
+
+		if job.SlackScheduled || job.ScheduledMessageID != "" {
+			messageID := job.ScheduledMessageID
+			if messageID == "" {
+				messageID = findScheduledMessageID(api, job.ChannelID, job.NextRun, job.message())
+			}
+			if messageID == "" {
+				return fmt.Errorf("Slack has `%s` scheduled and I couldn't find it there to cancel", id)
+			}
+			_, err := api.DeleteScheduledMessage(&slack.DeleteScheduledMessageParameters{
+				Channel:            job.ChannelID,
+				ScheduledMessageID: messageID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed cancelling with Slack: %v", err)
+			}
+		}
+
+		jobs.remove(id)
+		return nil
+	}
+
+	return fmt.Errorf("you have no reminder or scheduled message `%s`", id)
+}
+
+// isScheduleRequest reports whether a direct message is meant for the scheduler.
+func isScheduleRequest(text string) bool {
+	lower := strings.ToLower(strings.TrimSpace(text))
+	return strings.HasPrefix(lower, "remind me ") ||
+		(strings.HasPrefix(lower, "every ") && strings.Contains(lower, " post ")) ||
+		lower == "list reminders" || lower == "my reminders" ||
+		strings.HasPrefix(lower, "cancel reminder ")
+}
+
+// runScheduleRequest handles the text of a DM or a /schedule command and
+// returns the reply for the user.
+func runScheduleRequest(api *slack.Client, userID, replyChannel, text string) string {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+
+	switch {
+	case lower == "" || lower == "help":
+		return scheduleHelp
+
+	case lower == "list" || lower == "list reminders" || lower == "my reminders":
+		list := jobs.forUser(userID)
+		if len(list) == 0 {
+			return "You have no reminders or scheduled messages."
+		}
+		lines := make([]string, len(list))
+		for i, job := range list {
+			lines[i] = job.Describe()
+		}
+		return strings.Join(lines, "\n")
+
+	case strings.HasPrefix(lower, "cancel "):
+		id := strings.TrimSpace(text[strings.LastIndex(text, " ")+1:])
+		if err := cancelJob(api, userID, id); err != nil {
+			return "Couldn't cancel: " + err.Error()
+		}
+		return "Cancelled `" + id + "`."
+	}
+
+	loc := getUserLocation(api, userID)
+	now := time.Now().In(loc)
+
+	job := &Job{
+		ID:       newID(),
+		UserID:   userID,
+		Timezone: loc.String(),
+		Created:  time.Now(),
+	}
+
+	if i := strings.Index(lower, " post "); i >= 0 {
+		// "every weekday at 9am post Standup time! in #team"
+		when, rec, err := parseWhen(text[:i], now)
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+
+		rest := text[i+len(" post "):]
+		k := strings.LastIndex(rest, " in ")
+		if k < 0 {
+			return "Say which channel to post in, e.g. \"every weekday at 9am post Standup! in #team\""
+		}
+
+		channelID, err := resolveChannel(api, rest[k+len(" in "):])
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+		if err := authorize(api, userID, actionPost, channelID); err != nil {
+			return "Sorry, " + err.Error()
+		}
+
+		job.Text = strings.TrimSpace(rest[:k])
+		job.ChannelID = channelID
+		job.NextRun, job.Recurrence = when, rec
+	} else {
+		// "remind me in 2 hours to stretch", or "/schedule tomorrow at 9am to stretch"
+		spec := text
+		if strings.HasPrefix(lower, "remind me ") {
+			spec = text[len("remind me "):]
+		}
+
+		when, rec, task, err := splitWhen(spec, now)
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+
+		job.Text = task
+		job.Reminder = true
+		job.ChannelID = replyChannel
+		job.NextRun, job.Recurrence = when, rec
+	}
+
+	if job.Text == "" || job.ChannelID == "" {
+		return scheduleHelp
+	}
+
+	scheduleJob(api, job)
+
+	return "OK, " + job.Describe()
+}
+
+const scheduleHelp = "Try:\n" +
+	"• `remind me in 2 hours to stretch`\n" +
+	"• `remind me tomorrow at 9am to send the report`\n" +
+	"• `every weekday at 9am post Standup time! in #team`\n" +
+	"• `list reminders`, `cancel reminder <id>`\n" +
+	"or the same with `/schedule`, e.g. `/schedule list`."
+
+func handleScheduleMessage(ev *slackevents.MessageEvent, client *socketmode.Client) {
+	text := ev.Text
+	if strings.HasPrefix(strings.ToLower(text), "cancel reminder ") {
+		text = "cancel " + strings.TrimSpace(text[len("cancel reminder "):])
+	}
+
+	response := runScheduleRequest(&client.Client, ev.User, ev.Channel, text)
+
+	_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+func handleScheduleCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleScheduleCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	// Reminders from the slash command are delivered in a DM with the bot.
+	replyChannel := cmd.ChannelID
+	channel, _, _, err := client.Client.OpenConversation(&slack.OpenConversationParameters{
+		Users: []string{cmd.UserID},
+	})
+	if err != nil {
+		fmt.Printf("Failed opening channel: %v", err)
+	} else {
+		replyChannel = channel.ID
+	}
+
+	responseText := runScheduleRequest(&client.Client, cmd.UserID, replyChannel, cmd.Text)
+
+	payload := map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          responseText,
+	}
+
+	client.Ack(*evt.Request, payload)
+}
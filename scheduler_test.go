@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobsDue(t *testing.T) {
+	cfg.DataDir = t.TempDir()
+	jobs = &jobStore{Jobs: make(map[string]*Job)}
+
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Minute)
+	jobs.add(&Job{ID: "internal", ChannelID: "C1", Text: "ours", NextRun: past})
+	jobs.add(&Job{ID: "slack", ChannelID: "C1", Text: "Slack's", NextRun: past, SlackScheduled: true})
+	jobs.add(&Job{ID: "slack-id", ChannelID: "C1", Text: "Slack's, with an ID", NextRun: past, ScheduledMessageID: "Q1"})
+	jobs.add(&Job{ID: "later", ChannelID: "C1", Text: "not yet", NextRun: now.Add(time.Hour)})
+
+	due := jobs.due(now)
+	if len(due) != 1 || due[0].ID != "internal" {
+		t.Fatalf("due = %+v, want only the internal job", due)
+	}
+	// Jobs Slack delivered are pruned all the same.
+	if _, ok := jobs.Jobs["slack"]; ok {
+		t.Errorf("Slack-scheduled job still stored")
+	}
+	if _, ok := jobs.Jobs["later"]; !ok {
+		t.Errorf("future job was dropped")
+	}
+}
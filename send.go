@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Exit codes for send, so CI can tell a typo from a Slack outage.
+const (
+	exitUsage      = 2 // bad flags or input
+	exitResolve    = 3 // channel or user not found
+	exitSlackError = 4 // the Slack API call failed
+)
+
+// exitError carries a process exit code up to main.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func exitWith(code int, format string, a ...interface{}) error {
+	return &exitError{code: code, err: fmt.Errorf(format, a...)}
+}
+
+const sendUsage = `usage: slack-bot send -channel <where> [-text <text> | -stdin | -blocks <file.json> | -file <path>] [-thread-ts <ts>]
+
+<where> is #channel, a channel ID, @user or a user ID (sent as a DM).
+Use -stdin, or "-" as the -text, -blocks or -file value, to read stdin.`
+
+// sendCommand posts a message, Block Kit blocks or a file as the bot.
+func sendCommand(args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, sendUsage)
+		flags.PrintDefaults()
+	}
+	channel := flags.String("channel", "", "#channel, channel ID, @user or user ID")
+	text := flags.String("text", "", "message text, or the comment on an uploaded file")
+	blocksPath := flags.String("blocks", "", "JSON file with Block Kit blocks")
+	filePath := flags.String("file", "", "file to upload")
+	title := flags.String("title", "", "title for the uploaded file")
+	threadTS := flags.String("thread-ts", "", "reply in the thread with this parent ts")
+	join := flags.Bool("join", false, "join the public channel first if the bot isn't a member")
+	stdin := flags.Bool("stdin", false, "read the message text from stdin, same as -text -")
+	flags.Parse(args)
+
+	if *channel == "" {
+		return exitWith(exitUsage, "-channel is required\n%s", sendUsage)
+	}
+
+	// Stdin is only read when asked for, cron and CI jobs often leave it open.
+	if *stdin {
+		if *text != "" {
+			return exitWith(exitUsage, "use either -text or -stdin\n%s", sendUsage)
+		}
+		*text = "-"
+	}
+	if *text == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return exitWith(exitUsage, "reading stdin: %v", err)
+		}
+		*text = strings.TrimRight(string(data), "\n")
+	}
+
+	if *text == "" && *blocksPath == "" && *filePath == "" {
+		return exitWith(exitUsage, "nothing to send, use -text, -blocks or -file\n%s", sendUsage)
+	}
+
+	if err := cfg.require("bot"); err != nil {
+		return exitWith(exitUsage, "%v", err)
+	}
+	api := newSlackClient(false)
+
+	channelID, err := resolveChannel(api, *channel)
+	if err != nil {
+		return exitWith(exitResolve, "%v", err)
+	}
+
+	if *join && strings.HasPrefix(channelID, "C") {
+		if _, _, _, err := api.JoinConversation(channelID); err != nil {
+			return exitWith(exitSlackError, "joining %s: %v", *channel, err)
+		}
+	}
+
+	if *filePath != "" {
+		return sendFile(api, channelID, *filePath, *title, *text, *threadTS)
+	}
+
+	options := []slack.MsgOption{slack.MsgOptionText(*text, false)}
+	if *blocksPath != "" {
+		blocks, err := readBlocks(*blocksPath)
+		if err != nil {
+			return exitWith(exitUsage, "%v", err)
+		}
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+	if *threadTS != "" {
+		options = append(options, slack.MsgOptionTS(*threadTS))
+	}
+
+	respChannel, ts, err := api.PostMessage(channelID, options...)
+	if err != nil {
+		return exitWith(exitSlackError, "posting message: %v", err)
+	}
+
+	// Print what scripts need to thread follow-ups under this message.
+	fmt.Printf("%s %s\n", respChannel, ts)
+	return nil
+}
+
+func sendFile(api *slack.Client, channelID, path, title, comment, threadTS string) error {
+	var reader io.Reader
+	size := 0
+	name := filepath.Base(path)
+
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return exitWith(exitUsage, "reading stdin: %v", err)
+		}
+		reader, size, name = strings.NewReader(string(data)), len(data), "stdin.txt"
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return exitWith(exitUsage, "%v", err)
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return exitWith(exitUsage, "%v", err)
+		}
+		reader, size = f, int(info.Size())
+	}
+
+	if title == "" {
+		title = name
+	}
+
+	file, err := api.UploadFileV2(slack.UploadFileV2Parameters{
+		Reader:          reader,
+		FileSize:        size,
+		Filename:        name,
+		Title:           title,
+		InitialComment:  comment,
+		Channel:         channelID,
+		ThreadTimestamp: threadTS,
+	})
+	if err != nil {
+		return exitWith(exitSlackError, "uploading %s: %v", path, err)
+	}
+
+	fmt.Printf("%s %s\n", channelID, file.ID)
+	return nil
+}
+
+// readBlocks accepts a bare array of blocks, or the {"blocks": [...]} object
+// Block Kit Builder produces.
+func readBlocks(path string) ([]slack.Block, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var wrapper struct {
+			Blocks json.RawMessage `json:"blocks"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+		data = wrapper.Blocks
+	}
+
+	var blocks slack.Blocks
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if len(blocks.BlockSet) == 0 {
+		return nil, errors.New(path + " has no blocks")
+	}
+	return blocks.BlockSet, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// simulateCommand feeds direct messages through the same handler the bot
+// uses, against a stand-in for the Slack Web API that prints what the bot
+// would post. Handy for trying out rules without a workspace.
+func simulateCommand(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	user := flags.String("user", "USIMULATED", "user ID the messages come from")
+	tz := flags.String("tz", time.Local.String(), "timezone reported for every user")
+	flags.Parse(args)
+
+	// Keep jobs and history created while simulating out of the real data directory.
+	tmp, err := os.MkdirTemp("", "slack-bot-simulate")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	cfg.DataDir = tmp
+
+	server := httptest.NewServer(fakeSlackAPI(*tz))
+	defer server.Close()
+
+	api := slack.New("xoxb-simulated", slack.OptionAPIURL(server.URL+"/api/"))
+
+	send := func(text string) {
+		evt := &socketmode.Event{
+			Type: socketmode.EventTypeEventsAPI,
+			Data: slackevents.EventsAPIEvent{
+				Type: slackevents.CallbackEvent,
+				InnerEvent: slackevents.EventsAPIInnerEvent{
+					Type: string(slackevents.Message),
+					Data: &slackevents.MessageEvent{
+						Type:        string(slackevents.Message),
+						User:        *user,
+						Text:        text,
+						Channel:     "DSIMULATED",
+						ChannelType: "im",
+						ClientMsgID: newID(),
+						TimeStamp:   fmt.Sprintf("%d.000100", time.Now().Unix()),
+					},
+				},
+			},
+			Request: &socketmode.Request{EnvelopeID: "simulated"},
+		}
+
+		// A fresh client per message, nobody reads the acks it queues.
+		middlewareEventsAPI(evt, socketmode.New(api))
+	}
+
+	if text := strings.Join(flags.Args(), " "); text != "" {
+		send(text)
+		return nil
+	}
+
+	// No message on the command line, read one per line.
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			send(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// fakeSlackAPI answers the Web API methods the handlers call with canned
+// successes, printing anything the bot posts.
+func fakeSlackAPI(tz string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := path.Base(r.URL.Path)
+		ts := fmt.Sprintf("%d.%06d", time.Now().Unix(), time.Now().Nanosecond()/1000)
+
+		response := map[string]interface{}{"ok": true}
+
+		switch method {
+		case "chat.postMessage", "chat.postEphemeral", "chat.update", "chat.scheduleMessage":
+			text := r.FormValue("text")
+			if blocks := r.FormValue("blocks"); blocks != "" {
+				text = strings.TrimSpace(text + "\n" + blocks)
+			}
+			fmt.Printf("\n[%s → %s] %s\n", method, r.FormValue("channel"), text)
+			response["channel"] = r.FormValue("channel")
+			response["ts"] = ts
+
+		case "conversations.open":
+			response["channel"] = map[string]interface{}{"id": "D" + strings.TrimPrefix(r.FormValue("users"), "U")}
+
+		case "users.info":
+			id := r.FormValue("user")
+			response["user"] = map[string]interface{}{"id": id, "name": strings.ToLower(id), "tz": tz}
+
+		case "chat.scheduledMessages.list":
+			response["scheduled_messages"] = []interface{}{}
+
+		default:
+			fmt.Printf("\n[%s] %s\n", method, r.Form.Encode())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonStore is embedded in the stores kept as one JSON file in the data
+// directory. It loads the file the first time the store is locked.
+type jsonStore struct {
+	mu     sync.Mutex
+	loaded sync.Once
+}
+
+// lockJSON loads name into v on first use, then runs init (if any) to fill
+// in empty maps, and locks the store. what names the store in log messages.
+func (s *jsonStore) lockJSON(name, what string, v interface{}, init func()) {
+	s.loaded.Do(func() {
+		if err := loadJSON(name, v); err != nil {
+			fmt.Printf("failed loading %s: %v\n", what, err)
+		}
+		if init != nil {
+			init()
+		}
+	})
+	s.mu.Lock()
+}
+
+// saveLocked writes v to name. It must be called with s.mu held.
+func (s *jsonStore) saveLocked(name, what string, v interface{}) {
+	if err := saveJSON(name, v); err != nil {
+		fmt.Printf("failed saving %s: %v\n", what, err)
+	}
+}
+
+// loadJSON reads cfg.DataDir/name into v. A missing file is not an error,
+// v is simply left untouched.
+func loadJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(cfg.DataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON writes v to cfg.DataDir/name, going through a temp file so a crash
+// mid-write never leaves a truncated store behind.
+func saveJSON(name string, v interface{}) error {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(cfg.DataDir, name), data, 0o600)
+}
+
+// writeFileAtomic writes data to path through a temp file and a rename, so
+// readers see either the old contents or the new, never half of it.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newID returns a short random identifier users can type back to the bot.
+func newID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Thread and channel summaries.
+//
+// Mention the bot in a thread with "summarize", use the "Summarize thread"
+// message shortcut, or run /summarize #channel 24h. Messages are numbered in
+// the transcript so the model can cite them, and cited numbers are turned
+// into links back to the messages. Histories too long for one prompt are
+// summarized a chunk at a time and the partial summaries combined.
+
+const (
+	summarizeShortcut = "summarize_thread"
+
+	// Size of one chunk of transcript sent to the model.
+	maxSummaryChunkChars = 12000
+
+	// Rounds of summarizing summaries before giving up.
+	maxSummaryRounds = 3
+
+	defaultSummaryPeriod = 24 * time.Hour
+)
+
+const summarySystemPrompt = "You summarize Slack conversations for people who missed them. " +
+	"Be brief: a few bullet points covering decisions, open questions and action items with owners. " +
+	"Each transcript line starts with a reference like [3]; cite the lines that support each point using those references."
+
+var citationRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// summarizeMessages turns messages into a summary with links to the cited ones.
+func summarizeMessages(api *slack.Client, channelID string, messages []slack.Message, loc *time.Location) (string, error) {
+	var lines []string
+	for i, m := range messages {
+		if m.Text == "" || m.SubType == "channel_join" || m.SubType == "bot_add" {
+			continue
+		}
+		who := m.User
+		if who == "" {
+			who = m.Username
+		}
+		text := userMentionRe.ReplaceAllStringFunc(m.Text, func(s string) string {
+			return "@" + userName(api, userMentionRe.FindStringSubmatch(s)[1])
+		})
+		lines = append(lines, fmt.Sprintf("[%d] %s %s: %s", i+1, parseTS(m.Timestamp).In(loc).Format("Jan 2 15:04"), userName(api, who), text))
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("there's nothing to summarize")
+	}
+
+	summary, err := mapReduceSummary(lines)
+	if err != nil {
+		return "", err
+	}
+
+	// Link citations back to their messages.
+	links := make(map[int]string)
+	return citationRe.ReplaceAllStringFunc(summary, func(s string) string {
+		n, _ := strconv.Atoi(citationRe.FindStringSubmatch(s)[1])
+		if n < 1 || n > len(messages) {
+			return s
+		}
+		if _, ok := links[n]; !ok {
+			link, err := api.GetPermalink(&slack.PermalinkParameters{Channel: channelID, Ts: messages[n-1].Timestamp})
+			if err != nil {
+				return s
+			}
+			links[n] = link
+		}
+		return fmt.Sprintf("<%s|[%d]>", links[n], n)
+	}), nil
+}
+
+// mapReduceSummary summarizes each chunk of lines, then summarizes the
+// summaries, until everything fits into one prompt.
+func mapReduceSummary(lines []string) (string, error) {
+	return summarizeRound(lines, 1)
+}
+
+func summarizeRound(lines []string, round int) (string, error) {
+	chunks := chunkLines(lines, maxSummaryChunkChars)
+	if len(chunks) == 1 {
+		return complete(summarySystemPrompt, "Summarize this conversation:\n\n"+chunks[0])
+	}
+
+	partials := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		partial, err := complete(summarySystemPrompt,
+			fmt.Sprintf("This is part %d of %d of a longer conversation. Summarize it, keeping the references:\n\n%s", i+1, len(chunks), chunk))
+		if err != nil {
+			return "", err
+		}
+		partials[i] = partial
+	}
+
+	combined := strings.Join(partials, "\n\n")
+	if len(combined) > maxSummaryChunkChars {
+		// Summaries that don't get shorter would go round forever.
+		if round >= maxSummaryRounds {
+			return "", fmt.Errorf("there's too much to summarize, try a shorter period")
+		}
+		return summarizeRound(partials, round+1)
+	}
+	return complete(summarySystemPrompt,
+		"These are summaries of consecutive parts of one conversation. Combine them into a single summary, keeping the references:\n\n"+combined)
+}
+
+// chunkLines groups lines into chunks of at most max characters, a single
+// longer line is split across chunks.
+func chunkLines(lines []string, max int) []string {
+	var chunks []string
+	var b strings.Builder
+	add := func(line string) {
+		if b.Len() > 0 && b.Len()+len(line)+1 > max {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	for _, line := range lines {
+		for len(line) > max {
+			head, rest := cutText(line, max)
+			add(strings.TrimRight(head, " \n"))
+			line = rest
+		}
+		add(line)
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
+
+// canRead reports whether userID can see the channel: anyone can read a
+// public channel, anything else needs them to be a member.
+func canRead(api *slack.Client, userID, channelID string) (bool, error) {
+	channel, err := api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		return false, err
+	}
+	if !channel.IsPrivate && !channel.IsIM && !channel.IsMpIM {
+		return true, nil
+	}
+
+	return isMember(api, userID, channelID)
+}
+
+// summarizeThread summarizes a thread for userID.
+func summarizeThread(api *slack.Client, userID, channelID, threadTS string) string {
+	if ok, err := canRead(api, userID, channelID); err != nil || !ok {
+		return "Sorry, I can only summarize conversations you can see."
+	}
+
+	var messages []slack.Message
+	params := &slack.GetConversationRepliesParameters{ChannelID: channelID, Timestamp: threadTS, Limit: 200}
+	for {
+		page, more, cursor, err := api.GetConversationReplies(params)
+		if err != nil {
+			return "Sorry, I couldn't read the thread: " + err.Error()
+		}
+		messages = append(messages, page...)
+		if !more || cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+
+	summary, err := summarizeMessages(api, channelID, messages, getUserLocation(api, userID))
+	if err != nil {
+		return "Sorry, " + err.Error()
+	}
+	return "*Thread summary*\n" + summary
+}
+
+// summarizeChannel summarizes the last period of a channel for userID.
+func summarizeChannel(api *slack.Client, userID, channelID string, period time.Duration) string {
+	if ok, err := canRead(api, userID, channelID); err != nil || !ok {
+		return "Sorry, I can only summarize channels you can see."
+	}
+
+	messages, err := conversationHistory(api, channelID, time.Now().Add(-period))
+	if err != nil {
+		if err.Error() == "not_in_channel" {
+			return fmt.Sprintf("I'm not in <#%s>, invite me first.", channelID)
+		}
+		return "Sorry, I couldn't read the channel: " + err.Error()
+	}
+
+	summary, err := summarizeMessages(api, channelID, messages, getUserLocation(api, userID))
+	if err != nil {
+		return "Sorry, " + err.Error()
+	}
+	return fmt.Sprintf("*<#%s> in the last %s*\n%s", channelID, formatPeriod(period), summary)
+}
+
+func formatPeriod(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		if d == 24*time.Hour {
+			return "day"
+		}
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	s := strings.TrimSuffix(d.String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+//---
+
+// isSummarizeMention reports whether an app mention asks for a summary.
+func isSummarizeMention(text string) bool {
+	lower := strings.ToLower(text)
+	return strings.Contains(lower, "summarize") || strings.Contains(lower, "summarise") || strings.Contains(lower, "tl;dr")
+}
+
+// handleSummarizeMention answers "@bot summarize this thread" in the thread.
+// Outside a thread it summarizes the channel's last day, only for the person
+// who asked, as /summarize does.
+func handleSummarizeMention(ev *slackevents.AppMentionEvent, client *socketmode.Client) {
+	api := &client.Client
+
+	if ev.ThreadTimeStamp == "" {
+		text := summarizeChannel(api, ev.User, ev.Channel, defaultSummaryPeriod)
+		if _, err := api.PostEphemeral(ev.Channel, ev.User, slack.MsgOptionText(text, false)); err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+		return
+	}
+
+	text := summarizeThread(api, ev.User, ev.Channel, ev.ThreadTimeStamp)
+	_, _, err := api.PostMessage(ev.Channel, slack.MsgOptionText(text, false), slack.MsgOptionTS(ev.ThreadTimeStamp))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// handleSummarizeShortcut handles the "Summarize thread" message shortcut,
+// replying only to the user who asked.
+func handleSummarizeShortcut(callback slack.InteractionCallback, client *socketmode.Client) {
+	threadTS := callback.Message.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = callback.Message.Timestamp
+	}
+
+	text := summarizeThread(&client.Client, callback.User.ID, callback.Channel.ID, threadTS)
+
+	err := slack.PostWebhook(callback.ResponseURL, &slack.WebhookMessage{ResponseType: "ephemeral", Text: text})
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+// handleSummarizeCommand handles /summarize [#channel] [24h].
+func handleSummarizeCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleSummarizeCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+	api := &client.Client
+
+	// Looking channels up and summarizing take longer than Slack waits,
+	// answer through the response URL.
+	client.Ack(*evt.Request, map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          "Summarizing...",
+	})
+	respond := func(text string) {
+		err := slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{ResponseType: "ephemeral", Text: text})
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+
+	channelID, period := cmd.ChannelID, defaultSummaryPeriod
+	for _, arg := range strings.Fields(cmd.Text) {
+		if d, err := parseRelative(arg); err == nil {
+			period = d
+			continue
+		}
+		if days, err := strconv.Atoi(strings.TrimSuffix(arg, "d")); err == nil && days > 0 {
+			period = time.Duration(days) * 24 * time.Hour
+			continue
+		}
+		id, err := resolveChannel(api, arg)
+		if err != nil {
+			respond("Sorry, " + err.Error() + ". Try `/summarize #channel 24h`.")
+			return
+		}
+		channelID = id
+	}
+
+	respond(summarizeChannel(api, cmd.UserID, channelID, period))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkLines(t *testing.T) {
+	long := strings.Repeat("word ", 30) // 150 bytes
+	runes := strings.Repeat("é", 70)    // 140 bytes, no spaces
+	tests := []struct {
+		name   string
+		lines  []string
+		chunks int
+	}{
+		{"fits", []string{"one", "two"}, 1},
+		{"two chunks", []string{strings.Repeat("a", 60), strings.Repeat("b", 60)}, 2},
+		{"long line", []string{long}, 2},
+		{"long line without spaces", []string{runes}, 2},
+	}
+	for _, tt := range tests {
+		chunks := chunkLines(tt.lines, 100)
+		if len(chunks) != tt.chunks {
+			t.Errorf("%s: %d chunks %q, want %d", tt.name, len(chunks), chunks, tt.chunks)
+		}
+		var all string
+		for _, c := range chunks {
+			if len(c) > 101 {
+				t.Errorf("%s: chunk of %d bytes", tt.name, len(c))
+			}
+			if !utf8.ValidString(c) {
+				t.Errorf("%s: chunk %q splits a character", tt.name, c)
+			}
+			all += c
+		}
+		// Nothing is lost, only line breaks and spaces at the cuts.
+		want := strings.Join(strings.Fields(strings.Join(tt.lines, " ")), "")
+		if got := strings.Join(strings.Fields(all), ""); got != want {
+			t.Errorf("%s: chunks hold %q, want %q", tt.name, got, want)
+		}
+	}
+}
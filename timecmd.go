@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// World clock and timezone conversion.
+//
+//	time                      the asker's own time, from their Slack profile
+//	time in Tokyo             a place, IANA zone or abbreviation
+//	time for @user            another user's local time
+//	3pm PT in Berlin          convert a time of day between zones
+
+// zoneAliases covers abbreviations and cities that aren't IANA zone names.
+var zoneAliases = map[string]string{
+	"pt": "America/Los_Angeles", "pst": "America/Los_Angeles", "pdt": "America/Los_Angeles", "pacific": "America/Los_Angeles",
+	"mt": "America/Denver", "mst": "America/Denver", "mdt": "America/Denver", "mountain": "America/Denver",
+	"ct": "America/Chicago", "cst": "America/Chicago", "cdt": "America/Chicago", "central": "America/Chicago",
+	"et": "America/New_York", "est": "America/New_York", "edt": "America/New_York", "eastern": "America/New_York",
+	"utc": "UTC", "gmt": "UTC", "z": "UTC", "zulu": "UTC",
+	"bst": "Europe/London", "uk": "Europe/London",
+	"cet": "Europe/Berlin", "cest": "Europe/Berlin",
+	"ist": "Asia/Kolkata", "india": "Asia/Kolkata",
+	"jst": "Asia/Tokyo", "japan": "Asia/Tokyo",
+	"kst": "Asia/Seoul", "aest": "Australia/Sydney", "aedt": "Australia/Sydney",
+	"nzst": "Pacific/Auckland", "nzdt": "Pacific/Auckland",
+
+	"san francisco": "America/Los_Angeles", "sf": "America/Los_Angeles", "seattle": "America/Los_Angeles",
+	"portland": "America/Los_Angeles", "la": "America/Los_Angeles", "las vegas": "America/Los_Angeles",
+	"san diego": "America/Los_Angeles", "salt lake city": "America/Denver",
+	"dallas": "America/Chicago", "houston": "America/Chicago", "austin": "America/Chicago",
+	"minneapolis": "America/Chicago",
+	"nyc":         "America/New_York", "boston": "America/New_York", "washington": "America/New_York",
+	"atlanta": "America/New_York", "miami": "America/New_York", "philadelphia": "America/New_York",
+	"montreal": "America/Toronto", "ottawa": "America/Toronto",
+	"munich": "Europe/Berlin", "frankfurt": "Europe/Berlin", "hamburg": "Europe/Berlin",
+	"barcelona": "Europe/Madrid", "milan": "Europe/Rome", "geneva": "Europe/Zurich",
+	"edinburgh": "Europe/London", "manchester": "Europe/London",
+	"beijing": "Asia/Shanghai", "shenzhen": "Asia/Shanghai", "china": "Asia/Shanghai",
+	"mumbai": "Asia/Kolkata", "delhi": "Asia/Kolkata", "new delhi": "Asia/Kolkata", "bangalore": "Asia/Kolkata",
+	"bengaluru": "Asia/Kolkata", "hyderabad": "Asia/Kolkata", "chennai": "Asia/Kolkata",
+	"osaka": "Asia/Tokyo", "kyoto": "Asia/Tokyo", "hanoi": "Asia/Ho_Chi_Minh",
+	"canberra": "Australia/Sydney", "wellington": "Pacific/Auckland",
+}
+
+var zoneRegions = []string{"America", "Europe", "Asia", "Africa", "Australia", "Pacific", "Atlantic", "Indian", "America/Argentina"}
+
+// lookupZone resolves a place, abbreviation or IANA name to a location.
+func lookupZone(place string) (*time.Location, error) {
+	key := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(place, "?")))
+	if key == "" {
+		return nil, fmt.Errorf("which place?")
+	}
+
+	if name, ok := zoneAliases[key]; ok {
+		return time.LoadLocation(name)
+	}
+
+	if strings.Contains(place, "/") {
+		if loc, err := time.LoadLocation(strings.TrimSpace(place)); err == nil {
+			return loc, nil
+		}
+	}
+
+	// "new york" -> "America/New_York"
+	words := strings.Fields(key)
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	city := strings.Join(words, "_")
+	for _, region := range zoneRegions {
+		if loc, err := time.LoadLocation(region + "/" + city); err == nil {
+			return loc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("I don't know what timezone %s is in", strings.TrimSpace(place))
+}
+
+// slackDate renders t with a <!date> token, so every reader sees it in their
+// own timezone, falling back to the given text.
+func slackDate(t time.Time, fallback string) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", t.Unix(), fallback)
+}
+
+func zoneLabel(t time.Time) string {
+	name := t.Location().String()
+	abbrev, _ := t.Zone()
+	if name == abbrev || name == "Local" {
+		return abbrev
+	}
+	return fmt.Sprintf("%s, %s", strings.ReplaceAll(name, "_", " "), abbrev)
+}
+
+var (
+	userMentionRe = regexp.MustCompile(`<@([A-Z0-9]+)(\|[^>]*)?>`)
+	convertTimeRe = regexp.MustCompile(`(?i)^(?:convert\s+)?(.+?)\s+(\S+)\s+(?:in|to)\s+(.+)$`)
+)
+
+// timeQuestion extracts what follows "time"/"what time is it" in a DM.
+// ok is false when the message isn't asking about the time at all.
+func timeQuestion(text string) (string, bool) {
+	trimmed := strings.TrimRight(strings.TrimSpace(text), "?")
+	lower := strings.ToLower(trimmed)
+
+	for _, prefix := range []string{"what time is it", "do you know what time it is", "tell me the time", "time"} {
+		if lower == prefix {
+			return "", true
+		}
+		// Only when what follows is a place or a person, "time for lunch"
+		// is left for the LLM.
+		switch {
+		case strings.HasPrefix(lower, prefix+" in "):
+			if _, err := lookupZone(trimmed[len(prefix+" in "):]); err == nil {
+				return strings.TrimSpace(trimmed[len(prefix):]), true
+			}
+		case strings.HasPrefix(lower, prefix+" for "):
+			if isUserRef(trimmed[len(prefix+" for "):]) {
+				return strings.TrimSpace(trimmed[len(prefix):]), true
+			}
+		}
+	}
+
+	if strings.HasPrefix(lower, "convert ") {
+		return trimmed, true
+	}
+
+	// "3pm PT in Berlin"
+	if m := convertTimeRe.FindStringSubmatch(trimmed); m != nil {
+		if _, _, ok := parseClock(m[1]); ok {
+			if _, err := lookupZone(m[2]); err == nil {
+				return trimmed, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// isUserRef reports whether s names a user: <@U123>, @name or U123.
+func isUserRef(s string) bool {
+	s = strings.TrimSpace(s)
+	if userMentionRe.MatchString(s) {
+		return true
+	}
+	return (strings.HasPrefix(s, "@") && len(s) > 1 && !strings.Contains(s, " ")) || looksLikeID(s, "U", "W")
+}
+
+// runTimeRequest answers a time question for userID. query is what
+// timeQuestion returned: empty, "in <place>", "for <@user>" or a conversion.
+func runTimeRequest(api *slack.Client, userID, query string) string {
+	lower := strings.ToLower(query)
+
+	switch {
+	case query == "":
+		now := time.Now().In(getUserLocation(api, userID))
+		return "At the tone the time will be... \n" +
+			slackDate(now, now.Format("2006-01-02 15:04:05")) + " (" + zoneLabel(now) + ")"
+
+	case strings.HasPrefix(lower, "for "):
+		if !isUserRef(query[len("for "):]) {
+			return "Who? Try `time for @someone`."
+		}
+		target, err := resolveUser(api, query[len("for "):])
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+		now := time.Now().In(getUserLocation(api, target))
+		return fmt.Sprintf("It's %s for <@%s> (%s).", now.Format("Mon 15:04"), target, zoneLabel(now))
+
+	case strings.HasPrefix(lower, "in "):
+		loc, err := lookupZone(query[len("in "):])
+		if err != nil {
+			return "Sorry, " + err.Error()
+		}
+		now := time.Now().In(loc)
+		return fmt.Sprintf("It's %s in %s (%s).", now.Format("Mon 15:04"), strings.TrimSpace(query[len("in "):]), zoneLabel(now))
+	}
+
+	m := convertTimeRe.FindStringSubmatch(query)
+	if m == nil {
+		return "Try `time`, `time in Tokyo`, `time for @someone` or `3pm PT in Berlin`."
+	}
+
+	hour, minute, ok := parseClock(m[1])
+	if !ok {
+		return fmt.Sprintf("Sorry, I don't understand %q as a time.", m[1])
+	}
+
+	from, err := lookupZone(m[2])
+	if err != nil {
+		return "Sorry, " + err.Error()
+	}
+
+	var to *time.Location
+	if target := userMentionRe.FindStringSubmatch(m[3]); target != nil {
+		to = getUserLocation(api, target[1])
+	} else if to, err = lookupZone(m[3]); err != nil {
+		return "Sorry, " + err.Error()
+	}
+
+	// Convert the next occurrence of that time, so DST is right for the days that matter.
+	now := time.Now().In(from)
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, from)
+	if at.Before(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	converted := at.In(to)
+
+	return fmt.Sprintf("%s %s is %s in %s (%s).\nThat's %s where you are.",
+		at.Format("3:04pm"), strings.ToUpper(m[2]), converted.Format("Mon 3:04pm"), strings.TrimSpace(m[3]),
+		zoneLabel(converted), slackDate(at, at.Format(time.RFC1123)))
+}
+
+func handleTimeMessage(ev *slackevents.MessageEvent, client *socketmode.Client, query string) {
+	response := runTimeRequest(&client.Client, ev.User, query)
+
+	_, _, err := client.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
+
+func handleTimeCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleTimeCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	// "/time Tokyo" and "/time @user" are shorthand for "in" and "for".
+	query := strings.TrimSpace(cmd.Text)
+	lower := strings.ToLower(query)
+	switch {
+	case query == "", strings.HasPrefix(lower, "in "), strings.HasPrefix(lower, "for "), strings.HasPrefix(lower, "convert "):
+	case strings.HasPrefix(query, "<@"):
+		query = "for " + query
+	default:
+		if m := convertTimeRe.FindStringSubmatch(query); m == nil {
+			query = "in " + query
+		} else if _, _, ok := parseClock(m[1]); !ok {
+			query = "in " + query
+		}
+	}
+
+	payload := map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          runTimeRequest(&client.Client, cmd.UserID, query),
+	}
+
+	client.Ack(*evt.Request, payload)
+}
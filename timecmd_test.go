@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestTimeQuestion(t *testing.T) {
+	tests := []struct {
+		text, query string
+		ok          bool
+	}{
+		{"time", "", true},
+		{"What time is it?", "", true},
+		{"time in Tokyo", "in Tokyo", true},
+		{"what time is it in new york?", "in new york", true},
+		{"time in America/Sao_Paulo", "in America/Sao_Paulo", true},
+		{"time for <@U024BE7LH>", "for <@U024BE7LH>", true},
+		{"time for @alice", "for @alice", true},
+		{"time for U024BE7LH", "for U024BE7LH", true},
+		{"3pm PT in Berlin", "3pm PT in Berlin", true},
+		{"convert 9am CET to ET", "convert 9am CET to ET", true},
+
+		{"time for lunch?", "", false},
+		{"time for a break", "", false},
+		{"time in the sun", "", false},
+		{"time in narnia", "", false},
+		{"timezones are hard", "", false},
+		{"what is the weather like", "", false},
+	}
+	for _, tt := range tests {
+		query, ok := timeQuestion(tt.text)
+		if query != tt.query || ok != tt.ok {
+			t.Errorf("timeQuestion(%q) = %q, %v; want %q, %v", tt.text, query, ok, tt.query, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Natural-language time parsing for reminders and scheduled messages.
+//
+// Understood forms (case-insensitive):
+//
+//	in 2 hours, in 30 minutes, in an hour, in 1 day and 3 hours
+//	at 5pm, at 17:30, tomorrow at 9am, friday at noon, on 2026-12-24 at 8am
+//	every day at 9am, every weekday at 9:30am, every monday and thursday at 3pm
+//	every last friday at 4pm
+//
+// Times are interpreted in the location of the "now" passed in, which is the
+// requesting user's Slack timezone.
+
+// Recurrence describes a job that fires on the given weekdays at Hour:Minute.
+// With Last set it fires only on the last of those weekdays in each month.
+type Recurrence struct {
+	Weekdays []time.Weekday `json:"weekdays"`
+	Hour     int            `json:"hour"`
+	Minute   int            `json:"minute"`
+	Last     bool           `json:"last,omitempty"`
+}
+
+// Next returns the first occurrence strictly after t, in t's location. A
+// time that doesn't exist on a DST change day moves forward with the clocks.
+func (r *Recurrence) Next(t time.Time) time.Time {
+	days := 7
+	if r.Last {
+		days = 62
+	}
+	for i := 0; i <= days; i++ {
+		day := t.AddDate(0, 0, i)
+		candidate := time.Date(day.Year(), day.Month(), day.Day(), r.Hour, r.Minute, 0, 0, t.Location())
+		if candidate.Hour() != r.Hour {
+			// time.Date goes back an hour in the gap, go forward instead.
+			candidate = candidate.Add(time.Hour)
+		}
+		if !candidate.After(t) || !r.onDay(candidate.Weekday()) {
+			continue
+		}
+		// The last one in the month is the one a week before the next month.
+		if r.Last && candidate.AddDate(0, 0, 7).Month() == candidate.Month() {
+			continue
+		}
+		return candidate
+	}
+	// Unreachable with at least one weekday, but don't spin on an empty list.
+	return t.AddDate(0, 0, 1)
+}
+
+func (r *Recurrence) onDay(wd time.Weekday) bool {
+	for _, d := range r.Weekdays {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recurrence) String() string {
+	clock := fmt.Sprintf("%02d:%02d", r.Hour, r.Minute)
+	every := "every "
+	if r.Last {
+		every = "every last "
+	}
+	switch {
+	case r.Last:
+	case len(r.Weekdays) == 7:
+		return "every day at " + clock
+	case sameWeekdays(r.Weekdays, workWeek):
+		return "every weekday at " + clock
+	case sameWeekdays(r.Weekdays, weekend):
+		return "every weekend day at " + clock
+	}
+
+	names := make([]string, len(r.Weekdays))
+	for i, d := range r.Weekdays {
+		names[i] = d.String()
+	}
+	return every + strings.Join(names, ", ") + " at " + clock
+}
+
+var (
+	everyDay = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	workWeek = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	weekend  = []time.Weekday{time.Saturday, time.Sunday}
+)
+
+func sameWeekdays(a, b []time.Weekday) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, d := range b {
+		found := false
+		for _, e := range a {
+			if d == e {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday, "sundays": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday, "mondays": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday, "tuesdays": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday, "wednesdays": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday, "thursdays": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday, "fridays": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday, "saturdays": time.Saturday,
+}
+
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+func isWeekdayName(s string) bool {
+	_, ok := weekdayNames[s]
+	return ok
+}
+
+// parseWhen turns a phrase such as "tomorrow at 9am" into the next time it
+// refers to. For "every ..." phrases it also returns the recurrence.
+func parseWhen(text string, now time.Time) (time.Time, *Recurrence, error) {
+	s := strings.ToLower(strings.TrimSpace(text))
+
+	if strings.HasPrefix(s, "in ") {
+		d, err := parseRelative(strings.TrimPrefix(s, "in "))
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		return now.Add(d), nil, nil
+	}
+
+	if strings.HasPrefix(s, "every ") {
+		rec, err := parseRecurrence(strings.TrimPrefix(s, "every "))
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		return rec.Next(now), rec, nil
+	}
+
+	when, err := parseAbsolute(s, now)
+	return when, nil, err
+}
+
+// parseRelative parses "2 hours", "an hour", "1 day and 30 minutes", "90m".
+func parseRelative(s string) (time.Duration, error) {
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	var total time.Duration
+
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if f == "and" {
+			continue
+		}
+
+		// "90m", "2h"
+		if d, err := time.ParseDuration(f); err == nil {
+			total += d
+			continue
+		}
+
+		var n int
+		switch f {
+		case "a", "an", "one":
+			n = 1
+		default:
+			v, err := strconv.Atoi(f)
+			if err != nil {
+				return 0, fmt.Errorf("I don't understand %q as an amount of time", f)
+			}
+			n = v
+		}
+
+		if i+1 >= len(fields) {
+			return 0, fmt.Errorf("missing a unit after %q", f)
+		}
+		i++
+		unit, ok := durationUnits[fields[i]]
+		if !ok {
+			return 0, fmt.Errorf("unknown time unit %q", fields[i])
+		}
+		total += time.Duration(n) * unit
+	}
+
+	if total <= 0 {
+		return 0, fmt.Errorf("that's not a time in the future")
+	}
+	return total, nil
+}
+
+// parseRecurrence parses what follows "every": "weekday at 9am",
+// "day", "monday and wednesday at 15:00".
+func parseRecurrence(s string) (*Recurrence, error) {
+	daysPart, clockPart := s, ""
+	if i := strings.Index(s, " at "); i >= 0 {
+		daysPart, clockPart = s[:i], s[i+len(" at "):]
+	}
+
+	rec := &Recurrence{Hour: 9}
+	if clockPart != "" {
+		h, m, ok := parseClock(clockPart)
+		if !ok {
+			return nil, fmt.Errorf("I don't understand %q as a time of day", clockPart)
+		}
+		rec.Hour, rec.Minute = h, m
+	}
+
+	for _, f := range strings.Fields(strings.ReplaceAll(daysPart, ",", " ")) {
+		switch f {
+		case "and":
+		case "last":
+			rec.Last = true
+		case "day", "days":
+			rec.Weekdays = append(rec.Weekdays, everyDay...)
+		case "weekday", "weekdays", "workday", "workdays":
+			rec.Weekdays = append(rec.Weekdays, workWeek...)
+		case "weekend", "weekends":
+			rec.Weekdays = append(rec.Weekdays, weekend...)
+		default:
+			wd, ok := weekdayNames[f]
+			if !ok {
+				return nil, fmt.Errorf("I don't understand %q as a day", f)
+			}
+			rec.Weekdays = append(rec.Weekdays, wd)
+		}
+	}
+
+	if len(rec.Weekdays) == 0 {
+		return nil, fmt.Errorf("say which days, e.g. \"every weekday at 9am\"")
+	}
+	if rec.Last && len(rec.Weekdays) != 1 {
+		return nil, fmt.Errorf("say which day, e.g. \"every last friday at 4pm\"")
+	}
+	return rec, nil
+}
+
+// parseAbsolute parses "at 5pm", "tomorrow at 9am", "friday", "on 2026-12-24 at 8am".
+func parseAbsolute(s string, now time.Time) (time.Time, error) {
+	loc := now.Location()
+	day := now
+	dayGiven, weekdayGiven, clockGiven := false, false, false
+	hour, minute := 9, 0
+
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+
+		switch {
+		case f == "at" || f == "on" || f == "next" || f == "this":
+			continue
+		case f == "today" || f == "tonight":
+			dayGiven = true
+			if f == "tonight" && !clockGiven {
+				hour = 20
+			}
+		case f == "tomorrow":
+			day = now.AddDate(0, 0, 1)
+			dayGiven = true
+		case isWeekdayName(f):
+			wd := weekdayNames[f]
+			ahead := (int(wd) - int(now.Weekday()) + 7) % 7
+			day = now.AddDate(0, 0, ahead)
+			dayGiven, weekdayGiven = true, true
+		default:
+			if d, err := time.ParseInLocation("2006-01-02", f, loc); err == nil {
+				day = d
+				dayGiven = true
+				continue
+			}
+
+			// Allow a space before am/pm: "9 am"
+			clock := f
+			if i+1 < len(fields) && (fields[i+1] == "am" || fields[i+1] == "pm") {
+				clock += fields[i+1]
+				i++
+			}
+
+			h, m, ok := parseClock(clock)
+			if !ok || clockGiven {
+				return time.Time{}, fmt.Errorf("I don't understand %q as a time", f)
+			}
+			hour, minute = h, m
+			clockGiven = true
+		}
+	}
+
+	if !dayGiven && !clockGiven {
+		return time.Time{}, fmt.Errorf("I don't understand %q as a time", s)
+	}
+
+	when := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	if !when.After(now) {
+		switch {
+		case weekdayGiven:
+			when = when.AddDate(0, 0, 7)
+		case !dayGiven:
+			when = when.AddDate(0, 0, 1)
+		default:
+			return time.Time{}, fmt.Errorf("%s is in the past", when.Format("Mon Jan 2 15:04"))
+		}
+	}
+
+	return when, nil
+}
+
+// parseClock parses a time of day: "9am", "9:30pm", "17:30", "noon", "midnight".
+func parseClock(s string) (int, int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, " ", "")
+	s = strings.ReplaceAll(s, ".", "")
+
+	switch s {
+	case "noon", "midday":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+
+	suffix := ""
+	if strings.HasSuffix(s, "am") || strings.HasSuffix(s, "pm") {
+		suffix = s[len(s)-2:]
+		s = s[:len(s)-2]
+	}
+
+	hourStr, minuteStr := s, "0"
+	if i := strings.Index(s, ":"); i >= 0 {
+		hourStr, minuteStr = s[:i], s[i+1:]
+	}
+
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	minute, err := strconv.Atoi(minuteStr)
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+
+	switch suffix {
+	case "am":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		if hour != 12 {
+			hour += 12
+		}
+	default:
+		// A bare number needs a colon to count as a time, "5" alone is too ambiguous.
+		if !strings.Contains(s, ":") || hour < 0 || hour > 23 {
+			return 0, 0, false
+		}
+	}
+
+	return hour, minute, true
+}
+
+// splitWhen separates the time phrase from the task in a reminder, accepting
+// both "in 2 hours to call Bob" and "to call Bob in 2 hours".
+func splitWhen(text string, now time.Time) (time.Time, *Recurrence, string, error) {
+	text = strings.TrimSpace(text)
+
+	if strings.HasPrefix(strings.ToLower(text), "to ") {
+		words := strings.Fields(text[len("to "):])
+		// The earliest position that still parses gives the longest time phrase.
+		for i := 1; i < len(words); i++ {
+			when, rec, err := parseWhen(strings.Join(words[i:], " "), now)
+			if err == nil {
+				return when, rec, strings.Join(words[:i], " "), nil
+			}
+		}
+		return time.Time{}, nil, "", fmt.Errorf("I couldn't find when in %q", text)
+	}
+
+	i := strings.Index(strings.ToLower(text), " to ")
+	if i < 0 {
+		return time.Time{}, nil, "", fmt.Errorf("say what to be reminded about, e.g. \"remind me in 2 hours to stretch\"")
+	}
+
+	when, rec, err := parseWhen(text[:i], now)
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+	return when, rec, strings.TrimSpace(text[i+len(" to "):]), nil
+}
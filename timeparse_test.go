@@ -0,0 +1,197 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseWhen(t *testing.T) {
+	loc := newYork(t)
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, loc)
+	}
+
+	// A Friday evening, two days before clocks go forward.
+	friday := at(2026, time.March, 6, 23, 0)
+
+	tests := []struct {
+		text      string
+		now       time.Time
+		want      time.Time
+		recurring bool
+	}{
+		{"in 90 minutes", friday, at(2026, time.March, 7, 0, 30), false},
+		{"in 1 day and 3 hours", friday, at(2026, time.March, 8, 3, 0), false},
+		{"in an hour", friday, at(2026, time.March, 7, 0, 0), false},
+		{"in 2h", friday, at(2026, time.March, 7, 1, 0), false},
+		// Two real hours across the spring-forward gap.
+		{"in 2 hours", at(2026, time.March, 8, 1, 0), at(2026, time.March, 8, 4, 0), false},
+		{"at 5pm", friday, at(2026, time.March, 7, 17, 0), false},
+		{"at 23:30", friday, at(2026, time.March, 6, 23, 30), false},
+		{"tomorrow at 9am", at(2026, time.March, 7, 10, 0), at(2026, time.March, 8, 9, 0), false},
+		{"tomorrow", at(2026, time.January, 31, 10, 0), at(2026, time.February, 1, 9, 0), false},
+		{"tonight", at(2026, time.March, 6, 12, 0), at(2026, time.March, 6, 20, 0), false},
+		{"friday at noon", friday, at(2026, time.March, 13, 12, 0), false},
+		{"on 2026-12-24 at 8 am", friday, at(2026, time.December, 24, 8, 0), false},
+		{"every weekday at 9:30am", friday, at(2026, time.March, 9, 9, 30), true},
+		{"every monday and thursday at 15:00", friday, at(2026, time.March, 9, 15, 0), true},
+		{"every day", friday, at(2026, time.March, 7, 9, 0), true},
+		{"every last friday at 4pm", friday, at(2026, time.March, 27, 16, 0), true},
+		{"every last friday at 4pm", at(2026, time.March, 27, 17, 0), at(2026, time.April, 24, 16, 0), true},
+		{"every last sunday", at(2026, time.January, 30, 12, 0), at(2026, time.February, 22, 9, 0), true},
+		// 2:30 doesn't exist on the day clocks go forward.
+		{"every day at 2:30", at(2026, time.March, 7, 12, 0), at(2026, time.March, 8, 3, 30), true},
+		{"every day at 9am", at(2026, time.October, 31, 12, 0), at(2026, time.November, 1, 9, 0), true},
+	}
+
+	for _, tt := range tests {
+		got, rec, err := parseWhen(tt.text, tt.now)
+		if err != nil {
+			t.Errorf("parseWhen(%q) error: %v", tt.text, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseWhen(%q) at %v = %v, want %v", tt.text, tt.now, got, tt.want)
+		}
+		if (rec != nil) != tt.recurring {
+			t.Errorf("parseWhen(%q) recurrence = %v, want recurring %v", tt.text, rec, tt.recurring)
+		}
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	now := time.Date(2026, time.March, 6, 23, 0, 0, 0, newYork(t))
+
+	for _, text := range []string{
+		"",
+		"in 0 minutes",
+		"in 5",
+		"in 5 fortnights",
+		"at 25:00",
+		"at 5",
+		"yesterday",
+		"on 2020-01-01",
+		"every",
+		"every blue moon",
+		"every last friday and monday",
+		"at 9am at 10am",
+	} {
+		if when, _, err := parseWhen(text, now); err == nil {
+			t.Errorf("parseWhen(%q) = %v, want an error", text, when)
+		}
+	}
+}
+
+func TestRecurrenceNextDST(t *testing.T) {
+	loc := newYork(t)
+	rec := &Recurrence{Weekdays: everyDay, Hour: 9}
+
+	// Each run is at 9:00 local time, whatever the offset.
+	next := time.Date(2026, time.March, 7, 9, 0, 0, 0, loc)
+	for i := 0; i < 3; i++ {
+		next = rec.Next(next)
+		if next.Hour() != 9 || next.Minute() != 0 {
+			t.Fatalf("run %d at %v, want 09:00", i, next)
+		}
+	}
+	if want := time.Date(2026, time.March, 10, 9, 0, 0, 0, loc); !next.Equal(want) {
+		t.Errorf("third run at %v, want %v", next, want)
+	}
+
+	// 1:30 happens twice when clocks go back; the job runs once.
+	rec = &Recurrence{Weekdays: everyDay, Hour: 1, Minute: 30}
+	first := rec.Next(time.Date(2026, time.October, 31, 12, 0, 0, 0, loc))
+	second := rec.Next(first)
+	if first.Day() != 1 || second.Day() != 2 {
+		t.Errorf("runs at %v and %v, want November 1 then 2", first, second)
+	}
+}
+
+func TestRecurrenceString(t *testing.T) {
+	tests := []struct {
+		rec  Recurrence
+		want string
+	}{
+		{Recurrence{Weekdays: everyDay, Hour: 9}, "every day at 09:00"},
+		{Recurrence{Weekdays: workWeek, Hour: 9, Minute: 30}, "every weekday at 09:30"},
+		{Recurrence{Weekdays: weekend, Hour: 10}, "every weekend day at 10:00"},
+		{Recurrence{Weekdays: []time.Weekday{time.Monday, time.Thursday}, Hour: 15}, "every Monday, Thursday at 15:00"},
+		{Recurrence{Weekdays: []time.Weekday{time.Friday}, Hour: 16, Last: true}, "every last Friday at 16:00"},
+	}
+	for _, tt := range tests {
+		if got := tt.rec.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in           string
+		hour, minute int
+		ok           bool
+	}{
+		{"9am", 9, 0, true},
+		{"9:30pm", 21, 30, true},
+		{"12am", 0, 0, true},
+		{"12pm", 12, 0, true},
+		{"12:15 a.m.", 0, 15, true},
+		{"17:30", 17, 30, true},
+		{"0:05", 0, 5, true},
+		{"noon", 12, 0, true},
+		{"midnight", 0, 0, true},
+		{"5", 0, 0, false},
+		{"13pm", 0, 0, false},
+		{"0am", 0, 0, false},
+		{"24:00", 0, 0, false},
+		{"9:60", 0, 0, false},
+		{"teatime", 0, 0, false},
+	}
+	for _, tt := range tests {
+		h, m, ok := parseClock(tt.in)
+		if ok != tt.ok || (ok && (h != tt.hour || m != tt.minute)) {
+			t.Errorf("parseClock(%q) = %d, %d, %v; want %d, %d, %v", tt.in, h, m, ok, tt.hour, tt.minute, tt.ok)
+		}
+	}
+}
+
+func TestSplitWhen(t *testing.T) {
+	loc := newYork(t)
+	now := time.Date(2026, time.March, 6, 23, 0, 0, 0, loc)
+
+	tests := []struct {
+		text, task string
+		want       time.Time
+	}{
+		{"in 2 hours to call Bob", "call Bob", time.Date(2026, time.March, 7, 1, 0, 0, 0, loc)},
+		{"to call Bob in 2 hours", "call Bob", time.Date(2026, time.March, 7, 1, 0, 0, 0, loc)},
+		{"to stretch every weekday at 9am", "stretch", time.Date(2026, time.March, 9, 9, 0, 0, 0, loc)},
+		{"tomorrow at 8am to go to the dentist", "go to the dentist", time.Date(2026, time.March, 7, 8, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		when, _, task, err := splitWhen(tt.text, now)
+		if err != nil {
+			t.Errorf("splitWhen(%q) error: %v", tt.text, err)
+			continue
+		}
+		if task != tt.task || !when.Equal(tt.want) {
+			t.Errorf("splitWhen(%q) = %v, %q; want %v, %q", tt.text, when, task, tt.want, tt.task)
+		}
+	}
+
+	for _, text := range []string{"tomorrow", "to call Bob", "in 2 hours to"} {
+		if _, _, task, err := splitWhen(text, now); err == nil {
+			t.Errorf("splitWhen(%q) = %q, want an error", text, task)
+		}
+	}
+}
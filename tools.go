@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// The bot's commands as tools for the model.
+//
+// Free-form requests like "tell Bob a dad joke" go to the model with the
+// tools below. When it picks one the tool runs as the requesting user, so
+// the same authorization applies as for the typed command. Tools that post
+// or schedule something are held until the user presses Confirm.
+
+const (
+	toolConfirmAction = "tool_confirm"
+	toolCancelAction  = "tool_cancel"
+
+	// How long a confirmation button stays valid.
+	pendingToolTTL = 15 * time.Minute
+)
+
+// Tool is a command the model may call. Args are the JSON arguments, all
+// strings.
+type Tool struct {
+	Name        string
+	Description string
+	Params      map[string]string // name -> description
+	Required    []string
+
+	// Confirm marks tools with side effects, they wait for the user.
+	Confirm bool
+
+	// Describe says what the tool is about to do, for the confirmation.
+	Describe func(args map[string]string) string
+
+	Run func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error)
+}
+
+var tools = []*Tool{
+	{
+		Name:        "send_direct_message",
+		Description: "Send a direct message to a Slack user on behalf of the requester.",
+		Params:      map[string]string{"user": "the recipient, @name or user ID", "text": "the message"},
+		Required:    []string{"user", "text"},
+		Confirm:     true,
+		Describe: func(args map[string]string) string {
+			return fmt.Sprintf("Send %s a DM: %s", args["user"], args["text"])
+		},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			target, err := dmTarget(api, userID, args["user"])
+			if err != nil {
+				return "", nil, err
+			}
+			text := fmt.Sprintf("<@%s> asked me to tell you: %s", userID, args["text"])
+			if _, _, err := api.PostMessage(target, slack.MsgOptionText(text, false)); err != nil {
+				return "", nil, err
+			}
+			return "Sent.", nil, nil
+		},
+	},
+	{
+		Name:        "send_dad_joke",
+		Description: "Tell a dad joke to another user (in a DM) or in a channel.",
+		Params:      map[string]string{"to": "a user (@name) or a channel (#name)", "topic": "optional subject of the joke"},
+		Required:    []string{"to"},
+		Confirm:     true,
+		Describe: func(args map[string]string) string {
+			if args["topic"] != "" {
+				return fmt.Sprintf("Tell %s a dad joke about %s", args["to"], args["topic"])
+			}
+			return "Tell " + args["to"] + " a dad joke"
+		},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			var target string
+			var err error
+			if to := strings.TrimSpace(args["to"]); strings.HasPrefix(to, "#") || strings.HasPrefix(to, "<#") {
+				if target, err = resolveChannel(api, to); err == nil {
+					err = authorize(api, userID, actionPost, target)
+				}
+			} else {
+				target, err = dmTarget(api, userID, to)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+
+			joke, err := getDadJoke(target, args["topic"])
+			if err != nil {
+				return "", nil, err
+			}
+			if _, _, err := api.PostMessage(target, slack.MsgOptionText(joke, false)); err != nil {
+				return "", nil, err
+			}
+			return "Told the joke " + joke, nil, nil
+		},
+	},
+	{
+		Name:        "tell_dad_joke",
+		Description: "Tell the requester a dad joke.",
+		Params:      map[string]string{"topic": "optional subject of the joke"},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			joke, err := getDadJoke(channelID, args["topic"])
+			return joke, nil, err
+		},
+	},
+	{
+		Name:        "get_time",
+		Description: "Tell the time for the requester, in a place, for a user, or convert a time between zones.",
+		Params:      map[string]string{"query": `empty for the requester's time, "in Tokyo", "for <@U123>", or a conversion like "3pm PT in Berlin"`},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			return runTimeRequest(api, userID, args["query"]), nil, nil
+		},
+	},
+	{
+		Name:        "get_weather",
+		Description: "Get the weather forecast for a place, or the requester's default place.",
+		Params:      map[string]string{"place": "optional place name"},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			blocks, text := runWeatherRequest(userID, args["place"])
+			return text, blocks, nil
+		},
+	},
+	{
+		Name:        "schedule_reminder",
+		Description: "Remind the requester about something later, once or repeatedly.",
+		Params:      map[string]string{"when": `e.g. "in 2 hours", "tomorrow at 9am", "every weekday at 9am"`, "what": "what to remind them of"},
+		Required:    []string{"when", "what"},
+		Confirm:     true,
+		Describe: func(args map[string]string) string {
+			return fmt.Sprintf("Remind you %s to %s", args["when"], args["what"])
+		},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			replyChannel, err := openDirectMessage(api, userID)
+			if err != nil {
+				return "", nil, err
+			}
+			return runScheduleRequest(api, userID, replyChannel, args["when"]+" to "+args["what"]), nil, nil
+		},
+	},
+	{
+		Name:        "schedule_channel_message",
+		Description: "Post a message in a channel on a recurring schedule.",
+		Params:      map[string]string{"when": `a recurrence, e.g. "every weekday at 9am"`, "text": "the message", "channel": "#channel"},
+		Required:    []string{"when", "text", "channel"},
+		Confirm:     true,
+		Describe: func(args map[string]string) string {
+			return fmt.Sprintf("Post \"%s\" in %s %s", args["text"], args["channel"], args["when"])
+		},
+		Run: func(api *slack.Client, userID, channelID string, args map[string]string) (string, []slack.Block, error) {
+			when := args["when"]
+			if !strings.HasPrefix(strings.ToLower(when), "every ") {
+				when = "every " + when
+			}
+			request := fmt.Sprintf("%s post %s in %s", when, args["text"], args["channel"])
+			return runScheduleRequest(api, userID, "", request), nil, nil
+		},
+	},
+}
+
+// dmTarget resolves a user and checks userID may message them, returning
+// the DM channel.
+func dmTarget(api *slack.Client, userID, ref string) (string, error) {
+	target, err := resolveUser(api, ref)
+	if err != nil {
+		return "", err
+	}
+	if err := authorize(api, userID, actionDM, target); err != nil {
+		return "", err
+	}
+	return openDirectMessage(api, target)
+}
+
+func findTool(name string) *Tool {
+	for _, t := range tools {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// functionDefinitions describes the tools in the form the API takes.
+func functionDefinitions() []openai.FunctionDefinition {
+	defs := make([]openai.FunctionDefinition, len(tools))
+	for i, t := range tools {
+		properties := make(map[string]interface{})
+		for name, description := range t.Params {
+			properties[name] = map[string]string{"type": "string", "description": description}
+		}
+		required := t.Required
+		if required == nil {
+			required = []string{}
+		}
+
+		defs[i] = openai.FunctionDefinition{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			},
+		}
+	}
+	return defs
+}
+
+//---
+
+// pendingTool is a tool call waiting for the user to confirm it.
+type pendingTool struct {
+	Tool    *Tool
+	Args    map[string]string
+	UserID  string
+	Channel string
+	Created time.Time
+}
+
+var pendingTools = struct {
+	sync.Mutex
+	calls map[string]*pendingTool
+}{calls: make(map[string]*pendingTool)}
+
+// assist answers a message with the model, which may call one of the tools
+// the persona allows. It returns the reply to post.
+func assist(api *slack.Client, userID, channelID, question string) []slack.MsgOption {
+	persona := personaFor(api, userID, channelID, "")
+	system, prompt, sources := withDocuments(channelID, question)
+
+	req := persona.request(system, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt})
+	for _, f := range functionDefinitions() {
+		if persona.allows(f.Name) {
+			req.Functions = append(req.Functions, f)
+		}
+	}
+
+	resp, err := llm.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		return []slack.MsgOption{slack.MsgOptionText("ResponseError: "+err.Error(), false)}
+	}
+	if len(resp.Choices) == 0 {
+		return []slack.MsgOption{slack.MsgOptionText("ResponseError: the model returned no answer", false)}
+	}
+
+	msg := resp.Choices[0].Message
+	if msg.FunctionCall == nil {
+		return personaReply(persona, msg.Content+sources)
+	}
+
+	tool := findTool(msg.FunctionCall.Name)
+	args := make(map[string]string)
+	if tool == nil || !persona.allows(tool.Name) || json.Unmarshal([]byte(msg.FunctionCall.Arguments), &args) != nil {
+		fmt.Printf("model made a bad tool call: %+v\n", msg.FunctionCall)
+		return []slack.MsgOption{slack.MsgOptionText("Sorry, I got confused trying to do that. Could you rephrase?", false)}
+	}
+	for _, name := range tool.Required {
+		if strings.TrimSpace(args[name]) == "" {
+			return []slack.MsgOption{slack.MsgOptionText(fmt.Sprintf("I need the %s for that.", name), false)}
+		}
+	}
+
+	if !tool.Confirm {
+		return runTool(api, tool, userID, channelID, args)
+	}
+
+	id := newID()
+	pendingTools.Lock()
+	for key, p := range pendingTools.calls {
+		if time.Since(p.Created) > pendingToolTTL {
+			delete(pendingTools.calls, key)
+		}
+	}
+	pendingTools.calls[id] = &pendingTool{Tool: tool, Args: args, UserID: userID, Channel: channelID, Created: time.Now()}
+	pendingTools.Unlock()
+
+	description := tool.Describe(args)
+	return []slack.MsgOption{
+		slack.MsgOptionText(description+"?", false),
+		slack.MsgOptionBlocks(
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "Should I do this?\n>"+description, false, false), nil, nil),
+			slack.NewActionBlock("",
+				slack.NewButtonBlockElement(toolConfirmAction, id, slack.NewTextBlockObject(slack.PlainTextType, "Confirm", false, false)).WithStyle(slack.StylePrimary),
+				slack.NewButtonBlockElement(toolCancelAction, id, slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false)),
+			),
+		),
+	}
+}
+
+// personaReply is a text reply, signed with the persona's name.
+func personaReply(persona *Persona, text string) []slack.MsgOption {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if block := persona.contextBlock(); block != nil {
+		options = append(options, slack.MsgOptionBlocks(
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, truncate(text, 3000), false, false), nil, nil),
+			block,
+		))
+	}
+	return options
+}
+
+func runTool(api *slack.Client, tool *Tool, userID, channelID string, args map[string]string) []slack.MsgOption {
+	fmt.Printf("running tool %s for %s: %v\n", tool.Name, userID, args)
+
+	text, blocks, err := tool.Run(api, userID, channelID, args)
+	if err != nil {
+		return []slack.MsgOption{slack.MsgOptionText("Sorry, "+err.Error(), false)}
+	}
+
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if blocks != nil {
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+	return options
+}
+
+// handleToolConfirmation runs or drops a pending tool call from its buttons.
+func handleToolConfirmation(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	api := &client.Client
+
+	pendingTools.Lock()
+	p, ok := pendingTools.calls[action.Value]
+	if ok && p.UserID == callback.User.ID {
+		delete(pendingTools.calls, action.Value)
+	}
+	pendingTools.Unlock()
+
+	var options []slack.MsgOption
+	switch {
+	case !ok || time.Since(p.Created) > pendingToolTTL:
+		options = []slack.MsgOption{slack.MsgOptionText("This request has expired, ask me again.", false), slack.MsgOptionBlocks()}
+	case p.UserID != callback.User.ID:
+		_, err := api.PostEphemeral(callback.Channel.ID, callback.User.ID,
+			slack.MsgOptionText(fmt.Sprintf("Only <@%s> can confirm this.", p.UserID), false))
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+		return
+	case action.ActionID == toolCancelAction:
+		options = []slack.MsgOption{slack.MsgOptionText("Cancelled: "+p.Tool.Describe(p.Args), false), slack.MsgOptionBlocks()}
+	default:
+		fmt.Printf("running tool %s for %s: %v\n", p.Tool.Name, p.UserID, p.Args)
+		text, blocks, err := p.Tool.Run(api, p.UserID, p.Channel, p.Args)
+		if err != nil {
+			text, blocks = "Sorry, "+err.Error(), nil
+		}
+		options = []slack.MsgOption{slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...)}
+	}
+
+	// Replace the buttons with the outcome.
+	_, _, _, err := api.UpdateMessage(callback.Channel.ID, callback.Message.Timestamp, options...)
+	if err != nil {
+		fmt.Printf("failed updating message: %v\n", err)
+	}
+}
+
+// isToolAction reports whether a block action is a tool confirmation button.
+func isToolAction(actionID string) bool {
+	return actionID == toolConfirmAction || actionID == toolCancelAction
+}
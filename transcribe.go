@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Voice clips and audio files.
+//
+// Audio shared in a DM, or in a thread while mentioning the bot, is
+// transcribed and the transcript posted in the thread with a button to
+// summarize it. Transcription uses OpenAI's Whisper, or when
+// TRANSCRIBE_COMMAND is set a local program instead: it's run with the
+// audio file's path as its last argument and prints the transcript.
+
+const (
+	transcriptSummarizeAction = "transcript_summarize"
+
+	// Whisper's upload limit.
+	audioMaxBytes = 25 << 20
+
+	// Dollars per minute of audio.
+	whisperPrice = 0.006
+)
+
+var audioExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".wav": true, ".ogg": true, ".oga": true, ".opus": true,
+	".flac": true, ".webm": true, ".mpga": true, ".mpeg": true, ".aac": true,
+}
+
+func isAudio(f sharedFile) bool {
+	return strings.HasPrefix(f.Mimetype, "audio/") || audioExtensions[strings.ToLower(filepath.Ext(f.Name))]
+}
+
+// Transcriber turns speech into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, name string, audio []byte) (string, error)
+}
+
+var transcriber = newTranscriber()
+
+func newTranscriber() Transcriber {
+	if command := os.Getenv("TRANSCRIBE_COMMAND"); command != "" {
+		return commandTranscriber{command: strings.Fields(command)}
+	}
+	if os.Getenv("LLM_PROVIDER") == "fake" {
+		return fakeTranscriber{}
+	}
+	return whisperTranscriber{client: openai.NewClient(cfg.OpenAIToken)}
+}
+
+//---
+
+// whisperTranscriber uses OpenAI's transcription endpoint, under the same
+// limits and accounting as the other model calls.
+type whisperTranscriber struct {
+	client *openai.Client
+}
+
+func (t whisperTranscriber) Transcribe(ctx context.Context, name string, audio []byte) (string, error) {
+	if err := allowModelCall(); err != nil {
+		return "", err
+	}
+
+	resp, err := t.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    openai.Whisper1,
+		FilePath: name,
+		Reader:   bytes.NewReader(audio),
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return "", err
+	}
+	recordUsage(openai.Whisper1, 0, 0, 0, resp.Duration/60*whisperPrice)
+	return resp.Text, nil
+}
+
+// commandTranscriber runs a local speech-to-text program.
+type commandTranscriber struct {
+	command []string
+}
+
+func (t commandTranscriber) Transcribe(ctx context.Context, name string, audio []byte) (string, error) {
+	f, err := os.CreateTemp("", "audio-*"+filepath.Ext(name))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(audio); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.command[0], append(t.command[1:], f.Name())...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v %s", t.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// fakeTranscriber describes the audio instead.
+type fakeTranscriber struct{}
+
+func (fakeTranscriber) Transcribe(ctx context.Context, name string, audio []byte) (string, error) {
+	return fmt.Sprintf("This is a transcript of %s, %d bytes of audio.", name, len(audio)), nil
+}
+
+//---
+
+// transcriptBlocks shows a transcript, split to fit Slack's section limit,
+// with the Summarize button.
+func transcriptBlocks(name, transcript string) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, ":studio_microphone: Transcript of "+name, false, false)),
+	}
+
+	// Slack allows 50 blocks, keep room for the header and button.
+	chunks := chunkLines(strings.Fields(transcript), 2900)
+	if len(chunks) > 45 {
+		chunks = append(chunks[:44], "…")
+	}
+	for _, chunk := range chunks {
+		text := strings.TrimSpace(strings.ReplaceAll(chunk, "\n", " "))
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.PlainTextType, text, false, false), nil, nil))
+	}
+
+	button := slack.NewButtonBlockElement(transcriptSummarizeAction, "summarize", slack.NewTextBlockObject(slack.PlainTextType, "Summarize", false, false))
+	return append(blocks, slack.NewActionBlock("", button))
+}
+
+// transcribeFiles transcribes the audio among files into the thread. It
+// reports whether there was any.
+func transcribeFiles(api *slack.Client, channelID, threadTS string, files []sharedFile) bool {
+	found := false
+	for _, f := range files {
+		if !isAudio(f) {
+			continue
+		}
+		found = true
+
+		post := func(options ...slack.MsgOption) {
+			options = append(options, slack.MsgOptionTS(threadTS))
+			if _, _, err := api.PostMessage(channelID, options...); err != nil {
+				fmt.Printf("failed posting message: %v", err)
+			}
+		}
+
+		if f.Size > audioMaxBytes {
+			post(slack.MsgOptionText(fmt.Sprintf("Sorry, %s is over the %d MB I can transcribe.", f.Name, audioMaxBytes>>20), false))
+			continue
+		}
+
+		var b bytes.Buffer
+		if err := api.GetFile(f.URL, &b); err != nil {
+			fmt.Printf("failed downloading %s: %v\n", f.Name, err)
+			post(slack.MsgOptionText("Sorry, I couldn't download "+f.Name+".", false))
+			continue
+		}
+
+		transcript, err := transcriber.Transcribe(context.Background(), f.Name, b.Bytes())
+		if err != nil {
+			fmt.Printf("failed transcribing %s: %v\n", f.Name, err)
+			post(slack.MsgOptionText("Sorry, I couldn't transcribe "+f.Name+": "+err.Error(), false))
+			continue
+		}
+		if strings.TrimSpace(transcript) == "" {
+			post(slack.MsgOptionText("I couldn't hear any speech in "+f.Name+".", false))
+			continue
+		}
+
+		post(slack.MsgOptionText(transcript, false), slack.MsgOptionBlocks(transcriptBlocks(f.Name, transcript)...))
+	}
+	return found
+}
+
+// handleAudioMessage transcribes audio sent in a DM. It reports whether
+// there was any.
+func handleAudioMessage(ev *slackevents.MessageEvent, client *socketmode.Client) bool {
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+	return transcribeFiles(&client.Client, ev.Channel, threadTS, eventFiles(ev.Files))
+}
+
+// handleAudioMention transcribes audio shared with a mention. It reports
+// whether there was any.
+func handleAudioMention(ev *slackevents.AppMentionEvent, files []sharedFile, client *socketmode.Client) bool {
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+	return transcribeFiles(&client.Client, ev.Channel, threadTS, files)
+}
+
+// withoutSummarizeButton returns the transcript message's blocks without the
+// Summarize button and with a note underneath.
+func withoutSummarizeButton(msg slack.Message, note string) []slack.Block {
+	var blocks []slack.Block
+	for _, b := range msg.Blocks.BlockSet {
+		if b.BlockType() != slack.MBTAction {
+			blocks = append(blocks, b)
+		}
+	}
+	return append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, note, false, false)))
+}
+
+// summarizing holds the transcripts being summarized, by channel and
+// timestamp, so a second click while the model works is ignored.
+var (
+	summarizingMu sync.Mutex
+	summarizing   = make(map[string]bool)
+)
+
+// handleTranscriptSummary summarizes a transcript when its button is clicked,
+// and takes the button away.
+func handleTranscriptSummary(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	api := &client.Client
+	msg := callback.Message
+	channelID := callback.Channel.ID
+	key := channelID + "/" + msg.Timestamp
+
+	summarizingMu.Lock()
+	if summarizing[key] {
+		summarizingMu.Unlock()
+		return
+	}
+	summarizing[key] = true
+	summarizingMu.Unlock()
+	defer func() {
+		summarizingMu.Lock()
+		delete(summarizing, key)
+		summarizingMu.Unlock()
+	}()
+
+	// The message text is the full transcript, the blocks may be cut short.
+	transcript := msg.Text
+	update := func(blocks []slack.Block) {
+		_, _, _, err := api.UpdateMessage(channelID, msg.Timestamp, slack.MsgOptionText(transcript, false), slack.MsgOptionBlocks(blocks...))
+		if err != nil {
+			fmt.Printf("failed updating message: %v\n", err)
+		}
+	}
+
+	// Take the button away first, so it can't be clicked again.
+	update(withoutSummarizeButton(msg, fmt.Sprintf("Summarizing for <@%s>…", callback.User.ID)))
+
+	threadTS := msg.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = msg.Timestamp
+	}
+
+	var summary string
+	var err error
+	if len(transcript) <= maxSummaryChunkChars {
+		summary, err = complete("Summarize this transcript of a voice message in a few bullet points. "+
+			"Name any decisions, questions and action items.", transcript)
+	} else {
+		summary, err = mapReduceSummary(strings.SplitAfter(transcript, ". "))
+	}
+	if err != nil {
+		summary = "Sorry, I couldn't summarize it: " + err.Error()
+	}
+
+	_, _, err = api.PostMessage(channelID, slack.MsgOptionText("*Summary*\n"+summary, false), slack.MsgOptionTS(threadTS))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+		// Put the button back to try again.
+		update(msg.Blocks.BlockSet)
+		return
+	}
+
+	update(withoutSummarizeButton(msg, fmt.Sprintf("Summarized for <@%s>", callback.User.ID)))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Translation by reaction.
+//
+// Reacting to a message with a flag (:flag-de:, :jp:, ...) posts a
+// translation into that country's language in the message's thread. Each
+// translation is remembered, so reacting again with the same flag doesn't
+// translate the message twice unless it was edited since. Channels can opt
+// out with /translate off.
+
+const (
+	translationsFile = "translations.json"
+
+	// How long translations are remembered.
+	translationTTL = 30 * 24 * time.Hour
+)
+
+// flagLanguages maps country codes, as used in flag emoji names, to the
+// language to translate into.
+var flagLanguages = map[string]string{
+	"ar": "Spanish", "at": "German", "au": "English", "be": "French", "br": "Brazilian Portuguese",
+	"ca": "English", "ch": "German", "cl": "Spanish", "cn": "Simplified Chinese", "co": "Spanish",
+	"cz": "Czech", "de": "German", "dk": "Danish", "eg": "Arabic", "es": "Spanish", "fi": "Finnish",
+	"fr": "French", "gb": "English", "gr": "Greek", "hk": "Traditional Chinese", "hu": "Hungarian",
+	"id": "Indonesian", "ie": "English", "il": "Hebrew", "in": "Hindi", "ir": "Persian", "it": "Italian",
+	"jp": "Japanese", "kr": "Korean", "mx": "Spanish", "my": "Malay", "nl": "Dutch", "no": "Norwegian",
+	"nz": "English", "ph": "Filipino", "pk": "Urdu", "pl": "Polish", "pt": "Portuguese", "ro": "Romanian",
+	"ru": "Russian", "sa": "Arabic", "se": "Swedish", "sk": "Slovak", "th": "Thai", "tr": "Turkish",
+	"tw": "Traditional Chinese", "ua": "Ukrainian", "uk": "English", "us": "English", "vn": "Vietnamese",
+	"za": "English",
+}
+
+// Flags that Slack names without the "flag-" prefix.
+var bareFlags = map[string]bool{
+	"cn": true, "de": true, "es": true, "fr": true, "gb": true, "it": true, "jp": true, "kr": true,
+	"ru": true, "uk": true, "us": true,
+}
+
+// flagLanguage returns the language for a reaction name, if it's a flag.
+func flagLanguage(reaction string) (string, bool) {
+	code := strings.TrimPrefix(reaction, "flag-")
+	if code == reaction && !bareFlags[code] {
+		return "", false
+	}
+	language, ok := flagLanguages[code]
+	return language, ok
+}
+
+type Translation struct {
+	Channel  string    `json:"channel"`
+	TS       string    `json:"ts"`
+	Language string    `json:"language"`
+	Hash     string    `json:"hash"`
+	ReplyTS  string    `json:"reply_ts"`
+	Created  time.Time `json:"created"`
+}
+
+type translationStore struct {
+	jsonStore
+	Translations map[string]*Translation `json:"translations"`
+	OptedOut     map[string]bool         `json:"opted_out"`
+
+	// pending holds the keys being translated right now, so a second flag
+	// while the model is still working doesn't translate the message again.
+	pending map[string]bool
+}
+
+var translations = &translationStore{}
+
+// lock loads the store on first use and locks it.
+func (s *translationStore) lock() {
+	s.lockJSON(translationsFile, "translations", s, func() {
+		if s.Translations == nil {
+			s.Translations = make(map[string]*Translation)
+		}
+		if s.OptedOut == nil {
+			s.OptedOut = make(map[string]bool)
+		}
+		s.pending = make(map[string]bool)
+	})
+}
+
+// save drops expired translations and writes the store. It must be called
+// with s.mu held.
+func (s *translationStore) save() {
+	for key, t := range s.Translations {
+		if time.Since(t.Created) > translationTTL {
+			delete(s.Translations, key)
+		}
+	}
+	s.saveLocked(translationsFile, "translations", s)
+}
+
+func translationKey(channelID, ts, language string) string {
+	return channelID + "/" + ts + "/" + language
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+//---
+
+// handleTranslationReaction translates a message when someone reacts to it
+// with a flag.
+func handleTranslationReaction(ev *slackevents.ReactionAddedEvent, client *socketmode.Client) {
+	if ev.Item.Type != "message" {
+		return
+	}
+	language, ok := flagLanguage(ev.Reaction)
+	if !ok {
+		return
+	}
+	api := &client.Client
+
+	translations.lock()
+	optedOut := translations.OptedOut[ev.Item.Channel]
+	translations.mu.Unlock()
+	if optedOut {
+		return
+	}
+
+	msg, err := findMessage(api, ev.Item.Channel, ev.Item.Timestamp)
+	if err != nil {
+		fmt.Printf("failed fetching message to translate: %v\n", err)
+		return
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		return
+	}
+
+	key := translationKey(ev.Item.Channel, msg.Timestamp, language)
+	hash := textHash(msg.Text)
+
+	translations.lock()
+	cached, ok := translations.Translations[key]
+	if (ok && cached.Hash == hash) || translations.pending[key] {
+		translations.mu.Unlock()
+		fmt.Printf("message %s already translated into %s\n", msg.Timestamp, language)
+		return
+	}
+	translations.pending[key] = true
+	translations.mu.Unlock()
+
+	defer func() {
+		translations.lock()
+		delete(translations.pending, key)
+		translations.mu.Unlock()
+	}()
+
+	translated, err := complete(
+		"You are a translator. Translate the user's Slack message into "+language+". Keep Slack formatting, "+
+			"mentions, links, emoji codes and code blocks as they are. Reply with the translation only.",
+		msg.Text)
+	if err != nil {
+		fmt.Printf("failed translating message: %v\n", err)
+		return
+	}
+
+	threadTS := msg.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = msg.Timestamp
+	}
+	text := fmt.Sprintf(":%s: %s translation, asked for by <@%s>:\n%s", ev.Reaction, language, ev.User, translated)
+
+	_, replyTS, err := api.PostMessage(ev.Item.Channel, slack.MsgOptionText(text, false), slack.MsgOptionTS(threadTS))
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+		return
+	}
+
+	translations.lock()
+	translations.Translations[key] = &Translation{
+		Channel:  ev.Item.Channel,
+		TS:       msg.Timestamp,
+		Language: language,
+		Hash:     hash,
+		ReplyTS:  replyTS,
+		Created:  time.Now(),
+	}
+	translations.save()
+	translations.mu.Unlock()
+}
+
+// runTranslateRequest handles /translate and returns the reply.
+//
+//	/translate       whether flag reactions translate in this channel
+//	/translate off   stop translating here (admins)
+//	/translate on    translate here again (admins)
+func runTranslateRequest(userID, channelID, text string) string {
+	translations.lock()
+	defer translations.mu.Unlock()
+
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "":
+		if translations.OptedOut[channelID] {
+			return "Flag reactions don't translate messages in this channel. " + translateHelp
+		}
+		return "React to a message with a flag, like :flag-de:, to translate it in its thread. " + translateHelp
+
+	case "off", "on":
+		if !isAdmin(userID) {
+			return "Sorry, only bot admins can turn translation on or off."
+		}
+		if strings.EqualFold(strings.TrimSpace(text), "off") {
+			translations.OptedOut[channelID] = true
+			translations.save()
+			return "Flag reactions no longer translate messages in this channel."
+		}
+		delete(translations.OptedOut, channelID)
+		translations.save()
+		return "Flag reactions translate messages in this channel again."
+	}
+
+	return translateHelp
+}
+
+const translateHelp = "Try `/translate`, `/translate off` or `/translate on`."
+
+func handleTranslateCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleTranslateCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+
+	payload := map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          runTranslateRequest(cmd.UserID, cmd.ChannelID, cmd.Text),
+	}
+
+	client.Ack(*evt.Request, payload)
+}
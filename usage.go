@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Rate limits and cost accounting for model calls.
+//
+// Every chat, embedding, vision and image request counts against
+// LLM_REQUESTS_PER_MINUTE (default 60) and, when LLM_DAILY_BUDGET is set, a
+// daily spend in US dollars. Tokens, images and the estimated cost are
+// recorded per day and model in usage.json; "slack-bot usage" prints them.
+
+const (
+	usageFile = "usage.json"
+
+	// How many days of usage are kept.
+	usageDays = 90
+)
+
+var (
+	requestsPerMinute = int(envFloat("LLM_REQUESTS_PER_MINUTE", 60))
+	dailyBudget       = envFloat("LLM_DAILY_BUDGET", 0)
+
+	errRateLimited = fmt.Errorf("I'm handling too many requests right now, try again in a minute")
+	errBudgetSpent = fmt.Errorf("today's budget for AI requests is spent, try again tomorrow")
+)
+
+// Prices in dollars per 1000 prompt and completion tokens. Models are
+// matched by the longest prefix, unknown ones are counted as free.
+var tokenPrices = map[string][2]float64{
+	"gpt-3.5-turbo":          {0.0005, 0.0015},
+	"gpt-4":                  {0.03, 0.06},
+	"gpt-4-32k":              {0.06, 0.12},
+	"gpt-4-turbo":            {0.01, 0.03},
+	"gpt-4o":                 {0.0025, 0.01},
+	"gpt-4o-mini":            {0.00015, 0.0006},
+	"text-embedding-ada-002": {0.0001, 0},
+	"text-embedding-3-small": {0.00002, 0},
+	"text-embedding-3-large": {0.00013, 0},
+}
+
+// Prices in dollars per image, by model and size.
+var imagePrices = map[string]float64{
+	"dall-e-2":           0.02,
+	"dall-e-3":           0.04,
+	"dall-e-3/1024x1792": 0.08,
+	"dall-e-3/1792x1024": 0.08,
+}
+
+func tokenCost(model string, prompt, completion int) float64 {
+	best := ""
+	for prefix := range tokenPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return 0
+	}
+	price := tokenPrices[best]
+	return float64(prompt)/1000*price[0] + float64(completion)/1000*price[1]
+}
+
+func imageCost(model, size string) float64 {
+	if price, ok := imagePrices[model+"/"+size]; ok {
+		return price
+	}
+	return imagePrices[model]
+}
+
+type UsageEntry struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Images           int     `json:"images,omitempty"`
+	Cost             float64 `json:"cost"`
+}
+
+type usageStore struct {
+	jsonStore
+	// Days maps "2006-01-02" to usage by model.
+	Days map[string]map[string]*UsageEntry `json:"days"`
+
+	recent []time.Time
+}
+
+var spending = &usageStore{}
+
+// lock loads the store on first use and locks it.
+func (s *usageStore) lock() {
+	s.lockJSON(usageFile, "usage", s, func() {
+		if s.Days == nil {
+			s.Days = make(map[string]map[string]*UsageEntry)
+		}
+	})
+}
+
+// save drops days past usageDays and writes the store. It must be called
+// with s.mu held.
+func (s *usageStore) save() {
+	cutoff := time.Now().AddDate(0, 0, -usageDays).Format("2006-01-02")
+	for date := range s.Days {
+		if date < cutoff {
+			delete(s.Days, date)
+		}
+	}
+	s.saveLocked(usageFile, "usage", s)
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// allowModelCall reserves a request under the limits, or says why not.
+func allowModelCall() error {
+	spending.lock()
+	defer spending.mu.Unlock()
+
+	if dailyBudget > 0 {
+		spent := 0.0
+		for _, e := range spending.Days[today()] {
+			spent += e.Cost
+		}
+		if spent >= dailyBudget {
+			return errBudgetSpent
+		}
+	}
+
+	if requestsPerMinute > 0 {
+		cutoff := time.Now().Add(-time.Minute)
+		recent := spending.recent[:0]
+		for _, t := range spending.recent {
+			if t.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		spending.recent = recent
+		if len(recent) >= requestsPerMinute {
+			return errRateLimited
+		}
+		spending.recent = append(spending.recent, time.Now())
+	}
+	return nil
+}
+
+// recordUsage adds a finished request to today's totals.
+func recordUsage(model string, prompt, completion, images int, cost float64) {
+	spending.lock()
+	defer spending.mu.Unlock()
+
+	day, ok := spending.Days[today()]
+	if !ok {
+		day = make(map[string]*UsageEntry)
+		spending.Days[today()] = day
+	}
+	e, ok := day[model]
+	if !ok {
+		e = &UsageEntry{}
+		day[model] = e
+	}
+	e.Requests++
+	e.PromptTokens += prompt
+	e.CompletionTokens += completion
+	e.Images += images
+	e.Cost += cost
+	spending.save()
+}
+
+//---
+
+// meteredProvider applies the limits to a provider and records its usage.
+type meteredProvider struct {
+	LLMProvider
+}
+
+func (p meteredProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if err := allowModelCall(); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	resp, err := p.LLMProvider.CreateChatCompletion(ctx, req)
+	if err == nil {
+		u := resp.Usage
+		recordUsage(req.Model, u.PromptTokens, u.CompletionTokens, 0, tokenCost(req.Model, u.PromptTokens, u.CompletionTokens))
+	}
+	return resp, err
+}
+
+func (p meteredProvider) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	if err := allowModelCall(); err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	resp, err := p.LLMProvider.CreateEmbeddings(ctx, conv)
+	if err == nil {
+		model := conv.Convert().Model.String()
+		recordUsage(model, resp.Usage.PromptTokens, 0, 0, tokenCost(model, resp.Usage.PromptTokens, 0))
+	}
+	return resp, err
+}
+
+func (p meteredProvider) GenerateImage(ctx context.Context, req ImageGeneration) (GeneratedImage, error) {
+	if err := allowModelCall(); err != nil {
+		return GeneratedImage{}, err
+	}
+	img, err := p.LLMProvider.GenerateImage(ctx, req)
+	if err == nil {
+		recordUsage(req.Model, 0, 0, 1, imageCost(req.Model, req.Size))
+	}
+	return img, err
+}
+
+//---
+
+// usageCommand implements "slack-bot usage [-days n]".
+func usageCommand(args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	days := flags.Int("days", 7, "how many days to show")
+	flags.Parse(args)
+
+	spending.lock()
+	defer spending.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -*days+1).Format("2006-01-02")
+	var dates []string
+	for date := range spending.Days {
+		if date >= cutoff {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	if len(dates) == 0 {
+		fmt.Println("No usage recorded.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tMODEL\tREQUESTS\tPROMPT\tCOMPLETION\tIMAGES\tCOST")
+	total := 0.0
+	for _, date := range dates {
+		models := make([]string, 0, len(spending.Days[date]))
+		for model := range spending.Days[date] {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			e := spending.Days[date][model]
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t$%.4f\n", date, model, e.Requests, e.PromptTokens, e.CompletionTokens, e.Images, e.Cost)
+			total += e.Cost
+		}
+	}
+	fmt.Fprintf(w, "\t\t\t\t\t\t$%.4f\n", total)
+	return w.Flush()
+}
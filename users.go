@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	_ "time/tzdata" // users.info hands us IANA names, don't depend on the host having zoneinfo
+
+	"github.com/slack-go/slack"
+)
+
+// Keep a small cache of users.info lookups, a user's timezone rarely changes
+// and the API is rate limited.
+var (
+	userCacheMutex sync.Mutex
+	userCache      = make(map[string]cachedUser)
+)
+
+type cachedUser struct {
+	user    *slack.User
+	fetched time.Time
+}
+
+const userCacheTTL = 1 * time.Hour
+
+func getUserInfo(api *slack.Client, userID string) (*slack.User, error) {
+	userCacheMutex.Lock()
+	cached, ok := userCache[userID]
+	userCacheMutex.Unlock()
+
+	if ok && time.Since(cached.fetched) < userCacheTTL {
+		return cached.user, nil
+	}
+
+	user, err := api.GetUserInfo(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	userCacheMutex.Lock()
+	userCache[userID] = cachedUser{user: user, fetched: time.Now()}
+	userCacheMutex.Unlock()
+
+	return user, nil
+}
+
+// getUserLocation returns the timezone configured on the user's Slack profile,
+// falling back to the server's local time when it can't be determined.
+func getUserLocation(api *slack.Client, userID string) *time.Location {
+	user, err := getUserInfo(api, userID)
+	if err != nil {
+		fmt.Printf("failed getting user info for %s: %v\n", userID, err)
+		return time.Local
+	}
+
+	if user.TZ == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(user.TZ)
+	if err != nil {
+		fmt.Printf("unknown timezone %q for %s: %v\n", user.TZ, userID, err)
+		return time.Local
+	}
+
+	return loc
+}
+
+// userName returns the name to show for a user in transcripts, the display
+// name if they set one, falling back to the ID.
+func userName(api *slack.Client, userID string) string {
+	user, err := getUserInfo(api, userID)
+	if err != nil {
+		return userID
+	}
+	for _, name := range []string{user.Profile.DisplayName, user.RealName, user.Name} {
+		if name != "" {
+			return name
+		}
+	}
+	return userID
+}
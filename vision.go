@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Images shared with the bot.
+//
+// When a DM to the bot has images attached, they are downloaded with the
+// bot token and sent to a vision-capable model along with the message text,
+// so people can ask "what's wrong in this stack trace?" about a screenshot.
+// VISION_MODEL picks the model, by default the chat model. The go-openai
+// version we use can't send images, so the request is made directly.
+
+var (
+	visionModel    = envOrDefault("VISION_MODEL", llmModel)
+	visionMaxBytes = int(envFloat("VISION_MAX_BYTES", 20<<20))
+)
+
+// maxImages is how many images of one message go to the model.
+const maxImages = 4
+
+// visionTypes are the image types the model accepts.
+var visionTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Model name prefixes known to accept images.
+var visionModels = []string{"gpt-4o", "gpt-4-turbo", "gpt-4-vision", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"}
+
+func supportsVision(model string) bool {
+	if os.Getenv("LLM_PROVIDER") == "fake" {
+		return true
+	}
+	for _, prefix := range visionModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type Image struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// VisionProvider answers a prompt about images.
+type VisionProvider interface {
+	DescribeImages(ctx context.Context, model, system, prompt string, images []Image) (string, error)
+}
+
+// vision follows LLM_PROVIDER like llm does.
+var vision = newVisionProvider(os.Getenv("LLM_PROVIDER"))
+
+func newVisionProvider(name string) VisionProvider {
+	switch name {
+	case "fake":
+		return fakeVisionProvider{}
+	default:
+		return &openAIVisionProvider{client: &http.Client{Timeout: 2 * time.Minute}, token: cfg.OpenAIToken}
+	}
+}
+
+//---
+
+type openAIVisionProvider struct {
+	client *http.Client
+	token  string
+}
+
+func (p *openAIVisionProvider) DescribeImages(ctx context.Context, model, system, prompt string, images []Image) (string, error) {
+	if err := allowModelCall(); err != nil {
+		return "", err
+	}
+	system, _ = redact(system)
+	prompt, _ = redact(prompt)
+
+	type part struct {
+		Type     string            `json:"type"`
+		Text     string            `json:"text,omitempty"`
+		ImageURL map[string]string `json:"image_url,omitempty"`
+	}
+
+	parts := []part{{Type: "text", Text: prompt}}
+	for _, img := range images {
+		url := "data:" + img.MimeType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+		parts = append(parts, part{Type: "image_url", ImageURL: map[string]string{"url": url}})
+	}
+
+	messages := []interface{}{}
+	if system != "" {
+		messages = append(messages, map[string]string{"role": "system", "content": system})
+	}
+	messages = append(messages, map[string]interface{}{"role": "user", "content": parts})
+
+	body, err := json.Marshal(map[string]interface{}{"model": model, "messages": messages, "max_tokens": 1000})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("OpenAI returned %s", resp.Status)
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("OpenAI returned %s: %s", resp.Status, result.Error.Message)
+	}
+	u := result.Usage
+	recordUsage(model, u.PromptTokens, u.CompletionTokens, 0, tokenCost(model, u.PromptTokens, u.CompletionTokens))
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("the model returned no answer")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// fakeVisionProvider says what it was sent.
+type fakeVisionProvider struct{}
+
+func (fakeVisionProvider) DescribeImages(ctx context.Context, model, system, prompt string, images []Image) (string, error) {
+	names := make([]string, len(images))
+	for i, img := range images {
+		names[i] = fmt.Sprintf("%s (%s, %d bytes)", img.Name, img.MimeType, len(img.Data))
+	}
+	return fmt.Sprintf("You said: %s\nYou sent: %s", prompt, strings.Join(names, ", ")), nil
+}
+
+//---
+
+// downloadImages fetches the images among files, and explains why any of
+// them were left out.
+func downloadImages(api *slack.Client, files []slackevents.File) ([]Image, []string) {
+	var images []Image
+	var skipped []string
+
+	for _, f := range files {
+		switch {
+		case !visionTypes[f.Mimetype]:
+			skipped = append(skipped, fmt.Sprintf("%s isn't an image I can read", f.Name))
+		case f.Size > visionMaxBytes:
+			skipped = append(skipped, fmt.Sprintf("%s is over the %d MB limit", f.Name, visionMaxBytes>>20))
+		case len(images) == maxImages:
+			skipped = append(skipped, fmt.Sprintf("%s is past the first %d images", f.Name, maxImages))
+		default:
+			url := f.URLPrivateDownload
+			if url == "" {
+				url = f.URLPrivate
+			}
+			var b bytes.Buffer
+			if err := api.GetFile(url, &b); err != nil {
+				fmt.Printf("failed downloading %s: %v\n", f.Name, err)
+				skipped = append(skipped, fmt.Sprintf("%s couldn't be downloaded", f.Name))
+				continue
+			}
+			images = append(images, Image{Name: f.Name, MimeType: f.Mimetype, Data: b.Bytes()})
+		}
+	}
+	return images, skipped
+}
+
+// hasImages reports whether any of the files is an image.
+func hasImages(files []slackevents.File) bool {
+	for _, f := range files {
+		if strings.HasPrefix(f.Mimetype, "image/") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleImageMessage answers a DM that came with images.
+func handleImageMessage(ev *slackevents.MessageEvent, client *socketmode.Client) {
+	api := &client.Client
+
+	reply := func(text string) {
+		_, _, err := api.PostMessage(ev.Channel, slack.MsgOptionText(text, false))
+		if err != nil {
+			fmt.Printf("failed posting message: %v", err)
+		}
+	}
+
+	if !supportsVision(visionModel) {
+		reply(fmt.Sprintf("Sorry, I can't look at images with %s. Paste the text instead, or ask an admin to set VISION_MODEL to a model that can.", visionModel))
+		return
+	}
+
+	images, skipped := downloadImages(api, ev.Files)
+	note := ""
+	if len(skipped) > 0 {
+		note = "\n_Left out: " + strings.Join(skipped, "; ") + "._"
+	}
+	if len(images) == 0 {
+		reply("I couldn't use any of those files." + note)
+		return
+	}
+
+	prompt, ok := screen(api, ev.User, ev.Channel, strings.TrimSpace(ev.Text))
+	if !ok {
+		return
+	}
+	if prompt == "" {
+		prompt = "What does this show? If it's an error or a stack trace, explain it and how to fix it."
+	}
+
+	persona := personaFor(api, ev.User, ev.Channel, "")
+	answer, err := vision.DescribeImages(context.Background(), visionModel, persona.System, prompt, images)
+	if err != nil {
+		answer = "ResponseError: " + err.Error()
+	}
+
+	_, _, err = api.PostMessage(ev.Channel, personaReply(persona, answer+note)...)
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Units is the temperature unit a user wants to see, "F" or "C".
+type Units string
+
+const (
+	Fahrenheit Units = "F"
+	Celsius    Units = "C"
+)
+
+// Location is a resolved place name with coordinates.
+type Location struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type CurrentWeather struct {
+	Temperature float64
+	WindSpeed   float64
+	Code        int // WMO weather interpretation code
+}
+
+type DailyForecast struct {
+	Date         time.Time
+	High         float64
+	Low          float64
+	Code         int
+	PrecipChance int
+}
+
+type Weather struct {
+	Location Location
+	Units    Units
+	Current  CurrentWeather
+	Days     []DailyForecast
+	Source   string
+}
+
+// WeatherProvider looks up places and their weather.
+type WeatherProvider interface {
+	Geocode(ctx context.Context, query string) (Location, error)
+	Forecast(ctx context.Context, loc Location, units Units) (*Weather, error)
+}
+
+// weatherProvider is chosen by WEATHER_PROVIDER, "fake" gives canned data
+// for tests and offline development.
+var weatherProvider = newWeatherProvider(os.Getenv("WEATHER_PROVIDER"))
+
+func newWeatherProvider(name string) WeatherProvider {
+	var provider WeatherProvider
+	switch name {
+	case "fake":
+		provider = fakeWeatherProvider{}
+	default:
+		provider = &openMeteoProvider{client: &http.Client{Timeout: 5 * time.Second}}
+	}
+	return newCachedWeatherProvider(provider, 10*time.Minute)
+}
+
+//---
+
+// openMeteoProvider uses the free, keyless Open-Meteo geocoding and forecast APIs.
+type openMeteoProvider struct {
+	client *http.Client
+}
+
+func (p *openMeteoProvider) getJSON(ctx context.Context, endpoint string, params url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *openMeteoProvider) Geocode(ctx context.Context, query string) (Location, error) {
+	// Open-Meteo matches on the place name only, "Portland, OR" finds nothing.
+	name := strings.TrimSpace(strings.Split(query, ",")[0])
+
+	var result struct {
+		Results []struct {
+			Name      string  `json:"name"`
+			Admin1    string  `json:"admin1"`
+			Country   string  `json:"country"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"results"`
+	}
+
+	params := url.Values{"name": {name}, "count": {"1"}, "language": {"en"}, "format": {"json"}}
+	if err := p.getJSON(ctx, "https://geocoding-api.open-meteo.com/v1/search", params, &result); err != nil {
+		return Location{}, err
+	}
+
+	if len(result.Results) == 0 {
+		return Location{}, fmt.Errorf("I can't find a place called %q", query)
+	}
+
+	r := result.Results[0]
+	parts := []string{r.Name}
+	for _, part := range []string{r.Admin1, r.Country} {
+		if part != "" && part != r.Name {
+			parts = append(parts, part)
+		}
+	}
+
+	return Location{Name: strings.Join(parts, ", "), Latitude: r.Latitude, Longitude: r.Longitude}, nil
+}
+
+func (p *openMeteoProvider) Forecast(ctx context.Context, loc Location, units Units) (*Weather, error) {
+	var result struct {
+		CurrentWeather struct {
+			Temperature float64 `json:"temperature"`
+			WindSpeed   float64 `json:"windspeed"`
+			WeatherCode int     `json:"weathercode"`
+		} `json:"current_weather"`
+		Daily struct {
+			Time         []string  `json:"time"`
+			Max          []float64 `json:"temperature_2m_max"`
+			Min          []float64 `json:"temperature_2m_min"`
+			WeatherCode  []int     `json:"weathercode"`
+			PrecipChance []int     `json:"precipitation_probability_max"`
+		} `json:"daily"`
+	}
+
+	params := url.Values{
+		"latitude":        {fmt.Sprintf("%.4f", loc.Latitude)},
+		"longitude":       {fmt.Sprintf("%.4f", loc.Longitude)},
+		"current_weather": {"true"},
+		"daily":           {"temperature_2m_max,temperature_2m_min,weathercode,precipitation_probability_max"},
+		"timezone":        {"auto"},
+		"forecast_days":   {"3"},
+	}
+	if units == Fahrenheit {
+		params.Set("temperature_unit", "fahrenheit")
+		params.Set("windspeed_unit", "mph")
+	}
+
+	if err := p.getJSON(ctx, "https://api.open-meteo.com/v1/forecast", params, &result); err != nil {
+		return nil, err
+	}
+
+	weather := &Weather{
+		Location: loc,
+		Units:    units,
+		Current: CurrentWeather{
+			Temperature: result.CurrentWeather.Temperature,
+			WindSpeed:   result.CurrentWeather.WindSpeed,
+			Code:        result.CurrentWeather.WeatherCode,
+		},
+		Source: "Open-Meteo",
+	}
+
+	d := result.Daily
+	for i := range d.Time {
+		if i >= len(d.Max) || i >= len(d.Min) || i >= len(d.WeatherCode) {
+			break
+		}
+		date, err := time.Parse("2006-01-02", d.Time[i])
+		if err != nil {
+			continue
+		}
+		day := DailyForecast{Date: date, High: d.Max[i], Low: d.Min[i], Code: d.WeatherCode[i]}
+		if i < len(d.PrecipChance) {
+			day.PrecipChance = d.PrecipChance[i]
+		}
+		weather.Days = append(weather.Days, day)
+	}
+
+	return weather, nil
+}
+
+//---
+
+// fakeWeatherProvider knows every place and it's always the same weather there.
+type fakeWeatherProvider struct{}
+
+func (fakeWeatherProvider) Geocode(ctx context.Context, query string) (Location, error) {
+	return Location{Name: strings.TrimSpace(query), Latitude: 33.45, Longitude: -112.07}, nil
+}
+
+func (fakeWeatherProvider) Forecast(ctx context.Context, loc Location, units Units) (*Weather, error) {
+	temp := func(f float64) float64 {
+		if units == Celsius {
+			return (f - 32) * 5 / 9
+		}
+		return f
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	return &Weather{
+		Location: loc,
+		Units:    units,
+		Current:  CurrentWeather{Temperature: temp(102), WindSpeed: 5, Code: 0},
+		Days: []DailyForecast{
+			{Date: today, High: temp(104), Low: temp(81), Code: 0},
+			{Date: today.AddDate(0, 0, 1), High: temp(101), Low: temp(79), Code: 2, PrecipChance: 10},
+			{Date: today.AddDate(0, 0, 2), High: temp(97), Low: temp(77), Code: 95, PrecipChance: 60},
+		},
+		Source: "fake",
+	}, nil
+}
+
+//---
+
+// cachedWeatherProvider keeps geocoding results for the life of the process
+// and forecasts for ttl, so a busy channel doesn't hammer the upstream API.
+type cachedWeatherProvider struct {
+	provider WeatherProvider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	locations map[string]Location
+	forecasts map[string]cachedForecast
+}
+
+type cachedForecast struct {
+	weather *Weather
+	fetched time.Time
+}
+
+func newCachedWeatherProvider(provider WeatherProvider, ttl time.Duration) *cachedWeatherProvider {
+	return &cachedWeatherProvider{
+		provider:  provider,
+		ttl:       ttl,
+		locations: make(map[string]Location),
+		forecasts: make(map[string]cachedForecast),
+	}
+}
+
+func (c *cachedWeatherProvider) Geocode(ctx context.Context, query string) (Location, error) {
+	key := strings.ToLower(strings.TrimSpace(query))
+
+	c.mu.Lock()
+	loc, ok := c.locations[key]
+	c.mu.Unlock()
+	if ok {
+		return loc, nil
+	}
+
+	loc, err := c.provider.Geocode(ctx, query)
+	if err != nil {
+		return Location{}, err
+	}
+
+	c.mu.Lock()
+	c.locations[key] = loc
+	c.mu.Unlock()
+	return loc, nil
+}
+
+func (c *cachedWeatherProvider) Forecast(ctx context.Context, loc Location, units Units) (*Weather, error) {
+	key := fmt.Sprintf("%.2f,%.2f,%s", loc.Latitude, loc.Longitude, units)
+
+	c.mu.Lock()
+	cached, ok := c.forecasts[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < c.ttl {
+		// Nearby places share a forecast, keep the name that was asked for.
+		weather := *cached.weather
+		weather.Location = loc
+		return &weather, nil
+	}
+
+	weather, err := c.provider.Forecast(ctx, loc, units)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.forecasts[key] = cachedForecast{weather: weather, fetched: time.Now()}
+	c.mu.Unlock()
+	return weather, nil
+}
+
+//---
+
+const weatherPrefsFile = "weather_prefs.json"
+
+// WeatherPrefs are a user's default location and units.
+type WeatherPrefs struct {
+	Location string `json:"location,omitempty"`
+	Units    Units  `json:"units,omitempty"`
+}
+
+// weatherPrefStore keeps WeatherPrefs by user ID. The file is the bare map.
+type weatherPrefStore struct {
+	jsonStore
+	prefs map[string]WeatherPrefs
+}
+
+var weatherPrefs = &weatherPrefStore{}
+
+// lock loads the store on first use and locks it.
+func (s *weatherPrefStore) lock() {
+	s.lockJSON(weatherPrefsFile, "weather preferences", &s.prefs, func() {
+		if s.prefs == nil {
+			s.prefs = make(map[string]WeatherPrefs)
+		}
+	})
+}
+
+// defaultUnits is WEATHER_UNITS, for users who haven't picked their own.
+var defaultUnits = weatherUnitsFromEnv()
+
+func weatherUnitsFromEnv() Units {
+	value := envOrDefault("WEATHER_UNITS", string(Fahrenheit))
+	units, ok := parseUnits(value)
+	if !ok {
+		fmt.Printf("WEATHER_UNITS %q is not F or C, using °F\n", value)
+		return Fahrenheit
+	}
+	return units
+}
+
+func getWeatherPrefs(userID string) WeatherPrefs {
+	weatherPrefs.lock()
+	defer weatherPrefs.mu.Unlock()
+
+	prefs := weatherPrefs.prefs[userID]
+	if prefs.Units == "" {
+		prefs.Units = defaultUnits
+	}
+	return prefs
+}
+
+func setWeatherPrefs(userID string, prefs WeatherPrefs) {
+	weatherPrefs.lock()
+	defer weatherPrefs.mu.Unlock()
+
+	weatherPrefs.prefs[userID] = prefs
+	weatherPrefs.saveLocked(weatherPrefsFile, "weather preferences", weatherPrefs.prefs)
+}
+
+// parseUnits recognizes a unit given on its own, "c", "°F", "celsius".
+func parseUnits(s string) (Units, bool) {
+	switch strings.Trim(strings.ToLower(s), "°") {
+	case "f", "fahrenheit", "imperial":
+		return Fahrenheit, true
+	case "c", "celsius", "metric":
+		return Celsius, true
+	}
+	return "", false
+}
+
+// runWeatherRequest handles "/weather [place] [c|f]", "/weather default <place>"
+// and "/weather units <c|f>". It returns blocks, or a plain text reply.
+func runWeatherRequest(userID, text string) ([]slack.Block, string) {
+	prefs := getWeatherPrefs(userID)
+	fields := strings.Fields(text)
+
+	if len(fields) > 0 {
+		switch strings.ToLower(fields[0]) {
+		case "help":
+			return nil, weatherHelp
+
+		case "default", "set":
+			place := strings.Join(fields[1:], " ")
+			if place == "" {
+				return nil, weatherHelp
+			}
+			loc, err := weatherProvider.Geocode(context.Background(), place)
+			if err != nil {
+				return nil, "Sorry, " + err.Error()
+			}
+			prefs.Location = place
+			setWeatherPrefs(userID, prefs)
+			return nil, "Your default weather location is now " + loc.Name + "."
+
+		case "units":
+			if len(fields) < 2 {
+				return nil, "Your weather units are °" + string(prefs.Units) + "."
+			}
+			units, ok := parseUnits(fields[1])
+			if !ok {
+				return nil, weatherHelp
+			}
+			prefs.Units = units
+			setWeatherPrefs(userID, prefs)
+			return nil, "I'll show you temperatures in °" + string(units) + "."
+		}
+
+		// A trailing unit overrides the preference for this request only.
+		if units, ok := parseUnits(fields[len(fields)-1]); ok {
+			prefs.Units = units
+			fields = fields[:len(fields)-1]
+		}
+	}
+
+	place := strings.Join(fields, " ")
+	if place == "" {
+		place = prefs.Location
+	}
+	if place == "" {
+		return nil, "Where? Try `/weather Phoenix`, or set a default with `/weather default Phoenix`."
+	}
+
+	ctx := context.Background()
+	loc, err := weatherProvider.Geocode(ctx, place)
+	if err != nil {
+		return nil, "Sorry, " + err.Error()
+	}
+
+	weather, err := weatherProvider.Forecast(ctx, loc, prefs.Units)
+	if err != nil {
+		return nil, "Sorry, I couldn't get the weather: " + err.Error()
+	}
+
+	return weatherBlocks(weather), ""
+}
+
+const weatherHelp = "Try `/weather Phoenix`, `/weather Berlin c`, " +
+	"`/weather default Phoenix` to set your location, or `/weather units c` to switch to °C."
+
+func weatherBlocks(w *Weather) []slack.Block {
+	unit := "°" + string(w.Units)
+	wind := "km/h"
+	if w.Units == Fahrenheit {
+		wind = "mph"
+	}
+
+	emoji, description := describeWeatherCode(w.Current.Code)
+	current := fmt.Sprintf("*%s*\n%s *%.0f%s* %s, wind %.0f %s",
+		w.Location.Name, emoji, w.Current.Temperature, unit, description, w.Current.WindSpeed, wind)
+
+	var days []*slack.TextBlockObject
+	for _, day := range w.Days {
+		emoji, description := describeWeatherCode(day.Code)
+		text := fmt.Sprintf("*%s*\n%s %s\n%.0f%s / %.0f%s",
+			day.Date.Format("Mon Jan 2"), emoji, description, day.High, unit, day.Low, unit)
+		if day.PrecipChance > 0 {
+			text += fmt.Sprintf(", %d%% precip", day.PrecipChance)
+		}
+		days = append(days, slack.NewTextBlockObject(slack.MarkdownType, text, false, false))
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, current, false, false), nil, nil),
+	}
+	if len(days) > 0 {
+		blocks = append(blocks, slack.NewSectionBlock(nil, days, nil))
+	}
+	blocks = append(blocks, slack.NewContextBlock("",
+		slack.NewTextBlockObject(slack.MarkdownType, "Weather data: "+w.Source, false, false)))
+
+	return blocks
+}
+
+// describeWeatherCode maps a WMO weather interpretation code to words.
+func describeWeatherCode(code int) (string, string) {
+	switch {
+	case code == 0:
+		return ":sunny:", "Clear sky"
+	case code <= 2:
+		return ":partly_sunny:", "Partly cloudy"
+	case code == 3:
+		return ":cloud:", "Overcast"
+	case code == 45 || code == 48:
+		return ":fog:", "Fog"
+	case code >= 51 && code <= 57:
+		return ":rain_cloud:", "Drizzle"
+	case code >= 61 && code <= 67, code >= 80 && code <= 82:
+		return ":rain_cloud:", "Rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return ":snowflake:", "Snow"
+	case code >= 95:
+		return ":thunder_cloud_and_rain:", "Thunderstorm"
+	}
+	return ":thermometer:", "Unknown"
+}
+
+// weatherQuestionPlace extracts the place from "what is the weather like in Paris",
+// "weather in Paris" and friends. ok is false when the message isn't about weather.
+func weatherQuestionPlace(text string) (string, bool) {
+	text = strings.TrimRight(strings.TrimSpace(text), "?")
+	lower := strings.ToLower(text)
+
+	for _, prefix := range []string{"what is the weather like", "what's the weather like", "what's the weather", "what is the weather", "weather"} {
+		if lower == prefix {
+			return "", true
+		}
+		if strings.HasPrefix(lower, prefix+" in ") {
+			return strings.TrimSpace(text[len(prefix+" in "):]), true
+		}
+		if strings.HasPrefix(lower, prefix+" for ") {
+			return strings.TrimSpace(text[len(prefix+" for "):]), true
+		}
+	}
+	return "", false
+}
+
+func handleWeatherMessage(ev *slackevents.MessageEvent, client *socketmode.Client, place string) {
+	blocks, responseText := runWeatherRequest(ev.User, place)
+
+	option := slack.MsgOptionText(responseText, false)
+	if blocks != nil {
+		option = slack.MsgOptionBlocks(blocks...)
+	}
+
+	_, _, err := client.Client.PostMessage(ev.Channel, option)
+	if err != nil {
+		fmt.Printf("failed posting message: %v", err)
+	}
+}
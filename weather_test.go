@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// useFakeWeather points the weather commands at the fake provider and a
+// fresh preferences store.
+func useFakeWeather(t *testing.T) {
+	t.Helper()
+	cfg.DataDir = t.TempDir()
+	weatherPrefs = &weatherPrefStore{}
+
+	saved := weatherProvider
+	weatherProvider = newWeatherProvider("fake")
+	t.Cleanup(func() { weatherProvider = saved })
+}
+
+func blocksJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRunWeatherRequest(t *testing.T) {
+	useFakeWeather(t)
+
+	tests := []struct {
+		text string
+		// want is in the blocks, or the text reply when there are none.
+		want   []string
+		blocks bool
+	}{
+		{"Phoenix", []string{"Phoenix", "102°F", "mph", "Weather data: fake"}, true},
+		{"Berlin c", []string{"Berlin", "39°C", "km/h"}, true},
+		{"New York °C", []string{"New York", "39°C"}, true},
+		{"", []string{"Where?"}, false},
+		{"help", []string{"/weather units c"}, false},
+		{"units", []string{"°F"}, false},
+		{"units kelvin", []string{"Try `/weather Phoenix`"}, false},
+		{"default", []string{"Try `/weather Phoenix`"}, false},
+	}
+
+	for _, tt := range tests {
+		blocks, text := runWeatherRequest("U1", tt.text)
+		if (blocks != nil) != tt.blocks {
+			t.Errorf("runWeatherRequest(%q) blocks = %v, text = %q", tt.text, blocks != nil, text)
+			continue
+		}
+		got := text
+		if blocks != nil {
+			got = blocksJSON(t, blocks)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("runWeatherRequest(%q) = %s, want it to contain %q", tt.text, got, want)
+			}
+		}
+	}
+}
+
+func TestRunWeatherRequestPreferences(t *testing.T) {
+	useFakeWeather(t)
+
+	if _, text := runWeatherRequest("U1", "default Tucson"); text != "Your default weather location is now Tucson." {
+		t.Errorf("setting the default = %q", text)
+	}
+	if _, text := runWeatherRequest("U1", "units celsius"); text != "I'll show you temperatures in °C." {
+		t.Errorf("setting the units = %q", text)
+	}
+
+	blocks, text := runWeatherRequest("U1", "")
+	if blocks == nil {
+		t.Fatalf("weather at the default location = %q", text)
+	}
+	if got := blocksJSON(t, blocks); !strings.Contains(got, "Tucson") || !strings.Contains(got, "°C") {
+		t.Errorf("weather at the default location = %s", got)
+	}
+
+	// A unit given with the request doesn't change the preference.
+	runWeatherRequest("U1", "Tucson f")
+	if prefs := getWeatherPrefs("U1"); prefs.Units != Celsius || prefs.Location != "Tucson" {
+		t.Errorf("preferences = %+v", prefs)
+	}
+
+	// They survive a restart.
+	weatherPrefs = &weatherPrefStore{}
+	if prefs := getWeatherPrefs("U1"); prefs.Units != Celsius || prefs.Location != "Tucson" {
+		t.Errorf("reloaded preferences = %+v", prefs)
+	}
+
+	// Other users get the defaults.
+	if prefs := getWeatherPrefs("U2"); prefs.Units != defaultUnits || prefs.Location != "" {
+		t.Errorf("another user's preferences = %+v", prefs)
+	}
+}
+
+func TestWeatherQuestionPlace(t *testing.T) {
+	tests := []struct {
+		text, place string
+		ok          bool
+	}{
+		{"what is the weather like in Paris?", "Paris", true},
+		{"What's the weather like in New York", "New York", true},
+		{"what's the weather for Portland, OR?", "Portland, OR", true},
+		{"weather in Tokyo", "Tokyo", true},
+		{"Weather", "", true},
+		{"what is the weather like?", "", true},
+		{"weathering the storm", "", false},
+		{"is it sunny in Paris", "", false},
+		{"tell me a joke", "", false},
+	}
+	for _, tt := range tests {
+		place, ok := weatherQuestionPlace(tt.text)
+		if place != tt.place || ok != tt.ok {
+			t.Errorf("weatherQuestionPlace(%q) = %q, %v; want %q, %v", tt.text, place, ok, tt.place, tt.ok)
+		}
+	}
+}
+
+func TestWeatherUnitsFromEnv(t *testing.T) {
+	tests := map[string]Units{
+		"":        Fahrenheit,
+		"C":       Celsius,
+		"celsius": Celsius,
+		"°F":      Fahrenheit,
+		"kelvin":  Fahrenheit,
+	}
+	for value, want := range tests {
+		t.Setenv("WEATHER_UNITS", value)
+		if got := weatherUnitsFromEnv(); got != want {
+			t.Errorf("WEATHER_UNITS=%q gives %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Incoming webhook receiver for alerts.
+//
+// POST /webhook with a bearer token (WEBHOOK_TOKEN) or an HMAC-SHA256 of the
+// body in X-Signature-256: sha256=<hex> (WEBHOOK_SECRET). The body is either
+// an Alertmanager/Grafana notification with an "alerts" array, or a generic
+// {"title", "text", "severity", "status", "labels", "fingerprint", "url"} object.
+//
+// Alerts are routed to channels by label rules and rendered through templates
+// from alerts.json in the data directory. Repeats of a firing alert within the
+// dedupe window are dropped, and later notifications for the same fingerprint
+// are threaded under the original message.
+
+const (
+	alertsConfigFile = "alerts.json"
+	alertsStateFile  = "alerts_state.json"
+	maxWebhookBody   = 1 << 20
+
+	// How long alerts are remembered after they resolve, and when a firing
+	// alert nobody has heard about in a while is given up on.
+	alertRetention = 7 * 24 * time.Hour
+	staleAlertAge  = 30 * 24 * time.Hour
+)
+
+// Alert is a single normalized alert, whatever format it arrived in.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      string            `json:"status"` // firing or resolved
+	Title       string            `json:"title"`
+	Text        string            `json:"text"`
+	Severity    string            `json:"severity"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"starts_at"`
+	URL         string            `json:"url"`
+}
+
+// AlertRoute sends alerts whose labels match every entry in Match to Channel.
+type AlertRoute struct {
+	Match      map[string]string `json:"match"`
+	Channel    string            `json:"channel"`
+	Escalation *EscalationPolicy `json:"escalation,omitempty"` // overrides the default
+}
+
+// EscalationPolicy says who gets a DM when nobody acknowledges an alert.
+type EscalationPolicy struct {
+	Timeout  string   `json:"timeout"`  // e.g. "15m", empty disables automatic escalation
+	Rotation []string `json:"rotation"` // users to DM in turn: @name or user ID
+	OnCall   string   `json:"oncall"`   // or follow an /oncall rotation, starting with whoever is on call
+}
+
+type AlertsConfig struct {
+	DefaultChannel string       `json:"default_channel"`
+	Routes         []AlertRoute `json:"routes"`
+	Templates      struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	} `json:"templates"`
+	DedupeWindow string           `json:"dedupe_window"`
+	Escalation   EscalationPolicy `json:"escalation"`
+}
+
+func loadAlertsConfig() (*AlertsConfig, error) {
+	config := &AlertsConfig{DefaultChannel: os.Getenv("ALERTS_CHANNEL"), DedupeWindow: "30m"}
+	if err := loadJSON(alertsConfigFile, config); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", alertsConfigFile, err)
+	}
+	if config.Templates.Title == "" {
+		config.Templates.Title = "{{.Title}}"
+	}
+	if config.Templates.Body == "" {
+		config.Templates.Body = "{{.Text}}"
+	}
+	return config, nil
+}
+
+// route picks the channel and escalation policy for an alert.
+func (c *AlertsConfig) route(alert Alert) (string, EscalationPolicy) {
+	for _, r := range c.Routes {
+		matched := true
+		for key, value := range r.Match {
+			if alert.Labels[key] != value && !(key == "severity" && alert.Severity == value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			if r.Escalation != nil {
+				return r.Channel, *r.Escalation
+			}
+			return r.Channel, c.Escalation
+		}
+	}
+	return c.DefaultChannel, c.Escalation
+}
+
+//---
+
+// parseAlerts normalizes an Alertmanager/Grafana or generic payload.
+func parseAlerts(body []byte) ([]Alert, error) {
+	var probe struct {
+		Alerts json.RawMessage `json:"alerts"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Alerts != nil {
+		var payload struct {
+			Alerts []struct {
+				Status       string            `json:"status"`
+				Labels       map[string]string `json:"labels"`
+				Annotations  map[string]string `json:"annotations"`
+				StartsAt     time.Time         `json:"startsAt"`
+				GeneratorURL string            `json:"generatorURL"`
+				Fingerprint  string            `json:"fingerprint"`
+				PanelURL     string            `json:"panelURL"`
+			} `json:"alerts"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+
+		var alerts []Alert
+		for _, a := range payload.Alerts {
+			alert := Alert{
+				Fingerprint: a.Fingerprint,
+				Status:      a.Status,
+				Title:       firstNonEmpty(a.Annotations["summary"], a.Labels["alertname"]),
+				Text:        firstNonEmpty(a.Annotations["description"], a.Annotations["message"]),
+				Severity:    a.Labels["severity"],
+				Labels:      a.Labels,
+				Annotations: a.Annotations,
+				StartsAt:    a.StartsAt,
+				URL:         firstNonEmpty(a.PanelURL, a.GeneratorURL),
+			}
+			alerts = append(alerts, normalizeAlert(alert))
+		}
+		return alerts, nil
+	}
+
+	var alert Alert
+	if err := json.Unmarshal(body, &alert); err != nil {
+		return nil, err
+	}
+	if alert.Title == "" && alert.Text == "" {
+		return nil, fmt.Errorf("payload has neither alerts nor a title or text")
+	}
+	return []Alert{normalizeAlert(alert)}, nil
+}
+
+func normalizeAlert(alert Alert) Alert {
+	alert.Status = strings.ToLower(alert.Status)
+	if alert.Status != "resolved" {
+		alert.Status = "firing"
+	}
+	if alert.Title == "" {
+		alert.Title = "Alert"
+	}
+	if alert.StartsAt.IsZero() {
+		alert.StartsAt = time.Now()
+	}
+	if alert.Fingerprint == "" {
+		alert.Fingerprint = alertFingerprint(alert)
+	}
+	return alert
+}
+
+// alertFingerprint identifies an alert by its title and labels, so the
+// resolved notification finds the firing one.
+func alertFingerprint(alert Alert) string {
+	keys := make([]string, 0, len(alert.Labels))
+	for k := range alert.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	h.Write([]byte(alert.Title))
+	for _, k := range keys {
+		fmt.Fprintf(h, "\x00%s=%s", k, alert.Labels[k])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+//---
+
+// AlertRecord is what we remember about an alert we posted.
+type AlertRecord struct {
+	Alert     Alert     `json:"alert"`
+	Channel   string    `json:"channel"`
+	TS        string    `json:"ts"` // the top-level message, follow-ups thread under it
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastPost  time.Time `json:"last_post"`
+	Count     int       `json:"count"`
+
+	AckedBy    string    `json:"acked_by,omitempty"`
+	AckedAt    time.Time `json:"acked_at,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty"` // empty when monitoring resolved it
+	ResolvedAt time.Time `json:"resolved_at,omitempty"`
+
+	Escalation      EscalationPolicy `json:"escalation"`
+	EscalationLevel int              `json:"escalation_level"` // how many people we've DMed
+	EscalatedTo     string           `json:"escalated_to,omitempty"`
+	LastEscalation  time.Time        `json:"last_escalation,omitempty"`
+	// EscalationExhausted is set once everyone in the rotation was paged.
+	EscalationExhausted bool `json:"escalation_exhausted,omitempty"`
+}
+
+type alertStore struct {
+	jsonStore
+	Records map[string]*AlertRecord `json:"records"`
+}
+
+var alertState = &alertStore{Records: make(map[string]*AlertRecord)}
+
+// lock loads the store on first use and locks it.
+func (s *alertStore) lock() {
+	s.lockJSON(alertsStateFile, "alert state", s, func() {
+		if s.Records == nil {
+			s.Records = make(map[string]*AlertRecord)
+		}
+	})
+}
+
+// save must be called with s.mu held.
+func (s *alertStore) save() {
+	s.saveLocked(alertsStateFile, "alert state", s)
+}
+
+// prune forgets alerts resolved more than alertRetention ago, and firing ones
+// monitoring hasn't mentioned in staleAlertAge. Must hold s.mu.
+func (s *alertStore) prune(now time.Time) {
+	for fingerprint, rec := range s.Records {
+		resolved := rec.Alert.Status == "resolved" && now.Sub(rec.ResolvedAt) > alertRetention
+		if resolved || now.Sub(rec.LastSeen) > staleAlertAge {
+			delete(s.Records, fingerprint)
+		}
+	}
+}
+
+//---
+
+type alertRenderer struct {
+	title *template.Template
+	body  *template.Template
+}
+
+func newAlertRenderer(config *AlertsConfig) (*alertRenderer, error) {
+	title, err := template.New("title").Parse(config.Templates.Title)
+	if err != nil {
+		return nil, fmt.Errorf("title template: %v", err)
+	}
+	body, err := template.New("body").Parse(config.Templates.Body)
+	if err != nil {
+		return nil, fmt.Errorf("body template: %v", err)
+	}
+	return &alertRenderer{title: title, body: body}, nil
+}
+
+func (r *alertRenderer) execute(t *template.Template, alert Alert) string {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, alert); err != nil {
+		return fmt.Sprintf("(template error: %v)", err)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// blocks renders the alert's top-level message, with who has acted on it
+// and the buttons that still make sense.
+func (r *alertRenderer) blocks(rec *AlertRecord) []slack.Block {
+	alert := rec.Alert
+
+	emoji := ":red_circle:"
+	if alert.Status == "resolved" {
+		emoji = ":large_green_circle:"
+	}
+
+	title := r.execute(r.title, alert)
+	text := fmt.Sprintf("%s *%s*", emoji, title)
+	if body := r.execute(r.body, alert); body != "" {
+		text += "\n" + body
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+
+	var context []slack.MixedElement
+	context = append(context, slack.NewTextBlockObject(slack.MarkdownType,
+		fmt.Sprintf("*%s* since %s", strings.ToUpper(alert.Status), slackDate(alert.StartsAt, alert.StartsAt.Format(time.RFC1123))), false, false))
+	if alert.Severity != "" {
+		context = append(context, slack.NewTextBlockObject(slack.MarkdownType, "severity: *"+alert.Severity+"*", false, false))
+	}
+	if labels := formatLabels(alert.Labels); labels != "" {
+		context = append(context, slack.NewTextBlockObject(slack.MarkdownType, labels, false, false))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", context...))
+
+	if status := alertStatusLine(rec); status != "" {
+		blocks = append(blocks, slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType, status, false, false)))
+	}
+
+	var buttons []slack.BlockElement
+	if alert.Status == "firing" {
+		if rec.AckedBy == "" {
+			buttons = append(buttons, alertButton("alert_ack", "Acknowledge", alert.Fingerprint, slack.StylePrimary))
+		}
+		buttons = append(buttons,
+			alertButton("alert_resolve", "Resolve", alert.Fingerprint, ""),
+			alertButton("alert_escalate", "Escalate", alert.Fingerprint, slack.StyleDanger))
+	}
+	if alert.URL != "" {
+		button := slack.NewButtonBlockElement("alert_link", alert.Fingerprint,
+			slack.NewTextBlockObject(slack.PlainTextType, "Open", false, false))
+		button.URL = alert.URL
+		buttons = append(buttons, button)
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slack.NewActionBlock("alert_actions", buttons...))
+	}
+
+	return blocks
+}
+
+func alertButton(actionID, label, fingerprint string, style slack.Style) *slack.ButtonBlockElement {
+	button := slack.NewButtonBlockElement(actionID, fingerprint,
+		slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+	button.Style = style
+	return button
+}
+
+// alertStatusLine says who acknowledged, escalated or resolved the alert, and when.
+func alertStatusLine(rec *AlertRecord) string {
+	var parts []string
+	if rec.AckedBy != "" {
+		parts = append(parts, fmt.Sprintf(":eyes: Acknowledged by <@%s> %s", rec.AckedBy, slackDate(rec.AckedAt, rec.AckedAt.Format(time.RFC1123))))
+	}
+	if rec.EscalatedTo != "" && rec.Alert.Status == "firing" {
+		parts = append(parts, fmt.Sprintf(":rotating_light: Escalated to <@%s> %s", rec.EscalatedTo, slackDate(rec.LastEscalation, rec.LastEscalation.Format(time.RFC1123))))
+	}
+	if rec.Alert.Status == "resolved" && !rec.ResolvedAt.IsZero() {
+		by := "monitoring"
+		if rec.ResolvedBy != "" {
+			by = "<@" + rec.ResolvedBy + ">"
+		}
+		parts = append(parts, fmt.Sprintf(":white_check_mark: Resolved by %s %s", by, slackDate(rec.ResolvedAt, rec.ResolvedAt.Format(time.RFC1123))))
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "severity" && k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("`%s=%s`", k, labels[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+//---
+
+type webhookServer struct {
+	api      *slack.Client
+	token    string
+	secret   string
+	config   *AlertsConfig
+	renderer *alertRenderer
+	window   time.Duration
+
+	// resolved channel and user references, "#alerts" -> C123, "@ana" -> U123
+	channelsMutex sync.Mutex
+	channels      map[string]string
+	users         map[string]string
+}
+
+// runWebhookServer listens on WEBHOOK_ADDR until stopChannel is closed.
+func runWebhookServer(api *slack.Client) {
+	addr := os.Getenv("WEBHOOK_ADDR")
+	if addr == "" {
+		return
+	}
+
+	server, err := newWebhookServer(api)
+	if err != nil {
+		fmt.Printf("Webhook receiver disabled: %v\n", err)
+		return
+	}
+
+	alertServer = server
+	go server.runEscalations(stopChannel)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", server.handleWebhook)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintln(w, "ok") })
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		// A batch of alerts is posted to Slack before the response is written.
+		WriteTimeout: 2 * time.Minute,
+		IdleTimeout:  2 * time.Minute,
+	}
+
+	go func() {
+		<-stopChannel
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			fmt.Printf("Webhook receiver shutdown: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("Webhook receiver listening on %s\n", addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("Webhook receiver stopped: %v\n", err)
+	}
+}
+
+func newWebhookServer(api *slack.Client) (*webhookServer, error) {
+	s := &webhookServer{
+		api:      api,
+		token:    os.Getenv("WEBHOOK_TOKEN"),
+		secret:   os.Getenv("WEBHOOK_SECRET"),
+		channels: make(map[string]string),
+		users:    make(map[string]string),
+	}
+	if s.token == "" && s.secret == "" {
+		return nil, fmt.Errorf("set WEBHOOK_TOKEN or WEBHOOK_SECRET, refusing to accept unauthenticated alerts")
+	}
+
+	config, err := loadAlertsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.DefaultChannel == "" && len(config.Routes) == 0 {
+		return nil, fmt.Errorf("no default_channel or routes in %s", alertsConfigFile)
+	}
+	s.config = config
+
+	if s.window, err = time.ParseDuration(config.DedupeWindow); err != nil {
+		return nil, fmt.Errorf("bad dedupe_window: %v", err)
+	}
+
+	if s.renderer, err = newAlertRenderer(config); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *webhookServer) authorized(r *http.Request, body []byte) bool {
+	if s.token != "" {
+		auth := r.Header.Get("Authorization")
+		if hmac.Equal([]byte(auth), []byte("Bearer "+s.token)) {
+			return true
+		}
+	}
+
+	if s.secret != "" {
+		signature := strings.TrimPrefix(r.Header.Get("X-Signature-256"), "sha256=")
+		expected, err := hex.DecodeString(signature)
+		if err == nil {
+			mac := hmac.New(sha256.New, []byte(s.secret))
+			mac.Write(body)
+			if hmac.Equal(expected, mac.Sum(nil)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *webhookServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorized(r, body) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parsed, err := parseAlerts(body)
+	if err != nil {
+		http.Error(w, "bad payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	posted, failed := 0, 0
+	for _, alert := range parsed {
+		ok, err := s.deliver(alert)
+		if err != nil {
+			fmt.Printf("failed delivering alert %s: %v\n", alert.Fingerprint, err)
+			failed++
+		} else if ok {
+			posted++
+		}
+	}
+
+	status := http.StatusAccepted
+	if failed > 0 {
+		status = http.StatusBadGateway
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]int{"received": len(parsed), "posted": posted, "failed": failed})
+}
+
+func (s *webhookServer) resolveChannel(ref string) (string, error) {
+	s.channelsMutex.Lock()
+	defer s.channelsMutex.Unlock()
+
+	if id, ok := s.channels[ref]; ok {
+		return id, nil
+	}
+	id, err := resolveChannel(s.api, ref)
+	if err != nil {
+		return "", err
+	}
+	s.channels[ref] = id
+	return id, nil
+}
+
+// deliver posts, threads or drops an alert. It reports whether anything was
+// posted. The state lock is only held to read and update the record, never
+// across Slack calls.
+func (s *webhookServer) deliver(alert Alert) (bool, error) {
+	now := time.Now()
+
+	alertState.lock()
+	alertState.prune(now)
+	record, known := alertState.Records[alert.Fingerprint]
+
+	if !known && alert.Status == "resolved" {
+		// Resolved before we saw it fire, or after we forgot it.
+		alertState.mu.Unlock()
+		fmt.Printf("dropping resolved alert %s (%q), it isn't known\n", alert.Fingerprint, alert.Title)
+		return false, nil
+	}
+
+	// A new alert, or one firing again after it was resolved: new top-level message.
+	if !known || (record.Alert.Status == "resolved" && alert.Status == "firing") {
+		ref, escalation := s.config.route(alert)
+		if ref == "" {
+			alertState.mu.Unlock()
+			return false, fmt.Errorf("no route for alert %q", alert.Title)
+		}
+
+		// Claim the fingerprint, so repeats arriving while we post are
+		// deduplicated instead of posting again.
+		record = &AlertRecord{
+			Alert:     alert,
+			FirstSeen: now, LastSeen: now, LastPost: now, Count: 1,
+			Escalation: escalation,
+		}
+		previous := alertState.Records[alert.Fingerprint]
+		alertState.Records[alert.Fingerprint] = record
+		posted := *record
+		alertState.mu.Unlock()
+
+		channelID, ts, err := s.postAlert(ref, &posted)
+
+		alertState.lock()
+		if err != nil {
+			if alertState.Records[alert.Fingerprint] == record {
+				if previous != nil {
+					alertState.Records[alert.Fingerprint] = previous
+				} else {
+					delete(alertState.Records, alert.Fingerprint)
+				}
+			}
+			alertState.mu.Unlock()
+			return false, err
+		}
+		record.Channel, record.TS = channelID, ts
+		alertState.save()
+		changed := record.Alert.Status != posted.Alert.Status
+		snapshot := *record
+		alertState.mu.Unlock()
+
+		if changed {
+			// It resolved while we were posting.
+			s.updateAlertMessage(&snapshot)
+		}
+		return true, nil
+	}
+
+	record.LastSeen = now
+	record.Count++
+
+	switch {
+	case record.TS == "":
+		// The first message is still being posted, it picks up the status.
+		record.Alert = alert
+		if alert.Status == "resolved" {
+			record.ResolvedAt = now
+		}
+		alertState.save()
+		alertState.mu.Unlock()
+		return false, nil
+
+	case alert.Status == "resolved" && record.Alert.Status == "resolved":
+		// Already resolved, nothing new to say.
+		alertState.save()
+		alertState.mu.Unlock()
+		return false, nil
+
+	case alert.Status == "firing" && now.Sub(record.LastPost) < s.window:
+		// A repeat inside the dedupe window, or while the first post is in flight.
+		alertState.save()
+		alertState.mu.Unlock()
+		return false, nil
+	}
+
+	reply := fmt.Sprintf(":red_circle: Still firing (%d notifications since %s)", record.Count, slackDate(record.FirstSeen, record.FirstSeen.Format(time.RFC1123)))
+	if alert.Status == "resolved" {
+		reply = ":large_green_circle: Resolved"
+	}
+
+	lastPost := record.LastPost
+	record.Alert = alert
+	record.LastPost = now
+	if alert.Status == "resolved" {
+		record.ResolvedAt = now
+	}
+	alertState.save()
+	snapshot := *record
+	alertState.mu.Unlock()
+
+	_, _, err := s.api.PostMessage(snapshot.Channel, slack.MsgOptionText(reply, false), slack.MsgOptionTS(snapshot.TS))
+	if err != nil {
+		// Let the next notification try again.
+		alertState.lock()
+		if record.LastPost == now {
+			record.LastPost = lastPost
+			alertState.save()
+		}
+		alertState.mu.Unlock()
+		return false, err
+	}
+
+	if alert.Status == "resolved" {
+		// Flip the original message to resolved as well.
+		s.updateAlertMessage(&snapshot)
+	}
+
+	return true, nil
+}
+
+// postAlert posts a new alert's top-level message to the routed channel.
+func (s *webhookServer) postAlert(ref string, record *AlertRecord) (string, string, error) {
+	channelID, err := s.resolveChannel(ref)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, ts, err := s.api.PostMessage(channelID,
+		slack.MsgOptionText(s.renderer.execute(s.renderer.title, record.Alert), false),
+		slack.MsgOptionBlocks(s.renderer.blocks(record)...))
+	return channelID, ts, err
+}
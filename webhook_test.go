@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// fakeSlack records the Web API calls made through its client and answers
+// them with just enough for the bot to carry on.
+type fakeSlack struct {
+	mu    sync.Mutex
+	calls []fakeSlackCall
+	// dmUsers is the user of each DM channel, for conversations.info.
+	dmUsers map[string]string
+}
+
+type fakeSlackCall struct {
+	method string
+	form   url.Values
+}
+
+func newFakeSlack(t *testing.T) (*slack.Client, *fakeSlack) {
+	t.Helper()
+	f := &fakeSlack{dmUsers: make(map[string]string)}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := strings.TrimPrefix(r.URL.Path, "/")
+
+		f.mu.Lock()
+		f.calls = append(f.calls, fakeSlackCall{method, r.Form})
+		n := len(f.calls)
+		user := f.dmUsers[r.Form.Get("channel")]
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch method {
+		case "chat.postMessage":
+			fmt.Fprintf(w, `{"ok":true,"channel":%q,"ts":"1700000000.%06d"}`, r.Form.Get("channel"), n)
+		case "conversations.open":
+			fmt.Fprintf(w, `{"ok":true,"channel":{"id":"D%s"}}`, strings.TrimPrefix(r.Form.Get("users"), "U"))
+		case "conversations.info":
+			fmt.Fprintf(w, `{"ok":true,"channel":{"id":%q,"is_im":%t,"user":%q}}`, r.Form.Get("channel"), user != "", user)
+		case "chat.getPermalink":
+			fmt.Fprint(w, `{"ok":true,"permalink":"https://example.slack.com/archives/C1/p1"}`)
+		default:
+			fmt.Fprint(w, `{"ok":true}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/")), f
+}
+
+// texts returns the text of each call to method, in order.
+func (f *fakeSlack) texts(method string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.calls {
+		if c.method == method {
+			texts = append(texts, c.form.Get("text"))
+		}
+	}
+	return texts
+}
+
+// newTestWebhookServer returns a receiver posting to fake Slack, with fresh
+// alert state.
+func newTestWebhookServer(t *testing.T) (*webhookServer, *fakeSlack) {
+	t.Helper()
+	cfg.DataDir = t.TempDir()
+	alertState = &alertStore{Records: make(map[string]*AlertRecord)}
+	t.Setenv("WEBHOOK_TOKEN", "s3cret")
+	t.Setenv("ALERTS_CHANNEL", "C0000ALERTS")
+
+	api, fake := newFakeSlack(t)
+	s, err := newWebhookServer(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+func TestParseAlerts(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []Alert
+		wantErr bool
+	}{
+		{
+			name: "Alertmanager",
+			body: `{"version":"4","status":"firing","alerts":[
+				{"status":"firing","fingerprint":"abc123","labels":{"alertname":"HighLatency","severity":"critical","team":"payments"},
+				 "annotations":{"summary":"p99 over 2s","description":"checkout is slow"},"generatorURL":"http://prom/graph"},
+				{"status":"resolved","fingerprint":"def456","labels":{"alertname":"DiskFull"},"annotations":{}}]}`,
+			want: []Alert{
+				{Fingerprint: "abc123", Status: "firing", Title: "p99 over 2s", Text: "checkout is slow", Severity: "critical", URL: "http://prom/graph"},
+				{Fingerprint: "def456", Status: "resolved", Title: "DiskFull"},
+			},
+		},
+		{
+			name: "Grafana",
+			body: `{"receiver":"slack","status":"firing","alerts":[
+				{"status":"Firing","labels":{"alertname":"CPU","grafana_folder":"infra"},
+				 "annotations":{"message":"CPU at 95%"},"panelURL":"http://grafana/d/1?viewPanel=2","generatorURL":"http://grafana/alerting"}]}`,
+			want: []Alert{
+				{Status: "firing", Title: "CPU", Text: "CPU at 95%", URL: "http://grafana/d/1?viewPanel=2"},
+			},
+		},
+		{
+			name: "generic",
+			body: `{"title":"Backup failed","text":"nightly backup exited 1","severity":"warning","status":"firing"}`,
+			want: []Alert{{Status: "firing", Title: "Backup failed", Text: "nightly backup exited 1", Severity: "warning"}},
+		},
+		{
+			name: "generic without a status",
+			body: `{"text":"something happened"}`,
+			want: []Alert{{Status: "firing", Title: "Alert", Text: "something happened"}},
+		},
+		{name: "empty generic", body: `{"severity":"critical"}`, wantErr: true},
+		{name: "not JSON", body: `alert!`, wantErr: true},
+		{name: "alerts not a list", body: `{"alerts":"none"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAlerts([]byte(tt.body))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d alerts, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			a := got[i]
+			if want.Fingerprint != "" && a.Fingerprint != want.Fingerprint {
+				t.Errorf("%s[%d]: fingerprint %q, want %q", tt.name, i, a.Fingerprint, want.Fingerprint)
+			}
+			if a.Fingerprint == "" || a.StartsAt.IsZero() {
+				t.Errorf("%s[%d]: not normalized: %+v", tt.name, i, a)
+			}
+			if a.Status != want.Status || a.Title != want.Title || a.Text != want.Text || a.Severity != want.Severity || a.URL != want.URL {
+				t.Errorf("%s[%d]: got %+v, want %+v", tt.name, i, a, want)
+			}
+		}
+	}
+}
+
+func TestAlertFingerprint(t *testing.T) {
+	a := Alert{Title: "Disk full", Labels: map[string]string{"host": "db1", "mount": "/var"}}
+	b := Alert{Title: "Disk full", Labels: map[string]string{"mount": "/var", "host": "db1"}, Status: "resolved", Text: "back to 60%"}
+	if alertFingerprint(a) != alertFingerprint(b) {
+		t.Error("the same alert with other text or status got a different fingerprint")
+	}
+
+	for _, other := range []Alert{
+		{Title: "Disk full", Labels: map[string]string{"host": "db2", "mount": "/var"}},
+		{Title: "Disk nearly full", Labels: a.Labels},
+		{Title: "Disk full", Labels: map[string]string{"host": "db1"}},
+	} {
+		if alertFingerprint(a) == alertFingerprint(other) {
+			t.Errorf("%+v shares a fingerprint with %+v", other, a)
+		}
+	}
+}
+
+func TestWebhookAuthorized(t *testing.T) {
+	body := []byte(`{"title":"test"}`)
+	mac := hmac.New(sha256.New, []byte("hmac-secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name          string
+		token, secret string
+		header, value string
+		want          bool
+	}{
+		{"token", "s3cret", "", "Authorization", "Bearer s3cret", true},
+		{"wrong token", "s3cret", "", "Authorization", "Bearer nope", false},
+		{"token without Bearer", "s3cret", "", "Authorization", "s3cret", false},
+		{"no credentials", "s3cret", "hmac-secret", "", "", false},
+		{"signature", "", "hmac-secret", "X-Signature-256", signature, true},
+		{"signature without prefix", "", "hmac-secret", "X-Signature-256", strings.TrimPrefix(signature, "sha256="), true},
+		{"wrong signature", "", "hmac-secret", "X-Signature-256", "sha256=" + strings.Repeat("0", 64), false},
+		{"garbage signature", "", "hmac-secret", "X-Signature-256", "sha256=zz", false},
+		{"signature for another secret", "", "other", "X-Signature-256", signature, false},
+		{"token when only a secret is set", "", "hmac-secret", "Authorization", "Bearer ", false},
+		{"signature when only a token is set", "s3cret", "", "X-Signature-256", signature, false},
+	}
+
+	for _, tt := range tests {
+		s := &webhookServer{token: tt.token, secret: tt.secret}
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := s.authorized(r, body); got != tt.want {
+			t.Errorf("%s: authorized = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleWebhookRejectsUnauthorized(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleWebhook(w, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"title":"test"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if posts := fake.texts("chat.postMessage"); len(posts) != 0 {
+		t.Errorf("posted %q for an unauthorized request", posts)
+	}
+}
+
+func TestDeliverDedupes(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+	firing := normalizeAlert(Alert{Title: "Disk full", Labels: map[string]string{"host": "db1"}})
+	var firstTS string
+	resolved := firing
+	resolved.Status = "resolved"
+
+	steps := []struct {
+		name   string
+		alert  Alert
+		posted bool
+		posts  int // chat.postMessage calls so far
+	}{
+		{"first", firing, true, 1},
+		{"repeat inside the window", firing, false, 1},
+		{"another repeat", firing, false, 1},
+		{"resolved", resolved, true, 2},
+		{"resolved again", resolved, false, 2},
+		{"firing again", firing, true, 3},
+	}
+	for _, step := range steps {
+		posted, err := s.deliver(step.alert)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if posted != step.posted {
+			t.Errorf("%s: posted = %v, want %v", step.name, posted, step.posted)
+		}
+		if n := len(fake.texts("chat.postMessage")); n != step.posts {
+			t.Errorf("%s: %d messages posted, want %d", step.name, n, step.posts)
+		}
+		if firstTS == "" {
+			firstTS = alertState.Records[firing.Fingerprint].TS
+		}
+	}
+
+	posts := fake.texts("chat.postMessage")
+	if !strings.Contains(posts[1], "Resolved") {
+		t.Errorf("resolution posted %q", posts[1])
+	}
+	// Firing after a resolution starts a new message, counting from one.
+	if rec := alertState.Records[firing.Fingerprint]; rec.Count != 1 || rec.Alert.Status != "firing" || rec.TS == firstTS {
+		t.Errorf("record after firing again = %+v", rec)
+	}
+}
+
+func TestDeliverDropsUnknownResolved(t *testing.T) {
+	s, fake := newTestWebhookServer(t)
+	resolved := normalizeAlert(Alert{Title: "Never seen firing", Status: "resolved"})
+
+	posted, err := s.deliver(resolved)
+	if err != nil || posted {
+		t.Errorf("deliver = %v, %v, want nothing posted", posted, err)
+	}
+	if posts := fake.texts("chat.postMessage"); len(posts) != 0 {
+		t.Errorf("posted %q for an alert that never fired", posts)
+	}
+	if _, ok := alertState.Records[resolved.Fingerprint]; ok {
+		t.Error("the unknown resolved alert was recorded")
+	}
+}
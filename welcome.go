@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Welcoming people who join a channel.
+//
+// Each channel can have a welcome message, shown only to the person who
+// joined or sent as a DM. It's a text/template with {{.User}}, {{.Channel}},
+// {{.Purpose}}, {{.Topic}} and {{.Pins}} (the channel's pinned links),
+// followed by a checklist with a Done button per item and the related
+// channels, which the person can also be invited to straight away. Admins
+// edit it with /welcome, anyone can see it with /welcome preview.
+
+const (
+	welcomeFile        = "welcome.json"
+	welcomeModalID     = "welcome_modal"
+	welcomeCheckAction = "welcome_check"
+
+	welcomeEphemeral = "ephemeral"
+	welcomeDM        = "dm"
+	welcomeOff       = "off"
+
+	// How many pinned items the welcome links to.
+	maxWelcomePins = 5
+)
+
+const defaultWelcomeTemplate = `Welcome to {{.Channel}}, {{.User}}! :wave:
+{{if .Purpose}}*What this channel is for:* {{.Purpose}}
+{{end}}{{if .Pins}}*Worth reading first:*
+{{.Pins}}{{end}}`
+
+type WelcomeConfig struct {
+	Delivery   string    `json:"delivery"` // ephemeral, dm or off
+	Template   string    `json:"template"`
+	Checklist  []string  `json:"checklist,omitempty"`
+	Related    []string  `json:"related,omitempty"`
+	AutoInvite bool      `json:"auto_invite,omitempty"`
+	UpdatedBy  string    `json:"updated_by,omitempty"`
+	Updated    time.Time `json:"updated,omitempty"`
+}
+
+type welcomeStore struct {
+	jsonStore
+	Channels map[string]*WelcomeConfig `json:"channels"`
+	// Progress is the checklist items each person has done, by "channel/user".
+	Progress map[string][]int `json:"progress"`
+}
+
+var welcomes = &welcomeStore{}
+
+// lock loads the store on first use and locks it.
+func (s *welcomeStore) lock() {
+	s.lockJSON(welcomeFile, "welcome messages", s, func() {
+		if s.Channels == nil {
+			s.Channels = make(map[string]*WelcomeConfig)
+		}
+		if s.Progress == nil {
+			s.Progress = make(map[string][]int)
+		}
+	})
+}
+
+// save must be called with s.mu held.
+func (s *welcomeStore) save() {
+	s.saveLocked(welcomeFile, "welcome messages", s)
+}
+
+// welcomeConfig returns a copy of the channel's welcome, nil if it has none.
+func welcomeConfig(channelID string) *WelcomeConfig {
+	welcomes.lock()
+	defer welcomes.mu.Unlock()
+	c, ok := welcomes.Channels[channelID]
+	if !ok {
+		return nil
+	}
+	copied := *c
+	return &copied
+}
+
+var (
+	botUserOnce sync.Once
+	botUser     string
+)
+
+// botUserID returns the bot's own user ID.
+func botUserID(api *slack.Client) string {
+	botUserOnce.Do(func() {
+		auth, err := api.AuthTest()
+		if err != nil {
+			fmt.Printf("failed looking up the bot user: %v\n", err)
+			return
+		}
+		botUser = auth.UserID
+	})
+	return botUser
+}
+
+//---
+
+// pinnedLinks lists the channel's pinned messages and files as links.
+func pinnedLinks(api *slack.Client, channelID string) string {
+	items, _, err := api.ListPins(channelID)
+	if err != nil {
+		fmt.Printf("failed listing pins in %s: %v\n", channelID, err)
+		return ""
+	}
+
+	var lines []string
+	for _, item := range items {
+		if len(lines) == maxWelcomePins {
+			break
+		}
+		switch {
+		case item.Message != nil:
+			link := item.Message.Permalink
+			if link == "" {
+				link, _ = api.GetPermalink(&slack.PermalinkParameters{Channel: channelID, Ts: item.Message.Timestamp})
+			}
+			title := strings.SplitN(strings.TrimSpace(item.Message.Text), "\n", 2)[0]
+			if link != "" && title != "" {
+				lines = append(lines, fmt.Sprintf("• <%s|%s>", link, truncate(strings.NewReplacer("<", "", ">", "", "|", "").Replace(title), 80)))
+			}
+		case item.File != nil && item.File.Permalink != "":
+			title := item.File.Title
+			if title == "" {
+				title = item.File.Name
+			}
+			lines = append(lines, fmt.Sprintf("• <%s|%s>", item.File.Permalink, title))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// welcomeData is what a welcome template can use.
+type welcomeData struct{ User, Channel, Purpose, Topic, Pins string }
+
+// checkWelcomeTemplate runs the template against sample data, so mistakes
+// like an unknown field show up when it's saved rather than at every join.
+func checkWelcomeTemplate(tmpl string) error {
+	t, err := template.New("welcome").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(io.Discard, welcomeData{
+		User:    "<@U0000000000>",
+		Channel: "<#C0000000000>",
+		Purpose: "Talk about things",
+		Topic:   "Things",
+		Pins:    "• <https://example.slack.com/archives/C0000000000/p1|The handbook>\n",
+	})
+}
+
+// renderWelcome fills in the welcome text for a person.
+func renderWelcome(api *slack.Client, tmpl, userID, channelID string) (string, error) {
+	t, err := template.New("welcome").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	data := welcomeData{
+		User:    "<@" + userID + ">",
+		Channel: "<#" + channelID + ">",
+		Pins:    pinnedLinks(api, channelID),
+	}
+	if channel, err := api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channelID}); err == nil {
+		data.Purpose = channel.Purpose.Value
+		data.Topic = channel.Topic.Value
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// welcomeBlocks builds the welcome message: the text, the checklist with
+// what's done so far, and the related channels.
+func welcomeBlocks(c *WelcomeConfig, text, channelID string, done []int, invited []string) []slack.Block {
+	markdown := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, s, false, false)
+	}
+
+	blocks := []slack.Block{slack.NewSectionBlock(markdown(truncate(text, 3000)), nil, nil)}
+
+	if len(c.Checklist) > 0 {
+		blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(markdown("*Getting started*"), nil, nil))
+		for i, item := range c.Checklist {
+			if containsInt(done, i) {
+				blocks = append(blocks, slack.NewSectionBlock(markdown(":white_check_mark: ~"+item+"~"), nil, nil))
+				continue
+			}
+			button := slack.NewButtonBlockElement(welcomeCheckAction, channelID+"|"+strconv.Itoa(i),
+				slack.NewTextBlockObject(slack.PlainTextType, "Done", false, false))
+			blocks = append(blocks, slack.NewSectionBlock(markdown(":white_large_square: "+item), nil, slack.NewAccessory(button)))
+		}
+		if len(done) == len(c.Checklist) {
+			blocks = append(blocks, slack.NewContextBlock("", markdown("All done, welcome aboard! :tada:")))
+		}
+	}
+
+	if len(c.Related) > 0 {
+		links := make([]string, len(c.Related))
+		for i, id := range c.Related {
+			links[i] = "<#" + id + ">"
+		}
+		text := "Related channels: " + strings.Join(links, ", ")
+		if len(invited) > 0 {
+			links = links[:0]
+			for _, id := range invited {
+				links = append(links, "<#"+id+">")
+			}
+			text = "I've also added you to " + strings.Join(links, ", ") + "."
+		}
+		blocks = append(blocks, slack.NewContextBlock("", markdown(text)))
+	}
+	return blocks
+}
+
+func containsInt(list []int, n int) bool {
+	for _, x := range list {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
+
+// sendWelcome delivers a channel's welcome to userID, as configured or, for
+// a preview, as an ephemeral message in the channel it was asked for in.
+func sendWelcome(api *slack.Client, c *WelcomeConfig, userID, channelID, previewIn string) error {
+	text, err := renderWelcome(api, c.Template, userID, channelID)
+	if err != nil {
+		return err
+	}
+
+	var invited []string
+	if c.AutoInvite && previewIn == "" {
+		for _, related := range c.Related {
+			_, err := api.InviteUsersToConversation(related, userID)
+			if err != nil && !strings.Contains(err.Error(), "already_in_channel") {
+				fmt.Printf("failed inviting %s to %s: %v\n", userID, related, err)
+				continue
+			}
+			invited = append(invited, related)
+		}
+	}
+
+	welcomes.lock()
+	done := welcomes.Progress[channelID+"/"+userID]
+	welcomes.mu.Unlock()
+
+	options := []slack.MsgOption{
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(welcomeBlocks(c, text, channelID, done, invited)...),
+	}
+
+	switch {
+	case previewIn != "":
+		_, err = api.PostEphemeral(previewIn, userID, options...)
+	case c.Delivery == welcomeDM:
+		var dm string
+		if dm, err = openDirectMessage(api, userID); err == nil {
+			_, _, err = api.PostMessage(dm, options...)
+		}
+	default:
+		_, err = api.PostEphemeral(channelID, userID, options...)
+	}
+	return err
+}
+
+// handleMemberJoined welcomes someone who joined a channel with a welcome.
+func handleMemberJoined(ev *slackevents.MemberJoinedChannelEvent, client *socketmode.Client) {
+	api := &client.Client
+
+	c := welcomeConfig(ev.Channel)
+	if c == nil || c.Delivery == welcomeOff || ev.User == botUserID(api) {
+		return
+	}
+	if err := sendWelcome(api, c, ev.User, ev.Channel, ""); err != nil {
+		fmt.Printf("failed welcoming %s to %s: %v\n", ev.User, ev.Channel, err)
+	}
+}
+
+// handleWelcomeCheck ticks off a checklist item and redraws the welcome.
+func handleWelcomeCheck(callback slack.InteractionCallback, action *slack.BlockAction, client *socketmode.Client) {
+	api := &client.Client
+	userID := callback.User.ID
+
+	channelID, index, _ := strings.Cut(action.Value, "|")
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return
+	}
+
+	c := welcomeConfig(channelID)
+	if c == nil || i >= len(c.Checklist) {
+		return
+	}
+
+	key := channelID + "/" + userID
+	welcomes.lock()
+	if !containsInt(welcomes.Progress[key], i) {
+		welcomes.Progress[key] = append(welcomes.Progress[key], i)
+		welcomes.save()
+	}
+	done := welcomes.Progress[key]
+	welcomes.mu.Unlock()
+
+	text, err := renderWelcome(api, c.Template, userID, channelID)
+	if err != nil {
+		fmt.Printf("failed rendering welcome: %v\n", err)
+		return
+	}
+
+	// The response URL replaces ephemeral messages and DMs alike.
+	blocks := welcomeBlocks(c, text, channelID, done, nil)
+	err = slack.PostWebhook(callback.ResponseURL, &slack.WebhookMessage{
+		Text:            text,
+		Blocks:          &slack.Blocks{BlockSet: blocks},
+		ReplaceOriginal: true,
+	})
+	if err != nil {
+		fmt.Printf("failed updating welcome: %v\n", err)
+	}
+}
+
+//---
+
+// welcomeModal is the editor for a channel's welcome, with a preview.
+func welcomeModal(c *WelcomeConfig, channelID, preview string) slack.ModalViewRequest {
+	plain := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+
+	deliveries := []*slack.OptionBlockObject{
+		slack.NewOptionBlockObject(welcomeEphemeral, plain("In the channel, only they see it"), nil),
+		slack.NewOptionBlockObject(welcomeDM, plain("As a direct message"), nil),
+		slack.NewOptionBlockObject(welcomeOff, plain("Off"), nil),
+	}
+	delivery := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, "value", deliveries...)
+	for _, o := range deliveries {
+		if o.Value == c.Delivery {
+			delivery.InitialOption = o
+		}
+	}
+
+	text := slack.NewPlainTextInputBlockElement(nil, "value")
+	text.Multiline = true
+	text.InitialValue = c.Template
+
+	checklist := slack.NewPlainTextInputBlockElement(plain("One item per line"), "value")
+	checklist.Multiline = true
+	checklist.InitialValue = strings.Join(c.Checklist, "\n")
+
+	related := slack.NewOptionsMultiSelectBlockElement(slack.MultiOptTypeChannels, plain("Pick channels"), "value")
+	related.InitialChannels = c.Related
+
+	inviteOption := slack.NewOptionBlockObject("invite", plain("Invite them to the related channels too"), nil)
+	invite := slack.NewCheckboxGroupsBlockElement("value", inviteOption)
+	if c.AutoInvite {
+		invite.InitialOptions = []*slack.OptionBlockObject{inviteOption}
+	}
+
+	optional := func(b *slack.InputBlock) *slack.InputBlock {
+		b.Optional = true
+		return b
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "*Preview*\n"+truncate(preview, 2900), false, false), nil, nil),
+		slack.NewDividerBlock(),
+		slack.NewInputBlock("welcome_delivery", plain("Send it"), nil, delivery),
+		slack.NewInputBlock("welcome_template", plain("Message"),
+			plain("Use {{.User}}, {{.Channel}}, {{.Purpose}}, {{.Topic}} and {{.Pins}}"), text),
+		optional(slack.NewInputBlock("welcome_checklist", plain("Checklist"), nil, checklist)),
+		optional(slack.NewInputBlock("welcome_related", plain("Related channels"), nil, related)),
+		optional(slack.NewInputBlock("welcome_invite", plain("Invites"), nil, invite)),
+	}
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      welcomeModalID,
+		Title:           plain("Welcome message"),
+		Submit:          plain("Save"),
+		Close:           plain("Cancel"),
+		Blocks:          slack.Blocks{BlockSet: blocks},
+		PrivateMetadata: channelID,
+	}
+}
+
+// handleWelcomeSubmission saves the welcome from the modal. It returns the
+// response for Slack: field errors keep the modal open, nil closes it.
+func handleWelcomeSubmission(callback slack.InteractionCallback, client *socketmode.Client) *slack.ViewSubmissionResponse {
+	values := callback.View.State.Values
+	channelID := callback.View.PrivateMetadata
+	userID := callback.User.ID
+
+	if !isAdmin(userID) {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{"welcome_template": "Only bot admins can change the welcome."})
+	}
+
+	c := &WelcomeConfig{
+		Delivery:   values["welcome_delivery"]["value"].SelectedOption.Value,
+		Template:   values["welcome_template"]["value"].Value,
+		Related:    values["welcome_related"]["value"].SelectedChannels,
+		AutoInvite: len(values["welcome_invite"]["value"].SelectedOptions) > 0,
+		UpdatedBy:  userID,
+		Updated:    time.Now(),
+	}
+	for _, line := range strings.Split(values["welcome_checklist"]["value"].Value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			c.Checklist = append(c.Checklist, line)
+		}
+	}
+
+	if err := checkWelcomeTemplate(c.Template); err != nil {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{"welcome_template": truncate(err.Error(), 150)})
+	}
+
+	welcomes.lock()
+	// Checklist progress refers to the old items by position.
+	if old, ok := welcomes.Channels[channelID]; ok && strings.Join(old.Checklist, "\n") != strings.Join(c.Checklist, "\n") {
+		for key := range welcomes.Progress {
+			if strings.HasPrefix(key, channelID+"/") {
+				delete(welcomes.Progress, key)
+			}
+		}
+	}
+	welcomes.Channels[channelID] = c
+	welcomes.save()
+	welcomes.mu.Unlock()
+
+	// Show the admin what people will get.
+	go func() {
+		if err := sendWelcome(&client.Client, c, userID, channelID, channelID); err != nil {
+			fmt.Printf("failed previewing welcome: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// handleWelcomeCommand handles /welcome: admins get the editor, everyone
+// else and "/welcome preview" get the welcome as it would be sent to them.
+func handleWelcomeCommand(evt *socketmode.Event, client *socketmode.Client) {
+
+	if evt == nil || evt.Request == nil {
+		fmt.Println("Received nil event or request. handleWelcomeCommand Skipping...")
+		return
+	}
+
+	cmd := evt.Data.(slack.SlashCommand)
+	api := &client.Client
+
+	reply := func(text string) {
+		client.Ack(*evt.Request, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          text,
+		})
+	}
+
+	if strings.HasPrefix(cmd.ChannelID, "D") {
+		reply("Run /welcome in the channel whose welcome you want to see or change.")
+		return
+	}
+
+	if isAdmin(cmd.UserID) && strings.TrimSpace(cmd.Text) != "preview" {
+		client.Ack(*evt.Request)
+		c := welcomeConfig(cmd.ChannelID)
+		if c == nil {
+			c = &WelcomeConfig{Delivery: welcomeOff, Template: defaultWelcomeTemplate}
+		}
+
+		// The trigger expires in 3 seconds, open the editor before rendering
+		// the preview, which looks up the channel and its pins.
+		view, err := api.OpenView(cmd.TriggerID, welcomeModal(c, cmd.ChannelID, "_Loading…_"))
+		if err != nil {
+			fmt.Printf("failed opening welcome editor: %v\n", err)
+			return
+		}
+		preview, err := renderWelcome(api, c.Template, cmd.UserID, cmd.ChannelID)
+		if err != nil {
+			preview = "The template doesn't work: " + err.Error()
+		}
+		if _, err := api.UpdateView(welcomeModal(c, cmd.ChannelID, preview), "", view.Hash, view.ID); err != nil {
+			fmt.Printf("failed updating welcome editor: %v\n", err)
+		}
+		return
+	}
+
+	c := welcomeConfig(cmd.ChannelID)
+	if c == nil || c.Delivery == welcomeOff {
+		reply("This channel doesn't welcome new members. A bot admin can set it up with /welcome.")
+		return
+	}
+	client.Ack(*evt.Request)
+	if err := sendWelcome(api, c, cmd.UserID, cmd.ChannelID, cmd.ChannelID); err != nil {
+		fmt.Printf("failed previewing welcome: %v\n", err)
+	}
+}
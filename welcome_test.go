@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestCheckWelcomeTemplate(t *testing.T) {
+	tests := map[string]bool{
+		defaultWelcomeTemplate:                  true,
+		"Hi {{.User}}, see {{.Pins}}":           true,
+		"{{if .Topic}}Topic: {{.Topic}}{{end}}": true,
+		"Hi {{.Name}}":                          false,
+		"Hi {{.User":                            false,
+		"{{template \"missing\"}}":              false,
+	}
+	for tmpl, ok := range tests {
+		if err := checkWelcomeTemplate(tmpl); (err == nil) != ok {
+			t.Errorf("checkWelcomeTemplate(%q) = %v, want ok %v", tmpl, err, ok)
+		}
+	}
+}